@@ -0,0 +1,377 @@
+package bus
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/marketdata"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/resultcode"
+)
+
+// TODO persistent subscribers (resume from a journal seq)
+
+var (
+	ErrClosed           = errors.New("bus closed")
+	ErrDisconnected     = errors.New("subscriber disconnected")
+	ErrSubscriberExists = errors.New("subscriber exists")
+	ErrInvalidCapacity  = errors.New("invalid capacity")
+)
+
+// What the bus does when a subscriber falls a whole ring behind.
+type Policy int8
+
+const (
+	// The publisher (and so the engine) waits until the subscriber catches up.
+	Block Policy = iota + 1
+
+	// The subscriber skips the oldest envelopes, `Dropped` counts them.
+	DropOldest
+
+	// The subscriber is removed, further polls return `ErrDisconnected`.
+	Disconnect
+)
+
+type takerCommand interface {
+	OrderID() int64
+	UserID() int64
+}
+
+type symbolCommand interface {
+	SymbolID() int32
+}
+
+/*
+ * Result of one command with the context of the command.
+ * Taker fields are set for order commands only.
+ * Events must not be modified by subscribers.
+ */
+type Envelope struct {
+	seq          int64
+	timestampNS  int64
+	commandCode  int8
+	symbolID     int32 // 0 if none
+	takerOrderID int64 // 0 if none
+	takerUserID  int64 // 0 if none
+	code         resultcode.ResultCode
+	codes        []resultcode.ResultCode
+	head         event.Event
+	l2Updates    []*marketdata.Update // one per changed symbol
+	l2Snapshots  []*marketdata.Snapshot
+	l3Updates    []*marketdata.L3Update // one per changed symbol
+	_            struct{}
+}
+
+func NewEnvelope(
+	command cmd.Command,
+	res *orderbook.MatcherResult,
+) *Envelope {
+	e := &Envelope{
+		seq:         command.Seq(),
+		timestampNS: command.TimestampNS(),
+		commandCode: command.Code(),
+		code:        res.Code,
+		codes:       res.Codes,
+		head:        res.Head,
+	}
+
+	if c, ok := command.(symbolCommand); ok {
+		e.symbolID = c.SymbolID()
+	}
+
+	if c, ok := command.(takerCommand); ok {
+		e.takerOrderID = c.OrderID()
+		e.takerUserID = c.UserID()
+	}
+
+	return e
+}
+
+func (e *Envelope) Seq() int64 {
+	return e.seq
+}
+
+func (e *Envelope) TimestampNS() int64 {
+	return e.timestampNS
+}
+
+func (e *Envelope) CommandCode() int8 {
+	return e.commandCode
+}
+
+func (e *Envelope) SymbolID() int32 {
+	return e.symbolID
+}
+
+func (e *Envelope) TakerOrderID() int64 {
+	return e.takerOrderID
+}
+
+func (e *Envelope) TakerUserID() int64 {
+	return e.takerUserID
+}
+
+func (e *Envelope) ResultCode() resultcode.ResultCode {
+	return e.code
+}
+
+func (e *Envelope) ResultCodes() []resultcode.ResultCode {
+	return e.codes
+}
+
+func (e *Envelope) Head() event.Event {
+	return e.head
+}
+
+func (e *Envelope) SetL2(
+	updates []*marketdata.Update,
+	snapshots []*marketdata.Snapshot,
+) {
+	e.l2Updates = updates
+	e.l2Snapshots = snapshots
+}
+
+func (e *Envelope) L2Updates() []*marketdata.Update {
+	return e.l2Updates
+}
+
+func (e *Envelope) L2Snapshots() []*marketdata.Snapshot {
+	return e.l2Snapshots
+}
+
+func (e *Envelope) SetL3(updates []*marketdata.L3Update) {
+	e.l3Updates = updates
+}
+
+func (e *Envelope) L3Updates() []*marketdata.L3Update {
+	return e.l3Updates
+}
+
+// Events of the chain in order.
+func (e *Envelope) Events() []event.Event {
+	if e.head == nil {
+		return nil
+	}
+
+	events := make([]event.Event, 0, e.head.ChainSize())
+
+	for ev := e.head; ev != nil; ev = ev.Next() {
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+/*
+ * Fan-out of command results to subscribers (trade feeds, risk,
+ * user notifications, persistence).
+ * Envelopes are kept in a ring, each subscriber reads
+ * with its own cursor and receives envelopes published after subscribing.
+ */
+type Bus struct {
+	ring        []*Envelope
+	published   int64 // number of published envelopes
+	subscribers map[string]*Subscriber
+	closed      bool
+	mu          sync.Mutex
+	cond        *sync.Cond
+	_           struct{}
+}
+
+// `capacity` is the size of the ring, it must be positive.
+func New(capacity int32) (*Bus, error) {
+	if capacity <= 0 {
+		return nil, ErrInvalidCapacity
+	}
+
+	b := &Bus{
+		ring:        make([]*Envelope, capacity),
+		subscribers: make(map[string]*Subscriber),
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b, nil
+}
+
+func (b *Bus) Subscribe(
+	name string,
+	policy Policy,
+) (*Subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	if _, ok := b.subscribers[name]; ok {
+		return nil, ErrSubscriberExists
+	}
+
+	s := &Subscriber{
+		name:   name,
+		policy: policy,
+		cursor: b.published,
+		bus:    b,
+	}
+	b.subscribers[name] = s
+
+	return s, nil
+}
+
+func (b *Bus) Unsubscribe(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.subscribers[name]; ok {
+		s.disconnected = true
+		delete(b.subscribers, name)
+		b.cond.Broadcast()
+	}
+}
+
+/*
+ * Applies the policy of each lagging subscriber, then appends the envelope.
+ * Blocks while a `Block` subscriber is a whole ring behind.
+ */
+func (b *Bus) Publish(e *Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	capacity := int64(len(b.ring))
+
+	for !b.closed && b.isBlocked() {
+		b.cond.Wait()
+	}
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	for name, s := range b.subscribers {
+		if b.published-s.cursor < capacity {
+			continue
+		}
+
+		switch s.policy {
+		case DropOldest:
+			s.cursor++
+			s.dropped++
+		case Disconnect:
+			s.disconnected = true
+			delete(b.subscribers, name)
+		}
+	}
+
+	b.ring[b.published%capacity] = e
+	b.published++
+	b.cond.Broadcast()
+
+	return nil
+}
+
+func (b *Bus) isBlocked() bool {
+	for _, s := range b.subscribers {
+		if s.policy == Block && b.published-s.cursor >= int64(len(b.ring)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wakes up the publisher and subscribers, pending envelopes can still be polled.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+type Subscriber struct {
+	name         string
+	policy       Policy
+	cursor       int64 // next envelope to read
+	dropped      int64
+	disconnected bool
+	bus          *Bus
+	_            struct{}
+}
+
+func (s *Subscriber) Name() string {
+	return s.name
+}
+
+func (s *Subscriber) Policy() Policy {
+	return s.policy
+}
+
+// Number of envelopes skipped by `DropOldest`.
+func (s *Subscriber) Dropped() int64 {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	return s.dropped
+}
+
+// Returns up to `max` pending envelopes without waiting.
+func (s *Subscriber) Poll(max int) ([]*Envelope, error) {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s.disconnected {
+		return nil, ErrDisconnected
+	}
+
+	return s.read(max), nil
+}
+
+// Waits for at least one envelope, returns `ErrClosed` when the bus is closed and drained.
+func (s *Subscriber) Next(max int) ([]*Envelope, error) {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !s.disconnected && !b.closed && s.cursor == b.published {
+		b.cond.Wait()
+	}
+
+	if s.disconnected {
+		return nil, ErrDisconnected
+	}
+
+	if s.cursor == b.published {
+		return nil, ErrClosed
+	}
+
+	return s.read(max), nil
+}
+
+// `bus.mu` must be held.
+func (s *Subscriber) read(max int) []*Envelope {
+	b := s.bus
+	capacity := int64(len(b.ring))
+	n := b.published - s.cursor
+
+	if int64(max) < n {
+		n = int64(max)
+	}
+
+	envelopes := make([]*Envelope, 0, n)
+
+	for ; n > 0; n-- {
+		envelopes = append(envelopes, b.ring[s.cursor%capacity])
+		s.cursor++
+	}
+
+	if len(envelopes) > 0 {
+		// a blocked publisher may continue
+		b.cond.Broadcast()
+	}
+
+	return envelopes
+}
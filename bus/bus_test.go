@@ -0,0 +1,204 @@
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/resultcode"
+)
+
+func envelope(seq int64) *Envelope {
+	return NewEnvelope(
+		&cmd.Reset{Metadata: cmd.Metadata{Seq: seq}},
+		&orderbook.MatcherResult{Code: resultcode.Success},
+	)
+}
+
+func publish(t *testing.T, b *Bus, from, to int64) {
+	t.Helper()
+
+	for seq := from; seq <= to; seq++ {
+		if err := b.Publish(envelope(seq)); err != nil {
+			t.Fatalf("publish %v: %v", seq, err)
+		}
+	}
+}
+
+func seqs(envelopes []*Envelope) []int64 {
+	out := make([]int64, 0, len(envelopes))
+
+	for _, e := range envelopes {
+		out = append(out, e.Seq())
+	}
+
+	return out
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestOrdering(t *testing.T) {
+	b, err := New(4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	early, _ := b.Subscribe("early", Block)
+	publish(t, b, 1, 2)
+	late, _ := b.Subscribe("late", Block)
+	publish(t, b, 3, 4)
+
+	tests := []struct {
+		subscriber *Subscriber
+		polls      [][]int64 // batches of up to 3 envelopes
+	}{
+		{
+			subscriber: early,
+			polls:      [][]int64{{1, 2, 3}, {4}, {}},
+		},
+		{
+			subscriber: late,
+			polls:      [][]int64{{3, 4}, {}},
+		},
+	}
+
+	for _, test := range tests {
+		for i, want := range test.polls {
+			envelopes, err := test.subscriber.Poll(3)
+
+			if err != nil {
+				t.Fatalf("%v poll %v: %v", test.subscriber.Name(), i, err)
+			}
+
+			if got := seqs(envelopes); !equal(got, want) {
+				t.Errorf("%v poll %v: seqs %v, want %v", test.subscriber.Name(), i, got, want)
+			}
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		want    []int64
+		dropped int64
+		err     error
+	}{
+		{
+			name:    "drop oldest",
+			policy:  DropOldest,
+			want:    []int64{3, 4, 5},
+			dropped: 2,
+		},
+		{
+			name:   "disconnect",
+			policy: Disconnect,
+			err:    ErrDisconnected,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := New(3)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, _ := b.Subscribe("lagging", test.policy)
+			publish(t, b, 1, 5)
+			envelopes, err := s.Poll(10)
+
+			if err != test.err {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+
+			if got := seqs(envelopes); !equal(got, test.want) {
+				t.Errorf("seqs %v, want %v", got, test.want)
+			}
+
+			if s.Dropped() != test.dropped {
+				t.Errorf("dropped %v, want %v", s.Dropped(), test.dropped)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	b, err := New(2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := b.Subscribe("slow", Block)
+	publish(t, b, 1, 2)
+	published := make(chan error)
+
+	go func() {
+		published <- b.Publish(envelope(3))
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("published into a full ring of a blocking subscriber")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if envelopes, err := s.Poll(1); err != nil || !equal(seqs(envelopes), []int64{1}) {
+		t.Fatalf("poll: %v, %v", seqs(envelopes), err)
+	}
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publisher not resumed")
+	}
+
+	if envelopes, err := s.Poll(10); err != nil || !equal(seqs(envelopes), []int64{2, 3}) {
+		t.Errorf("poll: %v, %v", seqs(envelopes), err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b, err := New(2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := b.Subscribe("reader", Block)
+	publish(t, b, 1, 1)
+	b.Close()
+
+	if err := b.Publish(envelope(2)); err != ErrClosed {
+		t.Errorf("publish after close: %v, want %v", err, ErrClosed)
+	}
+
+	// pending envelopes are drained before the end
+	if envelopes, err := s.Next(10); err != nil || !equal(seqs(envelopes), []int64{1}) {
+		t.Errorf("next: %v, %v", seqs(envelopes), err)
+	}
+
+	if _, err := s.Next(10); err != ErrClosed {
+		t.Errorf("next after drain: %v, want %v", err, ErrClosed)
+	}
+}
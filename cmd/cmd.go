@@ -15,7 +15,10 @@ var (
 	Cancel_           int8 = (&order.Cancel{}).Code()
 	Move_             int8 = (&order.Move{}).Code()
 	Reduce_           int8 = (&order.Reduce{}).Code()
+	MassCancel_       int8 = (&order.MassCancel{}).Code()
 	OrderBookRequest_ int8 = 6
+	MassQuote_        int8 = (&order.MassQuote{}).Code()
+	Amend_            int8 = (&order.Amend{}).Code()
 
 	AddUser_     int8 = 10
 	BalanceAdj_  int8 = 11
@@ -23,6 +26,20 @@ var (
 	ResumeUser_  int8 = 13
 	AddAccounts_ int8 = 14 // TODO vs ADD_ACCOUNTS(1002),
 
+	Heartbeat_       int8 = 15
+	KillSwitch_      int8 = 16
+	ResetKillSwitch_ int8 = 17
+	SetMMP_          int8 = 18
+	ResetMMP_        int8 = 19
+	Batch_           int8 = 20
+	SetIndexPrice_   int8 = 21
+	Funding_         int8 = 22
+	Settle_          int8 = 23
+	Liquidate_       int8 = 24
+	Transfer_        int8 = 25
+	AddSubAccount_   int8 = 26
+	SetAdmin_        int8 = 27
+
 	AddSymbols_ int8 = 40 // TODO vs ADD_SYMBOLS(1003);
 
 	PersistStateMatching_ int8 = 110
@@ -51,6 +68,9 @@ var _codeToNew = map[int8]func() Command{
 	Cancel_:      newCancel,
 	Move_:        newMove,
 	Reduce_:      newReduce,
+	MassCancel_:  newMassCancel,
+	MassQuote_:   newMassQuote,
+	Amend_:       newAmend,
 	AddUser_:     newAddUser,
 	BalanceAdj_:  newBalanceAdj,
 	SuspendUser_: newSuspendUser,
@@ -58,6 +78,20 @@ var _codeToNew = map[int8]func() Command{
 	AddAccounts_: newAddAccounts,
 	AddSymbols_:  newAddSymbols,
 	Reset_:       newReset,
+
+	Heartbeat_:       newHeartbeat,
+	KillSwitch_:      newKillSwitch,
+	ResetKillSwitch_: newResetKillSwitch,
+	SetMMP_:          newSetMMP,
+	ResetMMP_:        newResetMMP,
+	Batch_:           newBatch,
+	SetIndexPrice_:   newSetIndexPrice,
+	Funding_:         newFunding,
+	Settle_:          newSettle,
+	Liquidate_:       newLiquidate,
+	Transfer_:        newTransfer,
+	AddSubAccount_:   newAddSubAccount,
+	SetAdmin_:        newSetAdmin,
 }
 
 type Symbol interface {
@@ -91,6 +125,42 @@ type BalanceAdj struct {
 	_ struct{}
 }
 
+/*
+ * Moves `Amount` between users, e.g. a master account and its sub-accounts.
+ * `TransferID` is a sequence per sending user starting at 1,
+ * resubmissions and skipped ids are rejected.
+ */
+type Transfer struct {
+	TransferID int64
+	FromUserId int64
+	ToUserId   int64
+	Currency   int32
+	Amount     int64
+	Metadata
+	_ struct{}
+}
+
+// Adds a user as a sub-account of an active master account.
+type AddSubAccount struct {
+	UserId       int64
+	ParentId     int64
+	SharedMargin bool // balances of the parent count for margin of the sub-account
+	Metadata
+	_ struct{}
+}
+
+/*
+ * Grants or revokes the admin role, issued by an admin (`AdminId`).
+ * The first admin is granted while there is none, from the admin API.
+ */
+type SetAdmin struct {
+	UserId  int64
+	AdminId int64
+	Admin   bool
+	Metadata
+	_ struct{}
+}
+
 type SuspendUser struct {
 	UserId int64
 	Metadata
@@ -122,6 +192,108 @@ type Reset struct {
 	_ struct{}
 }
 
+// Opens the session if not open yet.
+// The session times out when no heartbeat is received within `TimeoutNS`.
+type Heartbeat struct {
+	UserId    int64
+	SessionID int64
+	TimeoutNS int64
+	Metadata
+	_ struct{}
+}
+
+// Cancels all orders of the session and blocks the user.
+type KillSwitch struct {
+	UserId    int64
+	SessionID int64
+	Metadata
+	_ struct{}
+}
+
+// Unblocks the user, only by an admin (see `SetAdmin`).
+type ResetKillSwitch struct {
+	UserId  int64
+	AdminId int64
+	Metadata
+	_ struct{}
+}
+
+// Market maker protection of a group of symbols (see `mmp.Config`).
+// Replaces previous settings of the group.
+type SetMMP struct {
+	UserId      int64
+	GroupID     int64
+	SymbolIDs   []int32
+	WindowNS    int64
+	MaxQuantity int64
+	MaxDelta    int64
+	MaxTrades   int64
+	CooldownNS  int64
+	Metadata
+	_ struct{}
+}
+
+// Unfreezes the group before the cooldown ends, only by an admin (see `SetAdmin`).
+type ResetMMP struct {
+	UserId  int64
+	GroupID int64
+	AdminId int64
+	Metadata
+	_ struct{}
+}
+
+/*
+ * Order commands (place, cancel, move, reduce, amend) of one user
+ * applied all or nothing, journaled as one record.
+ */
+type Batch struct {
+	UserId   int64
+	Commands []Command
+	Metadata
+	_ struct{}
+}
+
+// Index price of a symbol from an external source (e.g. spot exchanges), used for the mark price.
+type SetIndexPrice struct {
+	SymbolID int32
+	Price    int64
+	Metadata
+	_ struct{}
+}
+
+// Settles funding payments of a perpetual swap, issued at each funding timestamp.
+type Funding struct {
+	SymbolID int32
+	Metadata
+	_ struct{}
+}
+
+// Final settlement of an expired future at `Price`, delists the symbol.
+type Settle struct {
+	SymbolID int32
+	Price    int64
+	Metadata
+	_ struct{}
+}
+
+/*
+ * Closes the margin position of a user in the book with an IOC order
+ * limited by the bankruptcy price `Price`, after cancelling the user's orders of the symbol.
+ * The rest is taken over by the insurance fund or auto-deleveraged by the user engine.
+ * Liquidatability, `Action` and `Price` are recomputed when processed,
+ * `Quantity` caps the close. See `userengine.Engine.Liquidation`.
+ */
+type Liquidate struct {
+	OrderID  int64
+	UserId   int64
+	SymbolID int32
+	Action   order.Action
+	Quantity int64
+	Price    int64
+	Metadata
+	_ struct{}
+}
+
 func (m *Metadata) Unmarshal(in *bytes.Buffer) error {
 	seq, err := serialization.UnmarshalInt64(in)
 
@@ -225,6 +397,109 @@ func (c *BalanceAdj) Unmarshal(in *bytes.Buffer) error {
 	return nil
 }
 
+func (c *Transfer) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	transferID, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	fromUserId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	toUserId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	currency, err := serialization.UnmarshalInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	amount, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.TransferID = transferID.(int64)
+	c.FromUserId = fromUserId.(int64)
+	c.ToUserId = toUserId.(int64)
+	c.Currency = currency.(int32)
+	c.Amount = amount.(int64)
+
+	return nil
+}
+
+func (c *AddSubAccount) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	parentId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	sharedMargin, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.UserId = userId.(int64)
+	c.ParentId = parentId.(int64)
+	c.SharedMargin = sharedMargin
+
+	return nil
+}
+
+func (c *SetAdmin) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	admin, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.UserId = userId.(int64)
+	c.Admin = admin
+
+	return nil
+}
+
 func (c *SuspendUser) Unmarshal(in *bytes.Buffer) error {
 	err := c.Metadata.Unmarshal(in)
 
@@ -335,139 +610,767 @@ func (c *Reset) Unmarshal(in *bytes.Buffer) error {
 	return nil
 }
 
-func (m *Metadata) Marshal(out *bytes.Buffer) error {
-	if err := serialization.WriteInt64(m.Seq, out); err != nil {
-		return err
-	}
+func (c *Heartbeat) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
 
-	if err := serialization.WriteInt64(m.TimestampNs, out); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.WriteInt32(m.ServiceFlags, out); err != nil {
-		return err
-	}
+	userId, err := serialization.UnmarshalInt64(in)
 
-	if err := serialization.WriteInt64(m.EventsGroup, out); err != nil {
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	sessionID, err := serialization.UnmarshalInt64(in)
 
-func (c *AddUser) Marshal(out *bytes.Buffer) error {
-	if err := c.Metadata.Marshal(out); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+	timeoutNS, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
+	c.UserId = userId.(int64)
+	c.SessionID = sessionID.(int64)
+	c.TimeoutNS = timeoutNS.(int64)
+
 	return nil
 }
 
-func (c *BalanceAdj) Marshal(out *bytes.Buffer) error {
-	if err := c.Metadata.Marshal(out); err != nil {
+func (c *KillSwitch) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt32(c.Currency, out); err != nil {
+	sessionID, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.TXID, out); err != nil {
+	c.UserId = userId.(int64)
+	c.SessionID = sessionID.(int64)
+
+	return nil
+}
+
+func (c *ResetKillSwitch) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.Amount, out); err != nil {
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt8(int8(c.BalanceAdjCategory), out); err != nil {
+	adminId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
+	c.UserId = userId.(int64)
+	c.AdminId = adminId.(int64)
+
 	return nil
 }
 
-func (c *SuspendUser) Marshal(out *bytes.Buffer) error {
-	if err := c.Metadata.Marshal(out); err != nil {
+func (c *SetMMP) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	groupID, err := serialization.UnmarshalInt64(in)
 
-func (c *ResumeUser) Marshal(out *bytes.Buffer) error {
-	if err := c.Metadata.Marshal(out); err != nil {
+	if err != nil {
 		return err
 	}
 
-	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+	items, err := serialization.UnmarshalSlice(in, serialization.UnmarshalInt32)
+
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	windowNS, err := serialization.UnmarshalInt64(in)
 
-func (c *AddAccounts) Marshal(out *bytes.Buffer) error {
-	// if err := c.Metadata.Marshal(out); err != nil {
-	// 	return err
-	// }
+	if err != nil {
+		return err
+	}
 
-	return serialization.MarshalMap(
-		c.Users,
-		out,
-		serialization.MarshalInt64,
-		func(in interface{}, b *bytes.Buffer) error {
-			return serialization.MarshalMap(
-				in,
-				b,
-				serialization.MarshalInt32,
-				serialization.MarshalInt64,
-			)
-		},
-	)
-}
+	maxQuantity, err := serialization.UnmarshalInt64(in)
 
-func (c *AddSymbols) Marshal(out *bytes.Buffer) error {
-	// if err := c.Metadata.Marshal(out); err != nil {
-	// 	return err
-	// }
+	if err != nil {
+		return err
+	}
 
-	size := int32(len(c.Symbols))
+	maxDelta, err := serialization.UnmarshalInt64(in)
 
-	if err := serialization.WriteInt32(size, out); err != nil {
+	if err != nil {
 		return err
 	}
 
-	for symbolID, symbol_ := range c.Symbols {
-		if err := serialization.WriteInt32(symbolID, out); err != nil {
-			return err
-		}
+	maxTrades, err := serialization.UnmarshalInt64(in)
 
-		if err := symbol_.Marshal(out); err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
+
+	cooldownNS, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolIDs := make([]int32, len(items))
+
+	for i, item := range items {
+		symbolIDs[i] = item.(int32)
+	}
+
+	c.UserId = userId.(int64)
+	c.GroupID = groupID.(int64)
+	c.SymbolIDs = symbolIDs
+	c.WindowNS = windowNS.(int64)
+	c.MaxQuantity = maxQuantity.(int64)
+	c.MaxDelta = maxDelta.(int64)
+	c.MaxTrades = maxTrades.(int64)
+	c.CooldownNS = cooldownNS.(int64)
+
+	return nil
+}
+
+func (c *ResetMMP) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	groupID, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.UserId = userId.(int64)
+	c.GroupID = groupID.(int64)
+
+	return nil
+}
+
+func (c *Batch) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	commands := make([]Command, 0, size)
+
+	for ; size > 0; size-- {
+		code, err := serialization.ReadInt8(in)
+
+		if err != nil {
+			return err
+		}
+
+		command, ok := From(code)
+
+		if !ok || code == Batch_ {
+			return fmt.Errorf("Batch.Unmarshal: code: %v", code)
+		}
+
+		if err := command.Unmarshal(in); err != nil {
+			return err
+		}
+
+		commands = append(commands, command)
+	}
+
+	c.UserId = userId.(int64)
+	c.Commands = commands
+
+	return nil
+}
+
+func (c *SetIndexPrice) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.UnmarshalInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	price, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.SymbolID = symbolID.(int32)
+	c.Price = price.(int64)
+
+	return nil
+}
+
+func (c *Funding) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.UnmarshalInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.SymbolID = symbolID.(int32)
+
+	return nil
+}
+
+func (c *Settle) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.UnmarshalInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	price, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.SymbolID = symbolID.(int32)
+	c.Price = price.(int64)
+
+	return nil
+}
+
+func (c *Liquidate) Unmarshal(in *bytes.Buffer) error {
+	err := c.Metadata.Unmarshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	orderID, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	userId, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.UnmarshalInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	val, err := serialization.UnmarshalInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	action, ok := order.ActionFrom(val.(int8))
+
+	if !ok {
+		return fmt.Errorf("Liquidate.Unmarshal: action: %v", val)
+	}
+
+	quantity, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	price, err := serialization.UnmarshalInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.OrderID = orderID.(int64)
+	c.UserId = userId.(int64)
+	c.SymbolID = symbolID.(int32)
+	c.Action = action
+	c.Quantity = quantity.(int64)
+	c.Price = price.(int64)
+
+	return nil
+}
+
+func (m *Metadata) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(m.Seq, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.TimestampNs, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(m.ServiceFlags, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.EventsGroup, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *AddUser) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *BalanceAdj) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt32(c.Currency, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.TXID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Amount, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt8(int8(c.BalanceAdjCategory), out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *SuspendUser) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *ResumeUser) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *AddAccounts) Marshal(out *bytes.Buffer) error {
+	// if err := c.Metadata.Marshal(out); err != nil {
+	// 	return err
+	// }
+
+	return serialization.MarshalMap(
+		c.Users,
+		out,
+		serialization.MarshalInt64,
+		func(in interface{}, b *bytes.Buffer) error {
+			return serialization.MarshalMap(
+				in,
+				b,
+				serialization.MarshalInt32,
+				serialization.MarshalInt64,
+			)
+		},
+	)
+}
+
+func (c *AddSymbols) Marshal(out *bytes.Buffer) error {
+	// if err := c.Metadata.Marshal(out); err != nil {
+	// 	return err
+	// }
+
+	size := int32(len(c.Symbols))
+
+	if err := serialization.WriteInt32(size, out); err != nil {
+		return err
+	}
+
+	for symbolID, symbol_ := range c.Symbols {
+		if err := serialization.WriteInt32(symbolID, out); err != nil {
+			return err
+		}
+
+		if err := symbol_.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Reset) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Heartbeat) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.SessionID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.TimeoutNS, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *KillSwitch) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.SessionID, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *ResetKillSwitch) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.AdminId, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *SetMMP) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.GroupID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalSlice(c.SymbolIDs, out, serialization.MarshalInt32); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.WindowNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.MaxQuantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.MaxDelta, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.MaxTrades, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.CooldownNS, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *ResetMMP) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.GroupID, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Batch) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(c.Commands)), out); err != nil {
+		return err
+	}
+
+	for _, command := range c.Commands {
+		if err := serialization.WriteInt8(command.Code(), out); err != nil {
+			return err
+		}
+
+		if err := command.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *SetIndexPrice) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt32(c.SymbolID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Price, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Funding) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt32(c.SymbolID, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Transfer) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.TransferID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.FromUserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.ToUserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt32(c.Currency, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Amount, out); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (c *Reset) Marshal(out *bytes.Buffer) error {
+func (c *AddSubAccount) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.ParentId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(c.SharedMargin, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *SetAdmin) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(c.Admin, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Liquidate) Marshal(out *bytes.Buffer) error {
+	if err := c.Metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.OrderID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.UserId, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt32(c.SymbolID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt8(int8(c.Action), out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Quantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Price, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Settle) Marshal(out *bytes.Buffer) error {
 	if err := c.Metadata.Marshal(out); err != nil {
 		return err
 	}
 
+	if err := serialization.MarshalInt32(c.SymbolID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.MarshalInt64(c.Price, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -499,6 +1402,58 @@ func (c *Reset) TimestampNS() int64 {
 	return c.Metadata.TimestampNs
 }
 
+func (c *Heartbeat) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *KillSwitch) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *ResetKillSwitch) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *SetMMP) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *ResetMMP) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *Batch) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *SetIndexPrice) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *Funding) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *Settle) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *Liquidate) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *Transfer) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *AddSubAccount) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
+func (c *SetAdmin) TimestampNS() int64 {
+	return c.Metadata.TimestampNs
+}
+
 func (c *AddUser) Seq() int64 {
 	return c.Metadata.Seq
 }
@@ -527,6 +1482,58 @@ func (c *Reset) Seq() int64 {
 	return c.Metadata.Seq
 }
 
+func (c *Heartbeat) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *KillSwitch) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *ResetKillSwitch) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *SetMMP) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *ResetMMP) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *Batch) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *SetIndexPrice) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *Funding) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *Settle) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *Liquidate) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *Transfer) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *AddSubAccount) Seq() int64 {
+	return c.Metadata.Seq
+}
+
+func (c *SetAdmin) Seq() int64 {
+	return c.Metadata.Seq
+}
+
 func (c *AddUser) SetSeq(seq int64) {
 	c.Metadata.Seq = seq
 }
@@ -555,6 +1562,58 @@ func (c *Reset) SetSeq(seq int64) {
 	c.Metadata.Seq = seq
 }
 
+func (c *Heartbeat) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *KillSwitch) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *ResetKillSwitch) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *SetMMP) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *ResetMMP) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *Batch) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *SetIndexPrice) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *Funding) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *Settle) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *Liquidate) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *Transfer) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *AddSubAccount) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
+func (c *SetAdmin) SetSeq(seq int64) {
+	c.Metadata.Seq = seq
+}
+
 func (c *AddUser) Code() int8 {
 	return AddUser_
 }
@@ -583,6 +1642,58 @@ func (c *Reset) Code() int8 {
 	return Reset_
 }
 
+func (c *Heartbeat) Code() int8 {
+	return Heartbeat_
+}
+
+func (c *KillSwitch) Code() int8 {
+	return KillSwitch_
+}
+
+func (c *ResetKillSwitch) Code() int8 {
+	return ResetKillSwitch_
+}
+
+func (c *SetMMP) Code() int8 {
+	return SetMMP_
+}
+
+func (c *ResetMMP) Code() int8 {
+	return ResetMMP_
+}
+
+func (c *Batch) Code() int8 {
+	return Batch_
+}
+
+func (c *SetIndexPrice) Code() int8 {
+	return SetIndexPrice_
+}
+
+func (c *Funding) Code() int8 {
+	return Funding_
+}
+
+func (c *Settle) Code() int8 {
+	return Settle_
+}
+
+func (c *Liquidate) Code() int8 {
+	return Liquidate_
+}
+
+func (c *Transfer) Code() int8 {
+	return Transfer_
+}
+
+func (c *AddSubAccount) Code() int8 {
+	return AddSubAccount_
+}
+
+func (c *SetAdmin) Code() int8 {
+	return SetAdmin_
+}
+
 func newPlace() Command {
 	return &order.Place{}
 }
@@ -599,6 +1710,18 @@ func newReduce() Command {
 	return &order.Reduce{}
 }
 
+func newMassCancel() Command {
+	return &order.MassCancel{}
+}
+
+func newMassQuote() Command {
+	return &order.MassQuote{}
+}
+
+func newAmend() Command {
+	return &order.Amend{}
+}
+
 func newAddUser() Command {
 	return &AddUser{}
 }
@@ -627,6 +1750,58 @@ func newReset() Command {
 	return &Reset{}
 }
 
+func newHeartbeat() Command {
+	return &Heartbeat{}
+}
+
+func newKillSwitch() Command {
+	return &KillSwitch{}
+}
+
+func newResetKillSwitch() Command {
+	return &ResetKillSwitch{}
+}
+
+func newSetMMP() Command {
+	return &SetMMP{}
+}
+
+func newResetMMP() Command {
+	return &ResetMMP{}
+}
+
+func newBatch() Command {
+	return &Batch{}
+}
+
+func newSetIndexPrice() Command {
+	return &SetIndexPrice{}
+}
+
+func newFunding() Command {
+	return &Funding{}
+}
+
+func newSettle() Command {
+	return &Settle{}
+}
+
+func newLiquidate() Command {
+	return &Liquidate{}
+}
+
+func newTransfer() Command {
+	return &Transfer{}
+}
+
+func newAddSubAccount() Command {
+	return &AddSubAccount{}
+}
+
+func newSetAdmin() Command {
+	return &SetAdmin{}
+}
+
 func From(code int8) (Command, bool) {
 	if f, ok := _codeToNew[code]; ok {
 		return f(), true
@@ -0,0 +1,171 @@
+package dedup
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// Client order ids kept per user.
+const DefaultSize int32 = 1024
+
+/*
+ * Last client order ids submitted by each user.
+ * Resubmissions (e.g. gateway retries) are detected
+ * even after the order is filled or cancelled,
+ * until `size` newer ids of the user evict the id.
+ */
+type Window struct {
+	size  int32
+	users map[int64]*ids
+	_     struct{}
+}
+
+type ids struct {
+	ordered []int64 // oldest first
+	set     map[int64]struct{}
+	_       struct{}
+}
+
+func NewWindow(size int32) *Window {
+	return &Window{
+		size:  size,
+		users: make(map[int64]*ids),
+	}
+}
+
+func (w *Window) Contains(
+	userID int64,
+	clientOrderID int64,
+) bool {
+	u, ok := w.users[userID]
+
+	if !ok {
+		return false
+	}
+
+	_, ok = u.set[clientOrderID]
+
+	return ok
+}
+
+// The oldest id of the user is evicted when the window is full.
+func (w *Window) Add(
+	userID int64,
+	clientOrderID int64,
+) {
+	u, ok := w.users[userID]
+
+	if !ok {
+		u = &ids{
+			set: make(map[int64]struct{}),
+		}
+		w.users[userID] = u
+	}
+
+	if _, ok := u.set[clientOrderID]; ok {
+		return
+	}
+
+	if int32(len(u.ordered)) >= w.size {
+		delete(u.set, u.ordered[0])
+		u.ordered = u.ordered[1:]
+	}
+
+	u.ordered = append(u.ordered, clientOrderID)
+	u.set[clientOrderID] = struct{}{}
+}
+
+func (w *Window) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt32(w.size, out); err != nil {
+		return err
+	}
+
+	userIDs := make([]int64, 0, len(w.users))
+
+	for id := range w.users {
+		userIDs = append(userIDs, id)
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(userIDs)), out); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		u := w.users[userID]
+
+		if err := serialization.WriteInt64(userID, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(int32(len(u.ordered)), out); err != nil {
+			return err
+		}
+
+		for _, id := range u.ordered {
+			if err := serialization.WriteInt64(id, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *Window) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	numUsers, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	users := make(map[int64]*ids, numUsers)
+
+	for ; numUsers > 0; numUsers-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		numIDs, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		u := &ids{
+			ordered: make([]int64, 0, numIDs),
+			set:     make(map[int64]struct{}, numIDs),
+		}
+
+		for ; numIDs > 0; numIDs-- {
+			id, err := serialization.ReadInt64(in)
+
+			if err != nil {
+				return err
+			}
+
+			u.ordered = append(u.ordered, id)
+			u.set[id] = struct{}{}
+		}
+
+		users[userID] = u
+	}
+
+	w.size = size
+	w.users = users
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package dedup
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(2)
+	w.Add(1, 10)
+	w.Add(1, 11)
+	// a resubmission does not evict
+	w.Add(1, 10)
+	w.Add(2, 10)
+
+	if !w.Contains(1, 10) || !w.Contains(1, 11) || !w.Contains(2, 10) || w.Contains(2, 11) {
+		t.Fatal("ids of the window")
+	}
+
+	// evicts the oldest id of the user only
+	w.Add(1, 12)
+
+	if w.Contains(1, 10) || !w.Contains(1, 11) || !w.Contains(1, 12) || !w.Contains(2, 10) {
+		t.Error("ids after eviction")
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := w.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewWindow(DefaultSize)
+
+	if err := restored.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the size and the order of eviction are restored
+	restored.Add(1, 13)
+
+	if restored.Contains(1, 11) || !restored.Contains(1, 12) || !restored.Contains(1, 13) || !restored.Contains(2, 10) {
+		t.Error("restored window")
+	}
+}
@@ -0,0 +1,482 @@
+package execution
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/bus"
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// TODO aggregate leg fills of spread orders into reports of the spread
+// TODO cancel/replace rejects (FIX OrderCancelReject)
+
+type Status int8
+
+const (
+	New Status = iota + 1
+	PartiallyFilled
+	Filled
+	Cancelled
+	Replaced
+	Rejected
+	Reduced // remained quantity reduced in place
+)
+
+/*
+ * Execution report of one order, in the spirit of FIX ExecutionReport.
+ * Last fill fields are set for fills only.
+ */
+type Report struct {
+	seq            int64
+	timestampNS    int64
+	symbolID       int32
+	orderID        int64
+	userID         int64
+	action         order.Action // 0 if unknown
+	status         Status
+	code           resultcode.ResultCode // reject reason
+	cumQuantity    int64
+	leavesQuantity int64
+	avgPrice       int64 // truncated
+	tradeID        int64
+	lastPrice      int64
+	lastQuantity   int64
+	_              struct{}
+}
+
+func (r *Report) Seq() int64 {
+	return r.seq
+}
+
+func (r *Report) TimestampNS() int64 {
+	return r.timestampNS
+}
+
+func (r *Report) SymbolID() int32 {
+	return r.symbolID
+}
+
+func (r *Report) OrderID() int64 {
+	return r.orderID
+}
+
+func (r *Report) UserID() int64 {
+	return r.userID
+}
+
+func (r *Report) Action() order.Action {
+	return r.action
+}
+
+func (r *Report) Status() Status {
+	return r.status
+}
+
+func (r *Report) Code() resultcode.ResultCode {
+	return r.code
+}
+
+func (r *Report) CumQuantity() int64 {
+	return r.cumQuantity
+}
+
+func (r *Report) LeavesQuantity() int64 {
+	return r.leavesQuantity
+}
+
+func (r *Report) AvgPrice() int64 {
+	return r.avgPrice
+}
+
+func (r *Report) TradeID() int64 {
+	return r.tradeID
+}
+
+func (r *Report) LastPrice() int64 {
+	return r.lastPrice
+}
+
+func (r *Report) LastQuantity() int64 {
+	return r.lastQuantity
+}
+
+// Execution state of a live order.
+type state struct {
+	orderID     int64
+	userID      int64
+	symbolID    int32
+	action      order.Action
+	cumQuantity int64
+	notional    int64 // sum of price * quantity of fills
+	leaves      int64
+	_           struct{}
+}
+
+func (s *state) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(s.orderID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(s.symbolID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt8(int8(s.action), out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.cumQuantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.notional, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.leaves, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *state) Unmarshal(in *bytes.Buffer) error {
+	orderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	code, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	action, ok := order.ActionFrom(code)
+
+	if !ok {
+		return fmt.Errorf("unmarshal: action: %v", code)
+	}
+
+	cumQuantity, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	notional, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	leaves, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	s.orderID = orderID
+	s.userID = userID
+	s.symbolID = symbolID
+	s.action = action
+	s.cumQuantity = cumQuantity
+	s.notional = notional
+	s.leaves = leaves
+
+	return nil
+}
+
+// Order ids are unique per symbol only, spread orders also fill in the leg books.
+type key struct {
+	symbolID int32
+	orderID  int64
+}
+
+func keyOf(s *state) key {
+	return key{
+		symbolID: s.symbolID,
+		orderID:  s.orderID,
+	}
+}
+
+/*
+ * Builds execution reports from published results (`bus.Envelope`).
+ * Cumulative quantities are tracked per live order and symbol,
+ * so envelopes must be fed in seq order from the start
+ * or from a snapshot of the tracker.
+ * Fills of spread orders in leg books are reported per leg.
+ */
+type Tracker struct {
+	orders map[key]*state
+	_      struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		orders: make(map[key]*state),
+	}
+}
+
+func (t *Tracker) Reports(
+	e *bus.Envelope,
+) []*Report {
+	var (
+		reports  []*Report
+		rejects  []*event.Reject
+		replaced = make(map[int64]struct{})
+	)
+
+	report := func(
+		s *state,
+		status Status,
+	) *Report {
+		r := &Report{
+			seq:            e.Seq(),
+			timestampNS:    e.TimestampNS(),
+			symbolID:       s.symbolID,
+			orderID:        s.orderID,
+			userID:         s.userID,
+			action:         s.action,
+			status:         status,
+			code:           resultcode.Success,
+			cumQuantity:    s.cumQuantity,
+			leavesQuantity: s.leaves,
+		}
+
+		if s.cumQuantity > 0 {
+			r.avgPrice = s.notional / s.cumQuantity
+		}
+
+		reports = append(reports, r)
+
+		if status == Filled || status == Cancelled || status == Rejected {
+			if t.orders[keyOf(s)] == s {
+				delete(t.orders, keyOf(s))
+			}
+		}
+
+		return r
+	}
+
+	if e.Head() == nil && e.CommandCode() == cmd.Place_ &&
+		e.ResultCode() != resultcode.Success && e.ResultCode() != resultcode.Accepted {
+		// not tracked, action is unknown (0)
+		s := &state{
+			orderID:  e.TakerOrderID(),
+			userID:   e.TakerUserID(),
+			symbolID: e.SymbolID(),
+		}
+		report(s, Rejected).code = e.ResultCode()
+
+		return reports
+	}
+
+	for ev := e.Head(); ev != nil; ev = ev.Next() {
+		switch ev := ev.(type) {
+		case *event.Trade:
+			maker := t.stateOf(e, ev, ev.MakerOrderID(), ev.MakerUserID(), ev.MakerAction())
+			t.fill(maker, ev, ev.MakerRemained())
+			fill(report(maker, fillStatus(ev.MakerOrderCompleted())), ev)
+
+			// spread makers filled by implied-in orders, see `spread.Engine`
+			if ev.TakerOrderID() == 0 {
+				continue
+			}
+
+			taker := t.stateOf(e, ev, ev.TakerOrderID(), ev.TakerUserID(), opposite(ev.MakerAction()))
+			t.fill(taker, ev, ev.TakerRemained())
+			fill(report(taker, fillStatus(ev.TakerOrderCompleted())), ev)
+		case *event.Accept:
+			s := t.stateOf(e, ev, ev.OrderID(), ev.UserID(), ev.Action())
+			s.leaves = ev.Quantity()
+
+			// replaced or partially filled reported already
+			if _, ok := replaced[ev.OrderID()]; !ok && s.cumQuantity == 0 {
+				report(s, New)
+			}
+		case *event.Reduce:
+			// reduce of cancel/replace
+			if next, ok := ev.Next().(*event.Replace); ok && next.OrderID() == ev.MakerOrderID() {
+				continue
+			}
+
+			s := t.stateOf(e, ev, ev.MakerOrderID(), 0, ev.Action())
+
+			if ev.MakerOrderCompleted() {
+				s.leaves = 0
+				report(s, Cancelled)
+			} else {
+				s.leaves -= ev.Quantity()
+				report(s, Reduced)
+			}
+		case *event.Replace:
+			s := t.stateOf(e, ev, ev.OrderID(), 0, ev.Action())
+			s.leaves = ev.Quantity()
+			replaced[ev.OrderID()] = struct{}{}
+			report(s, Replaced)
+		case *event.Reject:
+			// the reject of IOC precedes its trades
+			rejects = append(rejects, ev)
+		}
+	}
+
+	for _, ev := range rejects {
+		s := t.stateOf(e, ev, ev.TakerOrderID(), 0, ev.Action())
+		s.leaves = 0
+
+		if s.cumQuantity == 0 {
+			report(s, Rejected).code = e.ResultCode()
+		} else {
+			report(s, Cancelled)
+		}
+	}
+
+	return reports
+}
+
+/*
+ * Creates the state of an order seen for the first time.
+ * The symbol is taken from the event, envelopes of batches, mass cancels,
+ * mass quotes and spreads span several symbols.
+ */
+func (t *Tracker) stateOf(
+	e *bus.Envelope,
+	ev event.Event,
+	orderID int64,
+	userID int64, // 0 if unknown
+	action order.Action,
+) *state {
+	k := key{
+		symbolID: ev.SymbolID(),
+		orderID:  orderID,
+	}
+	s, ok := t.orders[k]
+
+	if !ok {
+		s = &state{
+			orderID:  orderID,
+			userID:   userID,
+			symbolID: ev.SymbolID(),
+			action:   action,
+		}
+
+		if userID == 0 && orderID == e.TakerOrderID() {
+			s.userID = e.TakerUserID()
+		}
+
+		t.orders[k] = s
+	}
+
+	return s
+}
+
+func (t *Tracker) fill(
+	s *state,
+	trade *event.Trade,
+	remained int64,
+) {
+	s.cumQuantity += trade.Quantity()
+	s.notional += trade.Quantity() * trade.Price()
+	s.leaves = remained
+}
+
+func fill(
+	r *Report,
+	trade *event.Trade,
+) {
+	r.tradeID = trade.TradeID()
+	r.lastPrice = trade.Price()
+	r.lastQuantity = trade.Quantity()
+}
+
+func fillStatus(completed bool) Status {
+	if completed {
+		return Filled
+	}
+
+	return PartiallyFilled
+}
+
+func opposite(action order.Action) order.Action {
+	if action == order.Ask {
+		return order.Bid
+	}
+
+	return order.Ask
+}
+
+func (t *Tracker) Marshal(out *bytes.Buffer) error {
+	keys := make([]key, 0, len(t.orders))
+
+	for k := range t.orders {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].orderID != keys[j].orderID {
+			return keys[i].orderID < keys[j].orderID
+		}
+
+		return keys[i].symbolID < keys[j].symbolID
+	})
+
+	if err := serialization.WriteInt32(int32(len(keys)), out); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := t.orders[k].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Tracker) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	orders := make(map[key]*state, size)
+
+	for ; size > 0; size-- {
+		s := &state{}
+
+		if err := s.Unmarshal(in); err != nil {
+			return err
+		}
+
+		orders[keyOf(s)] = s
+	}
+
+	t.orders = orders
+
+	return nil
+}
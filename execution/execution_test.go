@@ -0,0 +1,157 @@
+package execution
+
+import (
+	"testing"
+
+	"github.com/xerexchain/matching-engine/bus"
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+)
+
+func chain(events ...event.Event) *orderbook.MatcherResult {
+	res := &orderbook.MatcherResult{}
+
+	for _, e := range events {
+		res.Append(e)
+	}
+
+	return res
+}
+
+func TestReports(t *testing.T) {
+	type report struct {
+		symbolID int32
+		orderID  int64
+		status   Status
+		leaves   int64
+	}
+
+	steps := []struct {
+		command cmd.Command
+		res     *orderbook.MatcherResult
+		want    []report
+	}{
+		{
+			command: order.NewPlace(1, 1, 100, 10, 100, 1, 0, order.Bid, order.GTC),
+			res:     chain(event.NewAccept(1, 1, 1, 100, 10, order.Bid)),
+			want:    []report{{1, 1, New, 10}},
+		},
+		{
+			command: order.NewPlace(2, 1, 200, 5, 200, 2, 0, order.Ask, order.GTC),
+			res:     chain(event.NewAccept(2, 2, 1, 200, 5, order.Ask)),
+			want:    []report{{2, 2, New, 5}},
+		},
+		{
+			command: order.NewReduce(1, 1, 4),
+			res:     chain(event.NewReduce(1, 1, false, 100, 4, order.Bid)),
+			want:    []report{{1, 1, Reduced, 6}},
+		},
+		{
+			command: order.NewAmend(1, 1, 1, 0, 3, 0),
+			res: chain(
+				event.NewReduce(1, 1, false, 100, 3, order.Bid),
+				event.NewReplace(1, 1, 0, 100, 3, order.Bid, true),
+			),
+			want: []report{{1, 1, Replaced, 3}},
+		},
+		{
+			command: order.NewMassCancel(1, 0, 0),
+			res: chain(
+				event.NewReduce(1, 1, true, 100, 3, order.Bid),
+				event.NewReduce(2, 2, true, 200, 5, order.Ask),
+			),
+			want: []report{{1, 1, Cancelled, 0}, {2, 2, Cancelled, 0}},
+		},
+	}
+
+	tracker := NewTracker()
+
+	for i, step := range steps {
+		reports := tracker.Reports(bus.NewEnvelope(step.command, step.res))
+
+		if len(reports) != len(step.want) {
+			t.Fatalf("step %v: %v reports, want %v", i, len(reports), len(step.want))
+		}
+
+		for j, r := range reports {
+			got := report{r.SymbolID(), r.OrderID(), r.Status(), r.LeavesQuantity()}
+
+			if got != step.want[j] {
+				t.Errorf("step %v report %v: %v, want %v", i, j, got, step.want[j])
+			}
+		}
+	}
+}
+
+/*
+ * Spread order 7 (symbol 3 of legs 1 and 2, ratios 1 and -1) is partially filled
+ * by the implied-in ask 8 of symbol 1: the spread book trade has no taker,
+ * the spread order buys symbol 1 from the outright order and sells symbol 2.
+ */
+func TestImpliedReports(t *testing.T) {
+	type report struct {
+		symbolID int32
+		orderID  int64
+		status   Status
+		cum      int64
+		leaves   int64
+	}
+
+	steps := []struct {
+		command cmd.Command
+		res     *orderbook.MatcherResult
+		want    []report
+	}{
+		{
+			command: order.NewPlace(9, 3, 100, 10, 100, 2, 0, order.Bid, order.GTC),
+			res:     chain(event.NewAccept(2, 9, 3, 100, 10, order.Bid)),
+			want:    []report{{2, 9, New, 0, 10}},
+		},
+		{
+			command: order.NewPlace(7, 2, 5, 5, 5, 3, 0, order.Bid, order.GTC),
+			res:     chain(event.NewAccept(3, 7, 2, 5, 5, order.Bid)),
+			want:    []report{{3, 7, New, 0, 5}},
+		},
+		{
+			command: order.NewPlace(8, 1, 100, 5, 100, 1, 0, order.Ask, order.GTC),
+			res: chain(
+				event.NewTrade(3, 1, 7, 2, 0, 0, false, false, order.Bid, 2, 0, 5, 3, 5),
+				event.NewTrade(2, 1, 9, 3, 7, 2, false, true, order.Bid, 7, 0, 100, 3, 100),
+				event.NewTrade(1, 1, 7, 2, 8, 1, false, false, order.Bid, 2, 2, 105, 3, 105),
+				event.NewAccept(1, 8, 1, 100, 2, order.Ask),
+			),
+			want: []report{
+				{3, 7, PartiallyFilled, 3, 2},
+				{2, 9, PartiallyFilled, 3, 7},
+				{2, 7, Filled, 3, 0},
+				{1, 7, PartiallyFilled, 3, 2},
+				{1, 8, PartiallyFilled, 3, 2},
+			},
+		},
+		{
+			command: order.NewMassCancel(2, 0, 0),
+			res:     chain(event.NewReduce(3, 7, true, 5, 2, order.Bid)),
+			want:    []report{{3, 7, Cancelled, 3, 0}},
+		},
+	}
+
+	tracker := NewTracker()
+
+	for i, step := range steps {
+		reports := tracker.Reports(bus.NewEnvelope(step.command, step.res))
+
+		if len(reports) != len(step.want) {
+			t.Fatalf("step %v: %v reports, want %v", i, len(reports), len(step.want))
+		}
+
+		for j, r := range reports {
+			got := report{r.SymbolID(), r.OrderID(), r.Status(), r.CumQuantity(), r.LeavesQuantity()}
+
+			if got != step.want[j] {
+				t.Errorf("step %v report %v: %v, want %v", i, j, got, step.want[j])
+			}
+		}
+	}
+}
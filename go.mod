@@ -2,11 +2,11 @@ module github.com/xerexchain/matching-engine
 
 go 1.17
 
-require github.com/mitchellh/hashstructure/v2 v2.0.2
-
 require (
-	github.com/emirpasic/gods v1.18.1 // indirect
-	github.com/google/btree v1.1.2 // indirect
-	github.com/pierrec/lz4 v2.6.1+incompatible // indirect
-	github.com/pierrec/lz4/v4 v4.1.17 // indirect
+	github.com/emirpasic/gods v1.18.1
+	github.com/google/btree v1.1.2
+	github.com/mitchellh/hashstructure/v2 v2.0.2
+	github.com/pierrec/lz4/v4 v4.1.17
 )
+
+require github.com/pierrec/lz4 v2.6.1+incompatible // indirect
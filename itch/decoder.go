@@ -0,0 +1,328 @@
+package itch
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+)
+
+type priceLevel struct {
+	quantity  int64
+	numOrders int32
+	_         struct{}
+}
+
+type levels struct {
+	asks map[int64]priceLevel
+	bids map[int64]priceLevel
+	_    struct{}
+}
+
+func newLevels() *levels {
+	return &levels{
+		asks: make(map[int64]priceLevel),
+		bids: make(map[int64]priceLevel),
+	}
+}
+
+// Messages kept after a gap, the gap is skipped when exceeded.
+const MaxPendingMessages = 1 << 16
+
+/*
+ * Reference decoder, rebuilds the L2 books from MoldUDP64 packets.
+ * Messages are applied in seq order, duplicates are dropped
+ * and messages after a gap are kept until the gap is filled,
+ * e.g. by a retransmission request.
+ * A gap which can not be filled (older than the history of the retransmission server)
+ * is skipped by `Resync`, or when more than `MaxPendingMessages` are kept.
+ * Books are stale after a skipped gap until rebuilt by their next snapshot (`ClearBook`).
+ */
+type Decoder struct {
+	session string
+	nextSeq uint64
+	end     uint64 // seq after the last known message
+	pending map[uint64]*Message
+	books   map[int32]*levels
+
+	skipped bool               // a gap was skipped
+	synced  map[int32]struct{} // books rebuilt since the last skipped gap
+	mu      sync.Mutex
+	_       struct{}
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{
+		nextSeq: 1,
+		end:     1,
+		pending: make(map[uint64]*Message),
+		books:   make(map[int32]*levels),
+		synced:  make(map[int32]struct{}),
+	}
+}
+
+func (d *Decoder) Session() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.session
+}
+
+// Seq of the next message to apply.
+func (d *Decoder) NextSeq() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.nextSeq
+}
+
+/*
+ * Applies a packet, returns the missing range if a gap is detected
+ * (count 0 if none), to be requested from the retransmission server.
+ */
+func (d *Decoder) Handle(b []byte) (uint64, uint64, error) {
+	packet := &Packet{}
+
+	if err := packet.Unmarshal(bytes.NewBuffer(b)); err != nil {
+		return 0, 0, err
+	}
+
+	messages := make([]*Message, 0, len(packet.messages))
+
+	for _, raw := range packet.messages {
+		m := &Message{}
+
+		if err := m.Unmarshal(bytes.NewBuffer(raw)); err != nil {
+			return 0, 0, err
+		}
+
+		messages = append(messages, m)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.session = packet.session
+
+	for i, m := range messages {
+		seq := packet.seq + uint64(i)
+
+		if seq >= d.nextSeq {
+			d.pending[seq] = m
+		}
+	}
+
+	// a heartbeat carries the next seq
+	if end := packet.seq + uint64(len(messages)); end > d.end {
+		d.end = end
+	}
+
+	d.drain()
+
+	if len(d.pending) > MaxPendingMessages {
+		d.skipGap()
+	}
+
+	if d.end > d.nextSeq {
+		return d.nextSeq, d.gapSize(), nil
+	}
+
+	return 0, 0, nil
+}
+
+// Applies pending messages from `nextSeq` up to the next gap.
+func (d *Decoder) drain() {
+	for {
+		m, ok := d.pending[d.nextSeq]
+
+		if !ok {
+			break
+		}
+
+		delete(d.pending, d.nextSeq)
+		d.apply(m)
+		d.nextSeq++
+	}
+}
+
+/*
+ * Skips the current gap, e.g. when the retransmission server no longer has it.
+ * All books are stale until their next snapshot.
+ */
+func (d *Decoder) Resync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.skipGap()
+}
+
+func (d *Decoder) skipGap() {
+	if d.end <= d.nextSeq {
+		return
+	}
+
+	d.nextSeq += d.gapSize()
+	d.skipped = true
+	d.synced = make(map[int32]struct{})
+	d.drain()
+}
+
+// Whether the book may miss changes of a skipped gap.
+func (d *Decoder) IsStale(symbolID int32) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.synced[symbolID]
+
+	return d.skipped && !ok
+}
+
+// Messages missing from `nextSeq`, up to the first pending one or `end`.
+func (d *Decoder) gapSize() uint64 {
+	size := d.end - d.nextSeq
+
+	for seq := range d.pending {
+		if seq-d.nextSeq < size {
+			size = seq - d.nextSeq
+		}
+	}
+
+	return size
+}
+
+// Only price levels change the books, order-level messages are informative.
+func (d *Decoder) apply(m *Message) {
+	switch m.kind {
+	case ClearBook:
+		d.books[m.symbolID] = newLevels()
+		d.synced[m.symbolID] = struct{}{}
+	case PriceLevel:
+		book, ok := d.books[m.symbolID]
+
+		if !ok {
+			book = newLevels()
+			d.books[m.symbolID] = book
+		}
+
+		side := book.asks
+
+		if m.side == order.Bid {
+			side = book.bids
+		}
+
+		if m.quantity == 0 {
+			delete(side, m.price)
+		} else {
+			side[m.price] = priceLevel{
+				quantity:  m.quantity,
+				numOrders: m.numOrders,
+			}
+		}
+	}
+}
+
+// Asks ascending and bids descending by price, at most `depth` levels per side.
+func (d *Decoder) L2MarketData(
+	symbolID int32,
+	depth int32,
+) *orderbook.L2MarketData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	book, ok := d.books[symbolID]
+
+	if !ok {
+		book = newLevels()
+	}
+
+	askPrices := sortedPrices(book.asks, depth, false)
+	bidPrices := sortedPrices(book.bids, depth, true)
+	data := orderbook.NewL2MarketData(int32(len(askPrices)), int32(len(bidPrices)))
+
+	for i, price := range askPrices {
+		data.SetAskPriceAt(int32(i), price)
+		data.SetAskQuantityAt(int32(i), book.asks[price].quantity)
+		data.SetNumAskOrdersAt(int32(i), book.asks[price].numOrders)
+	}
+
+	for i, price := range bidPrices {
+		data.SetBidPriceAt(int32(i), price)
+		data.SetBidQuantityAt(int32(i), book.bids[price].quantity)
+		data.SetNumBidOrdersAt(int32(i), book.bids[price].numOrders)
+	}
+
+	return data
+}
+
+func sortedPrices(
+	side map[int64]priceLevel,
+	depth int32,
+	descending bool,
+) []int64 {
+	prices := make([]int64, 0, len(side))
+
+	for price := range side {
+		prices = append(prices, price)
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return prices[i] > prices[j]
+		}
+
+		return prices[i] < prices[j]
+	})
+
+	if int32(len(prices)) > depth {
+		prices = prices[:depth]
+	}
+
+	return prices
+}
+
+/*
+ * Sends requests of `count` messages from `seq` to the retransmission server
+ * `conn` is connected to, one request per `math.MaxUint16` messages.
+ */
+func RequestRetransmit(
+	conn net.Conn,
+	session string,
+	seq uint64,
+	count uint64,
+) error {
+	for _, req := range Requests(session, seq, count) {
+		out := &bytes.Buffer{}
+
+		if err := req.Marshal(out); err != nil {
+			return err
+		}
+
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Requests of a range, a request carries at most `math.MaxUint16` messages.
+func Requests(
+	session string,
+	seq uint64,
+	count uint64,
+) []*Request {
+	var requests []*Request
+
+	for count > 0 {
+		n := min(count, math.MaxUint16)
+		requests = append(requests, NewRequest(session, seq, uint16(n)))
+		seq += n
+		count -= n
+	}
+
+	return requests
+}
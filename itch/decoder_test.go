@@ -0,0 +1,267 @@
+package itch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+)
+
+const session = "S1"
+
+func level(symbolID int32, price int64) *Message {
+	return &Message{
+		kind:      PriceLevel,
+		symbolID:  symbolID,
+		side:      order.Bid,
+		price:     price,
+		quantity:  1,
+		numOrders: 1,
+	}
+}
+
+func clearBook(symbolID int32) *Message {
+	return &Message{
+		kind:     ClearBook,
+		symbolID: symbolID,
+	}
+}
+
+// Packets of the messages from `seq`, a heartbeat if there are none.
+func packets(
+	t *testing.T,
+	seq uint64,
+	messages ...*Message,
+) [][]byte {
+	encoded := make([][]byte, 0, len(messages))
+
+	for _, m := range messages {
+		out := &bytes.Buffer{}
+
+		if err := m.Marshal(out); err != nil {
+			t.Fatal(err)
+		}
+
+		encoded = append(encoded, out.Bytes())
+	}
+
+	packets_ := pack(session, seq, encoded)
+
+	if len(packets_) == 0 {
+		packets_ = []*Packet{{session: session, seq: seq}}
+	}
+
+	raw := make([][]byte, 0, len(packets_))
+
+	for _, p := range packets_ {
+		out := &bytes.Buffer{}
+
+		if err := p.Marshal(out); err != nil {
+			t.Fatal(err)
+		}
+
+		raw = append(raw, out.Bytes())
+	}
+
+	return raw
+}
+
+func TestGapRecovery(t *testing.T) {
+	type feed struct {
+		seq      uint64
+		messages []*Message
+		resync   bool // `Resync` instead of a packet
+	}
+
+	tests := []struct {
+		name    string
+		feeds   []feed
+		gap     [2]uint64 // seq and count returned by the last packet
+		nextSeq uint64
+		prices  []int64 // bids of symbol 1
+		stale   bool
+	}{
+		{
+			name: "gap filled by retransmission",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 3, messages: []*Message{level(1, 102)}},
+				{seq: 2, messages: []*Message{level(1, 101)}},
+			},
+			nextSeq: 4,
+			prices:  []int64{102, 101, 100},
+		},
+		{
+			name: "duplicates are dropped",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100), level(1, 101)}},
+				{seq: 2, messages: []*Message{level(1, 101)}},
+			},
+			nextSeq: 3,
+			prices:  []int64{101, 100},
+		},
+		{
+			name: "gap up to the first pending message",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 5, messages: []*Message{level(1, 104)}},
+			},
+			gap:     [2]uint64{2, 3},
+			nextSeq: 2,
+			prices:  []int64{100},
+		},
+		{
+			name: "heartbeat reveals a gap",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 4},
+			},
+			gap:     [2]uint64{2, 2},
+			nextSeq: 2,
+			prices:  []int64{100},
+		},
+		{
+			name: "resync skips the gap, the book is stale",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 3, messages: []*Message{level(1, 102)}},
+				{resync: true},
+			},
+			nextSeq: 4,
+			prices:  []int64{102, 100},
+			stale:   true,
+		},
+		{
+			name: "snapshot after resync rebuilds the book",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 3, messages: []*Message{level(1, 102)}},
+				{resync: true},
+				{seq: 4, messages: []*Message{clearBook(1), level(1, 103)}},
+			},
+			nextSeq: 6,
+			prices:  []int64{103},
+		},
+		{
+			name: "resync after a heartbeat",
+			feeds: []feed{
+				{seq: 1, messages: []*Message{level(1, 100)}},
+				{seq: 10},
+				{resync: true},
+				{seq: 10, messages: []*Message{level(1, 101)}},
+			},
+			nextSeq: 11,
+			prices:  []int64{101, 100},
+			stale:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDecoder()
+			var gap [2]uint64
+
+			for _, f := range test.feeds {
+				if f.resync {
+					d.Resync()
+					gap = [2]uint64{}
+
+					continue
+				}
+
+				for _, b := range packets(t, f.seq, f.messages...) {
+					seq, count, err := d.Handle(b)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					gap = [2]uint64{seq, count}
+				}
+			}
+
+			if gap != test.gap {
+				t.Errorf("gap %v, want %v", gap, test.gap)
+			}
+
+			if d.NextSeq() != test.nextSeq {
+				t.Errorf("next seq %v, want %v", d.NextSeq(), test.nextSeq)
+			}
+
+			if d.IsStale(1) != test.stale {
+				t.Errorf("stale %v, want %v", d.IsStale(1), test.stale)
+			}
+
+			data := d.L2MarketData(1, 10)
+
+			if data.BidSize() != int32(len(test.prices)) {
+				t.Fatalf("%v bids, want %v", data.BidSize(), len(test.prices))
+			}
+
+			for i, price := range test.prices {
+				if data.BidPriceAt(int32(i)) != price {
+					t.Errorf("bid %v: price %v, want %v", i, data.BidPriceAt(int32(i)), price)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingBound(t *testing.T) {
+	d := NewDecoder()
+	messages := make([]*Message, 0, MaxPendingMessages+1)
+
+	for i := 0; i <= MaxPendingMessages; i++ {
+		messages = append(messages, level(1, int64(i)))
+	}
+
+	// the gap at seq 1 is never filled
+	for _, b := range packets(t, 2, messages...) {
+		if _, _, err := d.Handle(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d.NextSeq() != MaxPendingMessages+3 {
+		t.Errorf("next seq %v, want %v", d.NextSeq(), MaxPendingMessages+3)
+	}
+
+	if !d.IsStale(1) {
+		t.Error("book not stale")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		seq   uint64
+		count uint64
+		want  [][2]uint64 // seq, count
+	}{
+		{1, 0, nil},
+		{1, 10, [][2]uint64{{1, 10}}},
+		{5, 1 << 16, [][2]uint64{{5, 1<<16 - 1}, {1<<16 + 4, 1}}},
+		{1, 3<<16 - 3, [][2]uint64{{1, 1<<16 - 1}, {1 << 16, 1<<16 - 1}, {1<<17 - 1, 1<<16 - 1}}},
+	}
+
+	for _, test := range tests {
+		requests := Requests(session, test.seq, test.count)
+
+		if len(requests) != len(test.want) {
+			t.Fatalf("%v from %v: %v requests, want %v", test.count, test.seq, len(requests), len(test.want))
+		}
+
+		for i, req := range requests {
+			if got := [2]uint64{req.Seq(), uint64(req.Count())}; got != test.want[i] {
+				t.Errorf("%v from %v: request %v %v, want %v", test.count, test.seq, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestNewPublisher(t *testing.T) {
+	for _, size := range []int{-1, 0} {
+		if _, err := NewPublisher(nil, session, size); err != ErrInvalidHistorySize {
+			t.Errorf("history size %v: error %v", size, err)
+		}
+	}
+}
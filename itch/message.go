@@ -0,0 +1,345 @@
+package itch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/xerexchain/matching-engine/bus"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+)
+
+// Wire format is big-endian, as in ITCH.
+
+type MessageType byte
+
+const (
+	AddOrder      MessageType = 'A'
+	ModifyOrder   MessageType = 'U' // quantity reduced, queue position kept
+	OrderExecuted MessageType = 'E' // deleted if remaining quantity is 0
+	DeleteOrder   MessageType = 'D'
+	Trade         MessageType = 'P'
+	PriceLevel    MessageType = 'L' // quantity 0 removes the level
+	ClearBook     MessageType = 'C' // followed by the price levels of a snapshot
+)
+
+const (
+	_sideBuy  byte = 'B'
+	_sideSell byte = 'S'
+)
+
+/*
+ * ITCH-like message. Every message starts with
+ * type (1), symbolID (4) and timestampNS (8),
+ * the other fields depend on the type:
+ *   A: orderID, side, price, quantity
+ *   U: orderID, quantity
+ *   E: orderID, executed, quantity (remaining), tradeID, price
+ *   D: orderID
+ *   P: tradeID, side (aggressor), price, quantity
+ *   L: side, price, quantity, numOrders
+ *   C: -
+ * Order ids are public ids of the L3 feed.
+ */
+type Message struct {
+	kind        MessageType
+	symbolID    int32
+	timestampNS int64
+	orderID     int64
+	side        order.Action
+	price       int64
+	quantity    int64
+	executed    int64
+	tradeID     int64
+	numOrders   int32
+	_           struct{}
+}
+
+func (m *Message) Type() MessageType {
+	return m.kind
+}
+
+func (m *Message) SymbolID() int32 {
+	return m.symbolID
+}
+
+func (m *Message) TimestampNS() int64 {
+	return m.timestampNS
+}
+
+func (m *Message) OrderID() int64 {
+	return m.orderID
+}
+
+func (m *Message) Side() order.Action {
+	return m.side
+}
+
+func (m *Message) Price() int64 {
+	return m.price
+}
+
+func (m *Message) Quantity() int64 {
+	return m.quantity
+}
+
+func (m *Message) Executed() int64 {
+	return m.executed
+}
+
+func (m *Message) TradeID() int64 {
+	return m.tradeID
+}
+
+func (m *Message) NumOrders() int32 {
+	return m.numOrders
+}
+
+/*
+ * Messages of the market data of an envelope:
+ * order-level changes and trades, then L2 deltas, then L2 snapshots.
+ */
+func Messages(e *bus.Envelope) []*Message {
+	var messages []*Message
+
+	for _, u := range e.L3Updates() {
+		for _, ev := range u.Events() {
+			messages = append(messages, fromL3(u.SymbolID(), u.TimestampNS(), ev)...)
+		}
+	}
+
+	for _, u := range e.L2Updates() {
+		for _, d := range u.Deltas() {
+			messages = append(messages, &Message{
+				kind:        PriceLevel,
+				symbolID:    u.SymbolID(),
+				timestampNS: u.TimestampNS(),
+				side:        d.Action(),
+				price:       d.Price(),
+				quantity:    d.Quantity(),
+				numOrders:   d.NumOrders(),
+			})
+		}
+	}
+
+	for _, s := range e.L2Snapshots() {
+		messages = append(messages, &Message{
+			kind:        ClearBook,
+			symbolID:    s.SymbolID(),
+			timestampNS: s.TimestampNS(),
+		})
+
+		data := s.Data()
+
+		for i := int32(0); i < data.AskSize(); i++ {
+			messages = append(messages, &Message{
+				kind:        PriceLevel,
+				symbolID:    s.SymbolID(),
+				timestampNS: s.TimestampNS(),
+				side:        order.Ask,
+				price:       data.AskPriceAt(i),
+				quantity:    data.AskQuantityAt(i),
+				numOrders:   data.NumAskOrdersAt(i),
+			})
+		}
+
+		for i := int32(0); i < data.BidSize(); i++ {
+			messages = append(messages, &Message{
+				kind:        PriceLevel,
+				symbolID:    s.SymbolID(),
+				timestampNS: s.TimestampNS(),
+				side:        order.Bid,
+				price:       data.BidPriceAt(i),
+				quantity:    data.BidQuantityAt(i),
+				numOrders:   data.NumBidOrdersAt(i),
+			})
+		}
+	}
+
+	return messages
+}
+
+func fromL3(
+	symbolID int32,
+	timestampNS int64,
+	ev *orderbook.L3Event,
+) []*Message {
+	m := &Message{
+		symbolID:    symbolID,
+		timestampNS: timestampNS,
+		orderID:     ev.OrderID(),
+		side:        ev.Action(),
+		price:       ev.Price(),
+		quantity:    ev.Quantity(),
+	}
+
+	switch ev.Kind() {
+	case orderbook.L3OrderAdded:
+		m.kind = AddOrder
+	case orderbook.L3OrderModified:
+		m.kind = ModifyOrder
+	case orderbook.L3OrderDeleted:
+		m.kind = DeleteOrder
+	case orderbook.L3OrderExecuted:
+		m.kind = OrderExecuted
+		m.executed = ev.Executed()
+		m.tradeID = ev.TradeID()
+
+		aggressor := order.Bid
+
+		if ev.Action() == order.Bid {
+			aggressor = order.Ask
+		}
+
+		trade := &Message{
+			kind:        Trade,
+			symbolID:    symbolID,
+			timestampNS: timestampNS,
+			side:        aggressor,
+			price:       ev.Price(),
+			quantity:    ev.Executed(),
+			tradeID:     ev.TradeID(),
+		}
+
+		return []*Message{m, trade}
+	}
+
+	return []*Message{m}
+}
+
+func (m *Message) Marshal(out *bytes.Buffer) error {
+	write := func(v interface{}) error {
+		return binary.Write(out, binary.BigEndian, v)
+	}
+
+	if err := out.WriteByte(byte(m.kind)); err != nil {
+		return err
+	}
+
+	if err := write(m.symbolID); err != nil {
+		return err
+	}
+
+	if err := write(m.timestampNS); err != nil {
+		return err
+	}
+
+	var fields []interface{}
+
+	switch m.kind {
+	case AddOrder:
+		fields = []interface{}{m.orderID, sideCode(m.side), m.price, m.quantity}
+	case ModifyOrder:
+		fields = []interface{}{m.orderID, m.quantity}
+	case OrderExecuted:
+		fields = []interface{}{m.orderID, m.executed, m.quantity, m.tradeID, m.price}
+	case DeleteOrder:
+		fields = []interface{}{m.orderID}
+	case Trade:
+		fields = []interface{}{m.tradeID, sideCode(m.side), m.price, m.quantity}
+	case PriceLevel:
+		fields = []interface{}{sideCode(m.side), m.price, m.quantity, m.numOrders}
+	case ClearBook:
+	default:
+		return fmt.Errorf("marshal: message type: %v", m.kind)
+	}
+
+	for _, f := range fields {
+		if err := write(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Message) Unmarshal(in *bytes.Buffer) error {
+	read := func(v interface{}) error {
+		return binary.Read(in, binary.BigEndian, v)
+	}
+
+	kind, err := in.ReadByte()
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		symbolID    int32
+		timestampNS int64
+		orderID     int64
+		side        byte
+		price       int64
+		quantity    int64
+		executed    int64
+		tradeID     int64
+		numOrders   int32
+		fields      []interface{}
+	)
+
+	if err := read(&symbolID); err != nil {
+		return err
+	}
+
+	if err := read(&timestampNS); err != nil {
+		return err
+	}
+
+	switch MessageType(kind) {
+	case AddOrder:
+		fields = []interface{}{&orderID, &side, &price, &quantity}
+	case ModifyOrder:
+		fields = []interface{}{&orderID, &quantity}
+	case OrderExecuted:
+		fields = []interface{}{&orderID, &executed, &quantity, &tradeID, &price}
+	case DeleteOrder:
+		fields = []interface{}{&orderID}
+	case Trade:
+		fields = []interface{}{&tradeID, &side, &price, &quantity}
+	case PriceLevel:
+		fields = []interface{}{&side, &price, &quantity, &numOrders}
+	case ClearBook:
+	default:
+		return fmt.Errorf("unmarshal: message type: %v", kind)
+	}
+
+	for _, f := range fields {
+		if err := read(f); err != nil {
+			return err
+		}
+	}
+
+	m.kind = MessageType(kind)
+	m.symbolID = symbolID
+	m.timestampNS = timestampNS
+	m.orderID = orderID
+	m.side = sideFrom(side)
+	m.price = price
+	m.quantity = quantity
+	m.executed = executed
+	m.tradeID = tradeID
+	m.numOrders = numOrders
+
+	return nil
+}
+
+func sideCode(action order.Action) byte {
+	if action == order.Bid {
+		return _sideBuy
+	}
+
+	return _sideSell
+}
+
+// 0 if the message has no side
+func sideFrom(code byte) order.Action {
+	switch code {
+	case _sideBuy:
+		return order.Bid
+	case _sideSell:
+		return order.Ask
+	default:
+		return 0
+	}
+}
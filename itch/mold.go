@@ -0,0 +1,269 @@
+package itch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Max UDP payload of a packet, below the usual ethernet MTU.
+	MaxPacketSize = 1400
+
+	_sessionSize = 10
+	_headerSize  = _sessionSize + 8 + 2
+
+	// message count of the packet ending the session
+	_endOfSession uint16 = 0xFFFF
+)
+
+var errPacketSize = errors.New("packet size")
+
+/*
+ * MoldUDP64 downstream packet: session (10), seq of the first message (8),
+ * message count (2), then each message prefixed by its length (2).
+ * A packet without messages is a heartbeat carrying the next seq.
+ */
+type Packet struct {
+	session  string
+	seq      uint64
+	messages [][]byte
+	end      bool // end of session
+	_        struct{}
+}
+
+func (p *Packet) Session() string {
+	return p.session
+}
+
+func (p *Packet) Seq() uint64 {
+	return p.seq
+}
+
+func (p *Packet) Messages() [][]byte {
+	return p.messages
+}
+
+func (p *Packet) IsEndOfSession() bool {
+	return p.end
+}
+
+func (p *Packet) Marshal(out *bytes.Buffer) error {
+	if err := writeSession(p.session, out); err != nil {
+		return err
+	}
+
+	count := uint16(len(p.messages))
+
+	if p.end {
+		count = _endOfSession
+	}
+
+	if err := binary.Write(out, binary.BigEndian, p.seq); err != nil {
+		return err
+	}
+
+	if err := binary.Write(out, binary.BigEndian, count); err != nil {
+		return err
+	}
+
+	for _, m := range p.messages {
+		if err := binary.Write(out, binary.BigEndian, uint16(len(m))); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Packet) Unmarshal(in *bytes.Buffer) error {
+	session, err := readSession(in)
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		seq   uint64
+		count uint16
+	)
+
+	if err := binary.Read(in, binary.BigEndian, &seq); err != nil {
+		return err
+	}
+
+	if err := binary.Read(in, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	end := count == _endOfSession
+
+	if end {
+		count = 0
+	}
+
+	messages := make([][]byte, 0, count)
+
+	for ; count > 0; count-- {
+		var size uint16
+
+		if err := binary.Read(in, binary.BigEndian, &size); err != nil {
+			return err
+		}
+
+		// copied, the buffer may be reused
+		m := append([]byte(nil), in.Next(int(size))...)
+
+		if len(m) != int(size) {
+			return errPacketSize
+		}
+
+		messages = append(messages, m)
+	}
+
+	p.session = session
+	p.seq = seq
+	p.messages = messages
+	p.end = end
+
+	return nil
+}
+
+// Splits messages starting at `seq` into packets of at most `MaxPacketSize` bytes.
+func pack(
+	session string,
+	seq uint64,
+	messages [][]byte,
+) []*Packet {
+	var (
+		packets []*Packet
+		current *Packet
+		size    int
+	)
+
+	for _, m := range messages {
+		if current == nil || size+2+len(m) > MaxPacketSize {
+			current = &Packet{
+				session: session,
+				seq:     seq,
+			}
+			packets = append(packets, current)
+			size = _headerSize
+		}
+
+		current.messages = append(current.messages, m)
+		size += 2 + len(m)
+		seq++
+	}
+
+	return packets
+}
+
+/*
+ * MoldUDP64 request packet: session (10), first seq (8), count (2).
+ * Sent to the retransmission server, which replies with downstream packets.
+ */
+type Request struct {
+	session string
+	seq     uint64
+	count   uint16
+	_       struct{}
+}
+
+func NewRequest(
+	session string,
+	seq uint64,
+	count uint16,
+) *Request {
+	return &Request{
+		session: session,
+		seq:     seq,
+		count:   count,
+	}
+}
+
+func (r *Request) Session() string {
+	return r.session
+}
+
+func (r *Request) Seq() uint64 {
+	return r.seq
+}
+
+func (r *Request) Count() uint16 {
+	return r.count
+}
+
+func (r *Request) Marshal(out *bytes.Buffer) error {
+	if err := writeSession(r.session, out); err != nil {
+		return err
+	}
+
+	if err := binary.Write(out, binary.BigEndian, r.seq); err != nil {
+		return err
+	}
+
+	if err := binary.Write(out, binary.BigEndian, r.count); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Request) Unmarshal(in *bytes.Buffer) error {
+	session, err := readSession(in)
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		seq   uint64
+		count uint16
+	)
+
+	if err := binary.Read(in, binary.BigEndian, &seq); err != nil {
+		return err
+	}
+
+	if err := binary.Read(in, binary.BigEndian, &count); err != nil {
+		return err
+	}
+
+	r.session = session
+	r.seq = seq
+	r.count = count
+
+	return nil
+}
+
+// Session is padded with spaces (alphanumeric, as in MoldUDP64).
+func writeSession(
+	session string,
+	out *bytes.Buffer,
+) error {
+	if len(session) > _sessionSize {
+		return fmt.Errorf("session: %v", session)
+	}
+
+	b := bytes.Repeat([]byte{' '}, _sessionSize)
+	copy(b, session)
+	_, err := out.Write(b)
+
+	return err
+}
+
+func readSession(in *bytes.Buffer) (string, error) {
+	b := in.Next(_sessionSize)
+
+	if len(b) != _sessionSize {
+		return "", errPacketSize
+	}
+
+	return string(bytes.TrimRight(b, " ")), nil
+}
@@ -0,0 +1,237 @@
+package itch
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/xerexchain/matching-engine/bus"
+)
+
+// TODO rate limit of retransmissions per requester
+
+// Messages kept for retransmission.
+const DefaultHistorySize = 1 << 20
+
+var ErrInvalidHistorySize = errors.New("invalid history size")
+
+/*
+ * Sends market data of published envelopes as MoldUDP64 packets.
+ * `conn` is connected to the multicast group or the unicast address of the feed.
+ * The last `historySize` messages are kept for retransmission requests.
+ * Message seqs start at 1.
+ */
+type Publisher struct {
+	conn        net.Conn
+	session     string
+	nextSeq     uint64
+	history     [][]byte // ring of messages by seq
+	historySize uint64
+	mu          sync.Mutex
+	_           struct{}
+}
+
+// `historySize` must be positive.
+func NewPublisher(
+	conn net.Conn,
+	session string,
+	historySize int,
+) (*Publisher, error) {
+	if historySize <= 0 {
+		return nil, ErrInvalidHistorySize
+	}
+
+	return &Publisher{
+		conn:        conn,
+		session:     session,
+		nextSeq:     1,
+		history:     make([][]byte, historySize),
+		historySize: uint64(historySize),
+	}, nil
+}
+
+func (p *Publisher) NextSeq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.nextSeq
+}
+
+func (p *Publisher) Publish(e *bus.Envelope) error {
+	messages := Messages(e)
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	encoded := make([][]byte, 0, len(messages))
+
+	for _, m := range messages {
+		out := &bytes.Buffer{}
+
+		if err := m.Marshal(out); err != nil {
+			return err
+		}
+
+		encoded = append(encoded, out.Bytes())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seq := p.nextSeq
+
+	for _, m := range encoded {
+		p.history[p.nextSeq%p.historySize] = m
+		p.nextSeq++
+	}
+
+	return send(p.conn, pack(p.session, seq, encoded))
+}
+
+// Consumes the subscriber until the bus is closed.
+func (p *Publisher) Run(sub *bus.Subscriber) error {
+	for {
+		envelopes, err := sub.Next(64)
+
+		if errors.Is(err, bus.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, e := range envelopes {
+			if err := p.Publish(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Lets idle consumers detect lost packets.
+func (p *Publisher) Heartbeat() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return send(p.conn, []*Packet{{
+		session: p.session,
+		seq:     p.nextSeq,
+	}})
+}
+
+func (p *Publisher) EndOfSession() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return send(p.conn, []*Packet{{
+		session: p.session,
+		seq:     p.nextSeq,
+		end:     true,
+	}})
+}
+
+/*
+ * Retransmission server, replies to requests with packets
+ * sent to the address of the requester.
+ * Messages no longer kept are skipped,
+ * the reply starts at the oldest kept message of the range.
+ */
+func (p *Publisher) ServeRetransmits(conn net.PacketConn) error {
+	b := make([]byte, MaxPacketSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(b)
+
+		if err != nil {
+			return err
+		}
+
+		req := &Request{}
+
+		if err := req.Unmarshal(bytes.NewBuffer(b[:n])); err != nil {
+			log.Printf("retransmit request: %v", err)
+
+			continue
+		}
+
+		if req.session != p.session {
+			continue
+		}
+
+		seq, messages := p.retransmit(req.seq, uint64(req.count))
+
+		for _, packet := range pack(p.session, seq, messages) {
+			out := &bytes.Buffer{}
+
+			if err := packet.Marshal(out); err != nil {
+				return err
+			}
+
+			if _, err := conn.WriteTo(out.Bytes(), addr); err != nil {
+				log.Printf("retransmit: %v", err)
+			}
+		}
+	}
+}
+
+func (p *Publisher) retransmit(
+	seq uint64,
+	count uint64,
+) (uint64, [][]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	oldest := uint64(1)
+
+	if p.nextSeq > p.historySize {
+		oldest = p.nextSeq - p.historySize
+	}
+
+	if seq < oldest {
+		count -= min(count, oldest-seq)
+		seq = oldest
+	}
+
+	if seq+count > p.nextSeq {
+		count = p.nextSeq - min(seq, p.nextSeq)
+	}
+
+	messages := make([][]byte, 0, count)
+
+	for i := uint64(0); i < count; i++ {
+		messages = append(messages, p.history[(seq+i)%p.historySize])
+	}
+
+	return seq, messages
+}
+
+func send(
+	conn net.Conn,
+	packets []*Packet,
+) error {
+	for _, packet := range packets {
+		out := &bytes.Buffer{}
+
+		if err := packet.Marshal(out); err != nil {
+			return err
+		}
+
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,121 @@
+package ledger
+
+type AccountType int8
+
+const (
+	// id is the user id
+	UserAccount AccountType = iota + 1
+
+	// Assets held in external custody, the negative of deposits less withdrawals.
+	// id is 0
+	CustodyAccount
+
+	// Counterpart of realized profit of trades, id is the symbol id.
+	ClearingAccount
+
+	// Counterpart of funding payments, id is the symbol id.
+	FundingAccount
+
+	// Fee revenue, id is 0.
+	FeeAccount
+
+	// Counterpart of manual adjustments, id is 0.
+	AdjustmentAccount
+
+	// Balances moved out of a suspended profile, id is the user id.
+	SuspendedAccount
+)
+
+var _accountTypes = map[int8]AccountType{
+	int8(UserAccount):       UserAccount,
+	int8(CustodyAccount):    CustodyAccount,
+	int8(ClearingAccount):   ClearingAccount,
+	int8(FundingAccount):    FundingAccount,
+	int8(FeeAccount):        FeeAccount,
+	int8(AdjustmentAccount): AdjustmentAccount,
+	int8(SuspendedAccount):  SuspendedAccount,
+}
+
+func AccountTypeFrom(code int8) (AccountType, bool) {
+	kind, ok := _accountTypes[code]
+
+	return kind, ok
+}
+
+// Account of all currencies, comparable for use as a key.
+type Account struct {
+	kind AccountType
+	id   int64
+	_    struct{}
+}
+
+func NewAccount(kind AccountType, id int64) Account {
+	return Account{
+		kind: kind,
+		id:   id,
+	}
+}
+
+func User(userID int64) Account {
+	return NewAccount(UserAccount, userID)
+}
+
+func Custody() Account {
+	return NewAccount(CustodyAccount, 0)
+}
+
+func Clearing(symbolID int32) Account {
+	return NewAccount(ClearingAccount, int64(symbolID))
+}
+
+func Funding(symbolID int32) Account {
+	return NewAccount(FundingAccount, int64(symbolID))
+}
+
+func Fees() Account {
+	return NewAccount(FeeAccount, 0)
+}
+
+func Adjustments() Account {
+	return NewAccount(AdjustmentAccount, 0)
+}
+
+func Suspended(userID int64) Account {
+	return NewAccount(SuspendedAccount, userID)
+}
+
+func (a Account) Type() AccountType {
+	return a.kind
+}
+
+func (a Account) ID() int64 {
+	return a.id
+}
+
+type EntryType int8
+
+const (
+	Deposit EntryType = iota + 1
+	Withdrawal
+	Trade
+	Fee
+	FundingPayment
+	Adjustment
+	Transfer
+)
+
+var _entryTypes = map[int8]EntryType{
+	int8(Deposit):        Deposit,
+	int8(Withdrawal):     Withdrawal,
+	int8(Trade):          Trade,
+	int8(Fee):            Fee,
+	int8(FundingPayment): FundingPayment,
+	int8(Adjustment):     Adjustment,
+	int8(Transfer):       Transfer,
+}
+
+func EntryTypeFrom(code int8) (EntryType, bool) {
+	kind, ok := _entryTypes[code]
+
+	return kind, ok
+}
@@ -0,0 +1,638 @@
+package ledger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+var (
+	ErrAmount  = errors.New("amount")
+	ErrAccount = errors.New("account")
+)
+
+// Entries kept in the journal, older ones are compacted into opening balances on post.
+const JournalSize = 1 << 20
+
+// `amount` of `currency` moved from one account to another.
+type Entry struct {
+	id          int64
+	kind        EntryType
+	currency    int32
+	from        Account
+	to          Account
+	amount      int64 // positive
+	timestampNS int64
+	_           struct{}
+}
+
+func (e *Entry) ID() int64 {
+	return e.id
+}
+
+func (e *Entry) Type() EntryType {
+	return e.kind
+}
+
+func (e *Entry) Currency() int32 {
+	return e.currency
+}
+
+func (e *Entry) From() Account {
+	return e.from
+}
+
+func (e *Entry) To() Account {
+	return e.to
+}
+
+func (e *Entry) Amount() int64 {
+	return e.amount
+}
+
+func (e *Entry) TimestampNS() int64 {
+	return e.timestampNS
+}
+
+// Change of the balance of `account` by the entry.
+func (e *Entry) AmountOf(account Account) int64 {
+	switch account {
+	case e.to:
+		return e.amount
+	case e.from:
+		return -e.amount
+	default:
+		return 0
+	}
+}
+
+/*
+ * Double-entry journal of balances. Each entry debits one account
+ * and credits another by the same amount, so the balances
+ * of all accounts sum to zero per currency.
+ * Old entries are compacted into opening balances, see `Compact`.
+ * Balances are rebuilt from the opening balances and the journal on unmarshal.
+ */
+type Ledger struct {
+	journal   []*Entry
+	opening   map[Account]map[int32]int64 // account -> currency -> balance of compacted entries
+	compacted int64                       // number of compacted entries
+	balances  map[Account]map[int32]int64 // account -> currency -> balance
+	entries   map[Account][]*Entry        // account -> entries, oldest first
+	_         struct{}
+}
+
+func New() *Ledger {
+	return &Ledger{
+		opening:  make(map[Account]map[int32]int64),
+		balances: make(map[Account]map[int32]int64),
+		entries:  make(map[Account][]*Entry),
+	}
+}
+
+func (l *Ledger) Reset() {
+	l.journal = nil
+	l.opening = make(map[Account]map[int32]int64)
+	l.compacted = 0
+	l.balances = make(map[Account]map[int32]int64)
+	l.entries = make(map[Account][]*Entry)
+}
+
+// Moves `amount` from `from` to `to`.
+func (l *Ledger) Post(
+	kind EntryType,
+	currency int32,
+	from Account,
+	to Account,
+	amount int64,
+	timestampNS int64,
+) (*Entry, error) {
+	if amount <= 0 {
+		return nil, ErrAmount
+	}
+
+	if from == to {
+		return nil, ErrAccount
+	}
+
+	entry := &Entry{
+		id:          l.compacted + int64(len(l.journal)) + 1,
+		kind:        kind,
+		currency:    currency,
+		from:        from,
+		to:          to,
+		amount:      amount,
+		timestampNS: timestampNS,
+	}
+	l.apply(entry)
+
+	if len(l.journal) > JournalSize {
+		l.Compact(JournalSize / 2)
+	}
+
+	return entry, nil
+}
+
+func (l *Ledger) apply(entry *Entry) {
+	l.journal = append(l.journal, entry)
+
+	for _, account := range []Account{entry.from, entry.to} {
+		add(l.balances, account, entry.currency, entry.AmountOf(account))
+		l.entries[account] = append(l.entries[account], entry)
+	}
+}
+
+func add(
+	balances map[Account]map[int32]int64,
+	account Account,
+	currency int32,
+	amount int64,
+) {
+	byCurrency, ok := balances[account]
+
+	if !ok {
+		byCurrency = make(map[int32]int64)
+		balances[account] = byCurrency
+	}
+
+	byCurrency[currency] += amount
+}
+
+/*
+ * Folds all but the last `keep` entries of the journal into opening balances,
+ * balances are not changed. Ids of later entries continue after the compacted ones.
+ * Returns the number of compacted entries.
+ */
+func (l *Ledger) Compact(keep int) int {
+	if keep < 0 {
+		keep = 0
+	}
+
+	size := len(l.journal) - keep
+
+	if size <= 0 {
+		return 0
+	}
+
+	for _, entry := range l.journal[:size] {
+		for _, account := range []Account{entry.from, entry.to} {
+			add(l.opening, account, entry.currency, entry.AmountOf(account))
+		}
+	}
+
+	l.journal = append([]*Entry(nil), l.journal[size:]...)
+	l.compacted += int64(size)
+
+	for account, entries := range l.entries {
+		i := sort.Search(len(entries), func(i int) bool {
+			return entries[i].id > l.compacted
+		})
+
+		if i == len(entries) {
+			delete(l.entries, account)
+		} else {
+			l.entries[account] = append([]*Entry(nil), entries[i:]...)
+		}
+	}
+
+	return size
+}
+
+// Number of entries folded into opening balances.
+func (l *Ledger) Compacted() int64 {
+	return l.compacted
+}
+
+// Balance of the account carried over from compacted entries.
+func (l *Ledger) OpeningBalance(
+	account Account,
+	currency int32,
+) int64 {
+	return l.opening[account][currency]
+}
+
+func (l *Ledger) Balance(
+	account Account,
+	currency int32,
+) int64 {
+	return l.balances[account][currency]
+}
+
+// Balance report of the account, currency -> balance.
+func (l *Ledger) Balances(account Account) map[int32]int64 {
+	balances := make(map[int32]int64, len(l.balances[account]))
+
+	for currency, balance := range l.balances[account] {
+		balances[currency] = balance
+	}
+
+	return balances
+}
+
+// Last `limit` entries of the account (all kept if 0), oldest first.
+func (l *Ledger) History(
+	account Account,
+	limit int32,
+) []*Entry {
+	entries := l.entries[account]
+
+	if limit <= 0 || int(limit) >= len(entries) {
+		return entries
+	}
+
+	return entries[len(entries)-int(limit):]
+}
+
+// Balance of a user in the ledger differing from the balance of the profile.
+type Mismatch struct {
+	userID  int64
+	ledger  int64
+	profile int64
+	_       struct{}
+}
+
+func (m *Mismatch) UserID() int64 {
+	return m.userID
+}
+
+func (m *Mismatch) Ledger() int64 {
+	return m.ledger
+}
+
+func (m *Mismatch) Profile() int64 {
+	return m.profile
+}
+
+/*
+ * Balances of a currency summed by account type, checked against
+ * balances of user profiles and assets held in external custody.
+ * Custody holds the negative of deposits less withdrawals.
+ */
+type Reconciliation struct {
+	currency   int32
+	totals     map[AccountType]int64
+	held       int64
+	mismatches []*Mismatch // sorted by user id
+	_          struct{}
+}
+
+func (r *Reconciliation) Currency() int32 {
+	return r.currency
+}
+
+func (r *Reconciliation) TotalOf(kind AccountType) int64 {
+	return r.totals[kind]
+}
+
+// Assets that must be held in external custody.
+func (r *Reconciliation) Custody() int64 {
+	return -r.totals[CustodyAccount]
+}
+
+// Assets held in external custody.
+func (r *Reconciliation) Held() int64 {
+	return r.held
+}
+
+// Difference of assets held in external custody to the ledger, 0 if they match.
+func (r *Reconciliation) Discrepancy() int64 {
+	return r.held - r.Custody()
+}
+
+func (r *Reconciliation) Mismatches() []*Mismatch {
+	return r.mismatches
+}
+
+// Custody and balances of all profiles match the ledger.
+func (r *Reconciliation) IsBalanced() bool {
+	return r.Discrepancy() == 0 && len(r.mismatches) == 0
+}
+
+/*
+ * Reconciliation per currency, sorted by currency.
+ * `profiles` are balances of user profiles (user id -> currency -> balance),
+ * `held` are assets held in external custody (currency -> amount).
+ */
+func (l *Ledger) Reconcile(
+	profiles map[int64]map[int32]int64,
+	held map[int32]int64,
+) []*Reconciliation {
+	byCurrency := make(map[int32]*Reconciliation)
+
+	reconciliationOf := func(currency int32) *Reconciliation {
+		r, ok := byCurrency[currency]
+
+		if !ok {
+			r = &Reconciliation{
+				currency: currency,
+				totals:   make(map[AccountType]int64),
+				held:     held[currency],
+			}
+			byCurrency[currency] = r
+		}
+
+		return r
+	}
+
+	for account, balances := range l.balances {
+		for currency, balance := range balances {
+			reconciliationOf(currency).totals[account.kind] += balance
+		}
+	}
+
+	for currency := range held {
+		reconciliationOf(currency)
+	}
+
+	userIDs := make(map[int64]struct{}, len(profiles))
+
+	for userID, balances := range profiles {
+		userIDs[userID] = struct{}{}
+
+		for currency := range balances {
+			reconciliationOf(currency)
+		}
+	}
+
+	for account := range l.balances {
+		if account.kind == UserAccount {
+			userIDs[account.id] = struct{}{}
+		}
+	}
+
+	for userID := range userIDs {
+		for currency, r := range byCurrency {
+			balance, profile := l.Balance(User(userID), currency), profiles[userID][currency]
+
+			if balance != profile {
+				r.mismatches = append(r.mismatches, &Mismatch{
+					userID:  userID,
+					ledger:  balance,
+					profile: profile,
+				})
+			}
+		}
+	}
+
+	reports := make([]*Reconciliation, 0, len(byCurrency))
+
+	for _, r := range byCurrency {
+		sort.Slice(r.mismatches, func(i, j int) bool {
+			return r.mismatches[i].userID < r.mismatches[j].userID
+		})
+		reports = append(reports, r)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].currency < reports[j].currency
+	})
+
+	return reports
+}
+
+func (l *Ledger) Marshal(out *bytes.Buffer) error {
+	if err := l.marshalOpening(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(l.journal)), out); err != nil {
+		return err
+	}
+
+	for _, entry := range l.journal {
+		if err := serialization.WriteInt8(int8(entry.kind), out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(entry.currency, out); err != nil {
+			return err
+		}
+
+		for _, account := range []Account{entry.from, entry.to} {
+			if err := serialization.WriteInt8(int8(account.kind), out); err != nil {
+				return err
+			}
+
+			if err := serialization.WriteInt64(account.id, out); err != nil {
+				return err
+			}
+		}
+
+		if err := serialization.WriteInt64(entry.amount, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(entry.timestampNS, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Ledger) Unmarshal(in *bytes.Buffer) error {
+	ledger := New()
+
+	if err := ledger.unmarshalOpening(in); err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	for i := int32(1); i <= size; i++ {
+		code, err := serialization.ReadInt8(in)
+
+		if err != nil {
+			return err
+		}
+
+		kind, ok := EntryTypeFrom(code)
+
+		if !ok {
+			return fmt.Errorf("Ledger.Unmarshal: entry type: %v", code)
+		}
+
+		currency, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		var accounts [2]Account
+
+		for j := range accounts {
+			code, err := serialization.ReadInt8(in)
+
+			if err != nil {
+				return err
+			}
+
+			accountType, ok := AccountTypeFrom(code)
+
+			if !ok {
+				return fmt.Errorf("Ledger.Unmarshal: account type: %v", code)
+			}
+
+			id, err := serialization.ReadInt64(in)
+
+			if err != nil {
+				return err
+			}
+
+			accounts[j] = NewAccount(accountType, id)
+		}
+
+		amount, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		timestampNS, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		ledger.apply(&Entry{
+			id:          ledger.compacted + int64(i),
+			kind:        kind,
+			currency:    currency,
+			from:        accounts[0],
+			to:          accounts[1],
+			amount:      amount,
+			timestampNS: timestampNS,
+		})
+	}
+
+	l.journal = ledger.journal
+	l.opening = ledger.opening
+	l.compacted = ledger.compacted
+	l.balances = ledger.balances
+	l.entries = ledger.entries
+
+	return nil
+}
+
+// Non-zero opening balances sorted by account and currency.
+func (l *Ledger) marshalOpening(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(l.compacted, out); err != nil {
+		return err
+	}
+
+	type opening struct {
+		account  Account
+		currency int32
+		balance  int64
+	}
+
+	var balances []opening
+
+	for account, byCurrency := range l.opening {
+		for currency, balance := range byCurrency {
+			if balance != 0 {
+				balances = append(balances, opening{account, currency, balance})
+			}
+		}
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		a, b := balances[i], balances[j]
+
+		if a.account.kind != b.account.kind {
+			return a.account.kind < b.account.kind
+		}
+
+		if a.account.id != b.account.id {
+			return a.account.id < b.account.id
+		}
+
+		return a.currency < b.currency
+	})
+
+	if err := serialization.WriteInt32(int32(len(balances)), out); err != nil {
+		return err
+	}
+
+	for _, b := range balances {
+		if err := serialization.WriteInt8(int8(b.account.kind), out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(b.account.id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(b.currency, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(b.balance, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Opening balances are added to the balances of the (new) ledger.
+func (l *Ledger) unmarshalOpening(in *bytes.Buffer) error {
+	compacted, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	for ; size > 0; size-- {
+		code, err := serialization.ReadInt8(in)
+
+		if err != nil {
+			return err
+		}
+
+		kind, ok := AccountTypeFrom(code)
+
+		if !ok {
+			return fmt.Errorf("Ledger.Unmarshal: account type: %v", code)
+		}
+
+		id, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		currency, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		balance, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		account := NewAccount(kind, id)
+		add(l.opening, account, currency, balance)
+		add(l.balances, account, currency, balance)
+	}
+
+	l.compacted = compacted
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package ledger
+
+import (
+	"bytes"
+	"testing"
+)
+
+const currency int32 = 840
+
+type post struct {
+	kind   EntryType
+	from   Account
+	to     Account
+	amount int64
+}
+
+func newLedger(t *testing.T, posts ...post) *Ledger {
+	l := New()
+
+	for i, p := range posts {
+		if _, err := l.Post(p.kind, currency, p.from, p.to, p.amount, int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return l
+}
+
+func TestReconcile(t *testing.T) {
+	deposits := []post{
+		{Deposit, Custody(), User(1), 100},
+		{Deposit, Custody(), User(2), 50},
+		{Transfer, User(1), User(2), 30},
+	}
+
+	tests := []struct {
+		name        string
+		profiles    map[int64]map[int32]int64
+		held        int64
+		discrepancy int64
+		mismatches  [][3]int64 // user id, ledger, profile
+	}{
+		{
+			name:     "balanced",
+			profiles: map[int64]map[int32]int64{1: {currency: 70}, 2: {currency: 80}},
+			held:     150,
+		},
+		{
+			name:        "custody short",
+			profiles:    map[int64]map[int32]int64{1: {currency: 70}, 2: {currency: 80}},
+			held:        140,
+			discrepancy: -10,
+		},
+		{
+			name:       "profile differs",
+			profiles:   map[int64]map[int32]int64{1: {currency: 70}, 2: {currency: 90}},
+			held:       150,
+			mismatches: [][3]int64{{2, 80, 90}},
+		},
+		{
+			name:       "profile missing",
+			profiles:   map[int64]map[int32]int64{2: {currency: 80}},
+			held:       150,
+			mismatches: [][3]int64{{1, 70, 0}},
+		},
+		{
+			name:       "balance not journaled",
+			profiles:   map[int64]map[int32]int64{1: {currency: 70}, 2: {currency: 80}, 3: {currency: 5}},
+			held:       150,
+			mismatches: [][3]int64{{3, 0, 5}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLedger(t, deposits...)
+			reports := l.Reconcile(test.profiles, map[int32]int64{currency: test.held})
+
+			if len(reports) != 1 {
+				t.Fatalf("%v reports, want 1", len(reports))
+			}
+
+			r := reports[0]
+
+			if r.Custody() != 150 {
+				t.Errorf("custody %v, want 150", r.Custody())
+			}
+
+			if r.Discrepancy() != test.discrepancy {
+				t.Errorf("discrepancy %v, want %v", r.Discrepancy(), test.discrepancy)
+			}
+
+			if len(r.Mismatches()) != len(test.mismatches) {
+				t.Fatalf("%v mismatches, want %v", len(r.Mismatches()), len(test.mismatches))
+			}
+
+			for i, m := range r.Mismatches() {
+				if got := [3]int64{m.UserID(), m.Ledger(), m.Profile()}; got != test.mismatches[i] {
+					t.Errorf("mismatch %v: %v, want %v", i, got, test.mismatches[i])
+				}
+			}
+
+			if balanced := test.discrepancy == 0 && len(test.mismatches) == 0; r.IsBalanced() != balanced {
+				t.Errorf("balanced %v, want %v", r.IsBalanced(), balanced)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	posts := []post{
+		{Deposit, Custody(), User(1), 100},
+		{Deposit, Custody(), User(2), 50},
+		{Transfer, User(1), User(2), 30},
+		{Withdrawal, User(2), Custody(), 20},
+	}
+
+	tests := []struct {
+		keep      int
+		compacted int
+		history   []int64 // entry ids of user 2
+	}{
+		{keep: 4, compacted: 0, history: []int64{2, 3, 4}},
+		{keep: 2, compacted: 2, history: []int64{3, 4}},
+		{keep: 1, compacted: 3, history: []int64{4}},
+		{keep: 0, compacted: 4},
+	}
+
+	for _, test := range tests {
+		l := newLedger(t, posts...)
+
+		if compacted := l.Compact(test.keep); compacted != test.compacted {
+			t.Errorf("keep %v: compacted %v, want %v", test.keep, compacted, test.compacted)
+		}
+
+		entry, err := l.Post(Deposit, currency, Custody(), User(1), 1, 4)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.ID() != 5 {
+			t.Errorf("keep %v: entry id %v, want 5", test.keep, entry.ID())
+		}
+
+		out := &bytes.Buffer{}
+
+		if err := l.Marshal(out); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := New()
+
+		if err := restored.Unmarshal(out); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, ledger := range []*Ledger{l, restored} {
+			balances := [3]int64{
+				ledger.Balance(User(1), currency),
+				ledger.Balance(User(2), currency),
+				ledger.Balance(Custody(), currency),
+			}
+
+			if balances != [3]int64{71, 60, -131} {
+				t.Errorf("keep %v: balances %v, want [71 60 -131]", test.keep, balances)
+			}
+
+			history := ledger.History(User(2), 0)
+
+			if len(history) != len(test.history) {
+				t.Fatalf("keep %v: %v entries, want %v", test.keep, len(history), len(test.history))
+			}
+
+			for i, entry := range history {
+				if entry.ID() != test.history[i] {
+					t.Errorf("keep %v: entry %v id %v, want %v", test.keep, i, entry.ID(), test.history[i])
+				}
+			}
+		}
+
+		if got := restored.OpeningBalance(User(2), currency); got != l.OpeningBalance(User(2), currency) {
+			t.Errorf("keep %v: opening balance %v, want %v", test.keep, got, l.OpeningBalance(User(2), currency))
+		}
+	}
+}
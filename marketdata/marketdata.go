@@ -0,0 +1,323 @@
+package marketdata
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// Updates of a symbol between periodic snapshots.
+const DefaultSnapshotInterval int64 = 1000
+
+/*
+ * Incremental L2 update of a symbol, deltas of one command.
+ * Seqs are contiguous per symbol, a gap means lost updates:
+ * the client waits for a snapshot and drops updates
+ * with seq not greater than the seq of the snapshot.
+ */
+type Update struct {
+	symbolID    int32
+	seq         int64
+	timestampNS int64
+	deltas      []*orderbook.L2Delta
+	_           struct{}
+}
+
+func (u *Update) SymbolID() int32 {
+	return u.symbolID
+}
+
+func (u *Update) Seq() int64 {
+	return u.seq
+}
+
+func (u *Update) TimestampNS() int64 {
+	return u.timestampNS
+}
+
+func (u *Update) Deltas() []*orderbook.L2Delta {
+	return u.deltas
+}
+
+// Full book of a symbol, including the update of `seq`.
+type Snapshot struct {
+	symbolID    int32
+	seq         int64
+	timestampNS int64
+	data        *orderbook.L2MarketData
+	_           struct{}
+}
+
+func (s *Snapshot) SymbolID() int32 {
+	return s.symbolID
+}
+
+func (s *Snapshot) Seq() int64 {
+	return s.seq
+}
+
+func (s *Snapshot) TimestampNS() int64 {
+	return s.timestampNS
+}
+
+func (s *Snapshot) Data() *orderbook.L2MarketData {
+	return s.data
+}
+
+/*
+ * Market by order update of a symbol, order-level changes of one command.
+ * Seqs are contiguous per symbol and independent of L2 seqs.
+ */
+type L3Update struct {
+	symbolID    int32
+	seq         int64
+	timestampNS int64
+	events      []*orderbook.L3Event
+	_           struct{}
+}
+
+func (u *L3Update) SymbolID() int32 {
+	return u.symbolID
+}
+
+func (u *L3Update) Seq() int64 {
+	return u.seq
+}
+
+func (u *L3Update) TimestampNS() int64 {
+	return u.timestampNS
+}
+
+func (u *L3Update) Events() []*orderbook.L3Event {
+	return u.events
+}
+
+// Resting orders of a symbol in priority order, including the L3 update of `seq`.
+type L3Snapshot struct {
+	symbolID    int32
+	seq         int64
+	timestampNS int64
+	orders      []*orderbook.L3Order
+	_           struct{}
+}
+
+func (s *L3Snapshot) SymbolID() int32 {
+	return s.symbolID
+}
+
+func (s *L3Snapshot) Seq() int64 {
+	return s.seq
+}
+
+func (s *L3Snapshot) TimestampNS() int64 {
+	return s.timestampNS
+}
+
+func (s *L3Snapshot) Orders() []*orderbook.L3Order {
+	return s.orders
+}
+
+/*
+ * Numbers L2 and L3 updates per symbol and emits a full L2 snapshot
+ * every `snapshotInterval` L2 updates (never if 0).
+ * L3 snapshots are served on request only.
+ */
+type Feed struct {
+	snapshotInterval int64
+	seqs             map[int32]int64 // symbolID -> seq of the last L2 update
+	l3Seqs           map[int32]int64 // symbolID -> seq of the last L3 update
+	_                struct{}
+}
+
+func NewFeed(snapshotInterval int64) *Feed {
+	return &Feed{
+		snapshotInterval: snapshotInterval,
+		seqs:             make(map[int32]int64),
+		l3Seqs:           make(map[int32]int64),
+	}
+}
+
+// Takes deltas of the book, nil if no level changed.
+func (f *Feed) Publish(
+	book *orderbook.Naive,
+	timestampNS int64,
+) (*Update, *Snapshot) {
+	deltas := book.L2Deltas()
+
+	if len(deltas) == 0 {
+		return nil, nil
+	}
+
+	symbolID := book.Symbol().ID()
+	f.seqs[symbolID]++
+	seq := f.seqs[symbolID]
+
+	update := &Update{
+		symbolID:    symbolID,
+		seq:         seq,
+		timestampNS: timestampNS,
+		deltas:      deltas,
+	}
+
+	if f.snapshotInterval > 0 && seq%f.snapshotInterval == 0 {
+		return update, f.Snapshot(book, timestampNS)
+	}
+
+	return update, nil
+}
+
+// Snapshot at the last update, e.g. for recovery of a client.
+func (f *Feed) Snapshot(
+	book *orderbook.Naive,
+	timestampNS int64,
+) *Snapshot {
+	symbolID := book.Symbol().ID()
+
+	return &Snapshot{
+		symbolID:    symbolID,
+		seq:         f.seqs[symbolID],
+		timestampNS: timestampNS,
+		data:        orderbook.L2MarketDataSnapshot(book, math.MaxInt32),
+	}
+}
+
+// Takes order-level changes of the book, nil if none.
+func (f *Feed) PublishL3(
+	book *orderbook.Naive,
+	timestampNS int64,
+) *L3Update {
+	events := book.L3Events()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	symbolID := book.Symbol().ID()
+	f.l3Seqs[symbolID]++
+
+	return &L3Update{
+		symbolID:    symbolID,
+		seq:         f.l3Seqs[symbolID],
+		timestampNS: timestampNS,
+		events:      events,
+	}
+}
+
+// L3 snapshot at the last L3 update, built from `AskOrders` and `BidOrders`.
+func (f *Feed) L3Snapshot(
+	book *orderbook.Naive,
+	timestampNS int64,
+) *L3Snapshot {
+	symbolID := book.Symbol().ID()
+
+	return &L3Snapshot{
+		symbolID:    symbolID,
+		seq:         f.l3Seqs[symbolID],
+		timestampNS: timestampNS,
+		orders:      book.L3Orders(),
+	}
+}
+
+func (f *Feed) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(f.snapshotInterval, out); err != nil {
+		return err
+	}
+
+	if err := marshalSeqs(f.seqs, out); err != nil {
+		return err
+	}
+
+	if err := marshalSeqs(f.l3Seqs, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (f *Feed) Unmarshal(in *bytes.Buffer) error {
+	snapshotInterval, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	seqs, err := unmarshalSeqs(in)
+
+	if err != nil {
+		return err
+	}
+
+	l3Seqs, err := unmarshalSeqs(in)
+
+	if err != nil {
+		return err
+	}
+
+	f.snapshotInterval = snapshotInterval
+	f.seqs = seqs
+	f.l3Seqs = l3Seqs
+
+	return nil
+}
+
+func marshalSeqs(
+	seqs map[int32]int64,
+	out *bytes.Buffer,
+) error {
+	symbolIDs := make([]int32, 0, len(seqs))
+
+	for id := range seqs {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range symbolIDs {
+		if err := serialization.WriteInt32(id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(seqs[id], out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalSeqs(in *bytes.Buffer) (map[int32]int64, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make(map[int32]int64, size)
+
+	for ; size > 0; size-- {
+		symbolID, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		seq, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		seqs[symbolID] = seq
+	}
+
+	return seqs, nil
+}
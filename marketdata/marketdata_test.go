@@ -0,0 +1,272 @@
+package marketdata
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const symbolID int32 = 1
+
+// Book of an exchange pair without fees.
+func newBook(t *testing.T) *orderbook.Naive {
+	out := &bytes.Buffer{}
+
+	if err := serialization.WriteInt8(1, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []int32{symbolID, 1, 2} {
+		if err := serialization.WriteInt32(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// scales, fees
+	for _, v := range []int64{1, 1, 0, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := symbol.Unmarshal(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return orderbook.NewNaive(s.(*symbol.Symbol))
+}
+
+func gtc(
+	orderID int64,
+	action order.Action,
+	price int64,
+	quantity int64,
+) *order.Place {
+	return order.NewPlace(orderID, 1, price, quantity, price, symbolID, 0, action, order.GTC)
+}
+
+func reduce(
+	orderID int64,
+	quantity int64,
+) *order.Reduce {
+	r := order.NewReduce(orderID, symbolID, quantity)
+	r.SetServiceFlags(order.OwnershipOverride)
+
+	return r
+}
+
+func TestL2(t *testing.T) {
+	// kind, action, price, quantity, number of orders
+	type delta [5]int64
+
+	steps := []struct {
+		commands []interface{}
+		seq      int64
+		deltas   []delta
+		snapshot bool
+	}{
+		{
+			commands: []interface{}{
+				gtc(1, order.Bid, 100, 5),
+				gtc(2, order.Bid, 100, 3),
+				gtc(3, order.Ask, 110, 4),
+			},
+			seq: 1,
+			deltas: []delta{
+				{int64(orderbook.L2LevelAdded), int64(order.Ask), 110, 4, 1},
+				{int64(orderbook.L2LevelAdded), int64(order.Bid), 100, 8, 2},
+			},
+		},
+		{
+			commands: []interface{}{reduce(1, 2)},
+			seq:      2,
+			deltas:   []delta{{int64(orderbook.L2LevelChanged), int64(order.Bid), 100, 6, 2}},
+			snapshot: true,
+		},
+		{
+			// levels changed back are skipped
+			commands: []interface{}{gtc(4, order.Ask, 120, 1), reduce(4, 1)},
+			seq:      2,
+		},
+		{
+			commands: []interface{}{gtc(5, order.Ask, 100, 6)},
+			seq:      3,
+			deltas: []delta{
+				{int64(orderbook.L2LevelRemoved), int64(order.Bid), 100, 0, 0},
+			},
+		},
+	}
+
+	book := newBook(t)
+	feed := NewFeed(2)
+
+	for i, step := range steps {
+		for _, c := range step.commands {
+			switch c := c.(type) {
+			case *order.Place:
+				book.PlaceGTC(c)
+			case *order.Reduce:
+				book.Reduce(c)
+			}
+		}
+
+		update, snapshot := feed.Publish(book, int64(i))
+
+		if len(step.deltas) == 0 {
+			if update != nil {
+				t.Errorf("step %v: update %v without changed levels", i, update.Seq())
+			}
+
+			continue
+		}
+
+		if update == nil {
+			t.Fatalf("step %v: no update", i)
+		}
+
+		if update.Seq() != step.seq || update.SymbolID() != symbolID {
+			t.Errorf("step %v: update %v of %v, want %v of %v", i, update.Seq(), update.SymbolID(), step.seq, symbolID)
+		}
+
+		if len(update.Deltas()) != len(step.deltas) {
+			t.Fatalf("step %v: %v deltas, want %v", i, len(update.Deltas()), len(step.deltas))
+		}
+
+		for j, d := range update.Deltas() {
+			got := delta{int64(d.Kind()), int64(d.Action()), d.Price(), d.Quantity(), int64(d.NumOrders())}
+
+			if got != step.deltas[j] {
+				t.Errorf("step %v delta %v: %v, want %v", i, j, got, step.deltas[j])
+			}
+		}
+
+		if (snapshot != nil) != step.snapshot {
+			t.Fatalf("step %v: snapshot %v, want %v", i, snapshot != nil, step.snapshot)
+		}
+
+		if snapshot != nil && snapshot.Seq() != update.Seq() {
+			t.Errorf("step %v: snapshot at %v, want %v", i, snapshot.Seq(), update.Seq())
+		}
+	}
+
+	// order 5 filled the bids and does not rest
+	data := feed.Snapshot(book, 0).Data()
+
+	if data.AskSize() != 1 || data.AskPriceAt(0) != 110 || data.AskQuantityAt(0) != 4 {
+		t.Errorf("snapshot asks: %v levels", data.AskSize())
+	}
+
+	if data.BidSize() != 0 {
+		t.Errorf("snapshot bids: %v levels, want 0", data.BidSize())
+	}
+
+	if seq := feed.Snapshot(book, 0).Seq(); seq != 3 {
+		t.Errorf("snapshot at %v, want 3", seq)
+	}
+}
+
+func TestL3(t *testing.T) {
+	// kind, action, price, remained quantity, executed quantity
+	type change [5]int64
+
+	steps := []struct {
+		commands []interface{}
+		changes  []change
+		public   []int // index of the public id of each change, in order of first appearance
+	}{
+		{
+			commands: []interface{}{gtc(1, order.Bid, 100, 5), gtc(2, order.Bid, 100, 3)},
+			changes: []change{
+				{int64(orderbook.L3OrderAdded), int64(order.Bid), 100, 5, 0},
+				{int64(orderbook.L3OrderAdded), int64(order.Bid), 100, 3, 0},
+			},
+			public: []int{0, 1},
+		},
+		{
+			commands: []interface{}{reduce(1, 2), reduce(2, 3)},
+			changes: []change{
+				{int64(orderbook.L3OrderModified), int64(order.Bid), 100, 3, 0},
+				{int64(orderbook.L3OrderDeleted), int64(order.Bid), 100, 0, 0},
+			},
+			public: []int{0, 1},
+		},
+		{
+			commands: []interface{}{gtc(3, order.Ask, 100, 4)},
+			changes: []change{
+				{int64(orderbook.L3OrderExecuted), int64(order.Bid), 100, 0, 3},
+				{int64(orderbook.L3OrderAdded), int64(order.Ask), 100, 1, 0},
+			},
+			public: []int{0, 2},
+		},
+	}
+
+	book := newBook(t)
+	feed := NewFeed(0)
+
+	// public ids by index of first appearance
+	var publicIDs []int64
+
+	for i, step := range steps {
+		for _, c := range step.commands {
+			switch c := c.(type) {
+			case *order.Place:
+				book.PlaceGTC(c)
+			case *order.Reduce:
+				book.Reduce(c)
+			}
+		}
+
+		update := feed.PublishL3(book, int64(i))
+
+		if update == nil {
+			t.Fatalf("step %v: no update", i)
+		}
+
+		if update.Seq() != int64(i+1) {
+			t.Errorf("step %v: update %v, want %v", i, update.Seq(), i+1)
+		}
+
+		if len(update.Events()) != len(step.changes) {
+			t.Fatalf("step %v: %v events, want %v", i, len(update.Events()), len(step.changes))
+		}
+
+		for j, e := range update.Events() {
+			got := change{int64(e.Kind()), int64(e.Action()), e.Price(), e.Quantity(), e.Executed()}
+
+			if got != step.changes[j] {
+				t.Errorf("step %v event %v: %v, want %v", i, j, got, step.changes[j])
+			}
+
+			if k := step.public[j]; k == len(publicIDs) {
+				publicIDs = append(publicIDs, e.OrderID())
+			} else if e.OrderID() != publicIDs[k] {
+				t.Errorf("step %v event %v: public id %v, want %v", i, j, e.OrderID(), publicIDs[k])
+			}
+		}
+	}
+
+	if publicIDs[0] == publicIDs[1] || publicIDs[1] == publicIDs[2] || publicIDs[0] == publicIDs[2] {
+		t.Errorf("public ids not unique: %v", publicIDs)
+	}
+
+	if update := feed.PublishL3(book, 3); update != nil {
+		t.Errorf("update %v without changes", update.Seq())
+	}
+
+	snapshot := feed.L3Snapshot(book, 3)
+	orders := snapshot.Orders()
+
+	if snapshot.Seq() != 3 || len(orders) != 1 {
+		t.Fatalf("snapshot at %v with %v orders, want 3 with 1", snapshot.Seq(), len(orders))
+	}
+
+	if orders[0].OrderID() != publicIDs[2] || orders[0].Action() != order.Ask || orders[0].Quantity() != 1 {
+		t.Errorf("snapshot order %v: %v of %v", orders[0].OrderID(), orders[0].Action(), orders[0].Quantity())
+	}
+}
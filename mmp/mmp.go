@@ -0,0 +1,582 @@
+package mmp
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// TODO options delta, currently delta is the signed filled quantity
+
+/*
+ * Market Maker Protection (MMP) limits of a user for a group of symbols.
+ * When filled quantity, delta or number of trades of maker orders
+ * within the rolling window exceeds a limit, the protection trips:
+ * resting orders of the group are cancelled and new quotes are frozen for the cooldown.
+ * Time is measured in command timestamps. 0 disables a limit.
+ */
+type Config struct {
+	windowNS    int64
+	maxQuantity int64
+	maxDelta    int64 // absolute value
+	maxTrades   int64
+	cooldownNS  int64
+	_           struct{}
+}
+
+func NewConfig(
+	windowNS int64,
+	maxQuantity int64,
+	maxDelta int64,
+	maxTrades int64,
+	cooldownNS int64,
+) *Config {
+	return &Config{
+		windowNS:    windowNS,
+		maxQuantity: maxQuantity,
+		maxDelta:    maxDelta,
+		maxTrades:   maxTrades,
+		cooldownNS:  cooldownNS,
+	}
+}
+
+func (c *Config) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(c.windowNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(c.maxQuantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(c.maxDelta, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(c.maxTrades, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(c.cooldownNS, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Config) Unmarshal(in *bytes.Buffer) error {
+	windowNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	maxQuantity, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	maxDelta, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	maxTrades, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	cooldownNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	c.windowNS = windowNS
+	c.maxQuantity = maxQuantity
+	c.maxDelta = maxDelta
+	c.maxTrades = maxTrades
+	c.cooldownNS = cooldownNS
+
+	return nil
+}
+
+type fill struct {
+	timestampNS int64
+	quantity    int64
+	delta       int64
+	_           struct{}
+}
+
+// Rolling window state of one user and group.
+type protection struct {
+	userID    int64
+	groupID   int64
+	symbolIDs []int32 // sorted
+	config    Config
+
+	// fills within the window, oldest first
+	fills    []fill
+	quantity int64
+	delta    int64
+
+	frozenUntilNS int64
+	_             struct{}
+}
+
+func (p *protection) prune(timestampNS int64) {
+	i := 0
+
+	for ; i < len(p.fills); i++ {
+		f := p.fills[i]
+
+		if timestampNS-f.timestampNS < p.config.windowNS {
+			break
+		}
+
+		p.quantity -= f.quantity
+		p.delta -= f.delta
+	}
+
+	p.fills = p.fills[i:]
+}
+
+func (p *protection) isBreached() bool {
+	c := p.config
+	delta := p.delta
+
+	if delta < 0 {
+		delta = -delta
+	}
+
+	return (c.maxQuantity > 0 && p.quantity > c.maxQuantity) ||
+		(c.maxDelta > 0 && delta > c.maxDelta) ||
+		(c.maxTrades > 0 && int64(len(p.fills)) > c.maxTrades)
+}
+
+func (p *protection) reset() {
+	p.fills = nil
+	p.quantity = 0
+	p.delta = 0
+}
+
+func (p *protection) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(p.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.groupID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(p.symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, symbolID := range p.symbolIDs {
+		if err := serialization.WriteInt32(symbolID, out); err != nil {
+			return err
+		}
+	}
+
+	if err := p.config.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(p.fills)), out); err != nil {
+		return err
+	}
+
+	for _, f := range p.fills {
+		if err := serialization.WriteInt64(f.timestampNS, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(f.quantity, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(f.delta, out); err != nil {
+			return err
+		}
+	}
+
+	if err := serialization.WriteInt64(p.frozenUntilNS, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *protection) Unmarshal(in *bytes.Buffer) error {
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	groupID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolIDs := make([]int32, 0, size)
+
+	for ; size > 0; size-- {
+		symbolID, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		symbolIDs = append(symbolIDs, symbolID)
+	}
+
+	config := Config{}
+
+	if err := config.Unmarshal(in); err != nil {
+		return err
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		fills    = make([]fill, 0, size)
+		quantity int64
+		delta    int64
+	)
+
+	for ; size > 0; size-- {
+		timestampNS, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		fillQuantity, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		fillDelta, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		fills = append(fills, fill{
+			timestampNS: timestampNS,
+			quantity:    fillQuantity,
+			delta:       fillDelta,
+		})
+		quantity += fillQuantity
+		delta += fillDelta
+	}
+
+	frozenUntilNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	p.userID = userID
+	p.groupID = groupID
+	p.symbolIDs = symbolIDs
+	p.config = config
+	p.fills = fills
+	p.quantity = quantity
+	p.delta = delta
+	p.frozenUntilNS = frozenUntilNS
+
+	return nil
+}
+
+type key struct {
+	userID int64
+	id     int64 // groupID or symbolID
+	_      struct{}
+}
+
+// MMP settings and window state of all users.
+type Registry struct {
+	protections map[key]*protection // (userID, groupID)
+	groups      map[key]int64       // (userID, symbolID) -> groupID
+	_           struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		protections: make(map[key]*protection),
+		groups:      make(map[key]int64),
+	}
+}
+
+// Replaces settings of the group, window state is reset.
+// A symbol belongs to one group of a user.
+func (r *Registry) Set(
+	userID int64,
+	groupID int64,
+	symbolIDs []int32,
+	config *Config,
+) {
+	r.Remove(userID, groupID)
+
+	sorted := append([]int32(nil), symbolIDs...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	for _, symbolID := range sorted {
+		if previous, ok := r.groups[key{userID: userID, id: int64(symbolID)}]; ok {
+			r.removeSymbol(userID, previous, symbolID)
+		}
+
+		r.groups[key{userID: userID, id: int64(symbolID)}] = groupID
+	}
+
+	r.protections[key{userID: userID, id: groupID}] = &protection{
+		userID:    userID,
+		groupID:   groupID,
+		symbolIDs: sorted,
+		config:    *config,
+	}
+}
+
+func (r *Registry) Remove(
+	userID int64,
+	groupID int64,
+) {
+	k := key{userID: userID, id: groupID}
+	p, ok := r.protections[k]
+
+	if !ok {
+		return
+	}
+
+	for _, symbolID := range p.symbolIDs {
+		delete(r.groups, key{userID: userID, id: int64(symbolID)})
+	}
+
+	delete(r.protections, k)
+}
+
+func (r *Registry) removeSymbol(
+	userID int64,
+	groupID int64,
+	symbolID int32,
+) {
+	p, ok := r.protections[key{userID: userID, id: groupID}]
+
+	if !ok {
+		return
+	}
+
+	symbolIDs := make([]int32, 0, len(p.symbolIDs))
+
+	for _, id := range p.symbolIDs {
+		if id != symbolID {
+			symbolIDs = append(symbolIDs, id)
+		}
+	}
+
+	p.symbolIDs = symbolIDs
+}
+
+func (r *Registry) protectionOf(
+	userID int64,
+	symbolID int32,
+) (*protection, bool) {
+	groupID, ok := r.groups[key{userID: userID, id: int64(symbolID)}]
+
+	if !ok {
+		return nil, false
+	}
+
+	p, ok := r.protections[key{userID: userID, id: groupID}]
+
+	return p, ok
+}
+
+// Unfreezes the group before the cooldown ends.
+func (r *Registry) Reset(
+	userID int64,
+	groupID int64,
+) bool {
+	p, ok := r.protections[key{userID: userID, id: groupID}]
+
+	if !ok {
+		return false
+	}
+
+	p.frozenUntilNS = 0
+	p.reset()
+
+	return true
+}
+
+func (r *Registry) IsFrozen(
+	userID int64,
+	symbolID int32,
+	timestampNS int64,
+) bool {
+	p, ok := r.protectionOf(userID, symbolID)
+
+	return ok && timestampNS < p.frozenUntilNS
+}
+
+/*
+ * Symbols of all groups, of any user, that contain the symbol, sorted.
+ * A fill in the symbol may trip such a group and cancel orders in these symbols.
+ */
+func (r *Registry) GroupSymbolIDs(
+	symbolID int32,
+) []int32 {
+	seen := make(map[int32]struct{})
+
+	for k, groupID := range r.groups {
+		if k.id != int64(symbolID) {
+			continue
+		}
+
+		p, ok := r.protections[key{userID: k.userID, id: groupID}]
+
+		if !ok {
+			continue
+		}
+
+		for _, id := range p.symbolIDs {
+			seen[id] = struct{}{}
+		}
+	}
+
+	symbolIDs := make([]int32, 0, len(seen))
+
+	for id := range seen {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	return symbolIDs
+}
+
+/*
+ * Records a fill of a maker order.
+ * Returns symbols of the group if the protection tripped,
+ * resting orders of the user in these symbols must be cancelled.
+ */
+func (r *Registry) Record(
+	userID int64,
+	symbolID int32,
+	makerAction order.Action,
+	quantity int64,
+	timestampNS int64,
+) ([]int32, bool) {
+	p, ok := r.protectionOf(userID, symbolID)
+
+	if !ok || timestampNS < p.frozenUntilNS {
+		return nil, false
+	}
+
+	delta := quantity
+
+	if makerAction == order.Ask {
+		delta = -quantity
+	}
+
+	p.prune(timestampNS)
+	p.fills = append(p.fills, fill{
+		timestampNS: timestampNS,
+		quantity:    quantity,
+		delta:       delta,
+	})
+	p.quantity += quantity
+	p.delta += delta
+
+	if !p.isBreached() {
+		return nil, false
+	}
+
+	p.frozenUntilNS = timestampNS + p.config.cooldownNS
+	p.reset()
+
+	return p.symbolIDs, true
+}
+
+func (r *Registry) Marshal(out *bytes.Buffer) error {
+	keys := make([]key, 0, len(r.protections))
+
+	for k := range r.protections {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].userID != keys[j].userID {
+			return keys[i].userID < keys[j].userID
+		}
+
+		return keys[i].id < keys[j].id
+	})
+
+	if err := serialization.WriteInt32(int32(len(keys)), out); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := r.protections[k].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	protections := make(map[key]*protection, size)
+	groups := make(map[key]int64)
+
+	for ; size > 0; size-- {
+		p := &protection{}
+
+		if err := p.Unmarshal(in); err != nil {
+			return err
+		}
+
+		protections[key{userID: p.userID, id: p.groupID}] = p
+
+		for _, symbolID := range p.symbolIDs {
+			groups[key{userID: p.userID, id: int64(symbolID)}] = p.groupID
+		}
+	}
+
+	r.protections = protections
+	r.groups = groups
+
+	return nil
+}
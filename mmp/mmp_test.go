@@ -0,0 +1,130 @@
+package mmp
+
+import (
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+)
+
+func TestRecord(t *testing.T) {
+	type fill struct {
+		action      order.Action
+		quantity    int64
+		timestampNS int64
+		tripped     bool
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		fills  []fill
+	}{
+		{
+			name:   "quantity limit is exceeded, not reached",
+			config: NewConfig(100, 10, 0, 0, 50),
+			fills: []fill{
+				{order.Bid, 6, 0, false},
+				{order.Ask, 4, 1, false},
+				{order.Bid, 1, 2, true},
+			},
+		},
+		{
+			name:   "trades limit",
+			config: NewConfig(100, 0, 0, 2, 50),
+			fills: []fill{
+				{order.Bid, 1, 0, false},
+				{order.Bid, 1, 1, false},
+				{order.Bid, 1, 2, true},
+			},
+		},
+		{
+			name:   "delta nets opposite fills",
+			config: NewConfig(100, 0, 5, 0, 50),
+			fills: []fill{
+				{order.Bid, 5, 0, false},
+				{order.Ask, 5, 1, false},
+				{order.Ask, 5, 2, false},
+				{order.Ask, 1, 3, true},
+			},
+		},
+		{
+			name:   "fills out of the window are pruned",
+			config: NewConfig(100, 10, 0, 0, 50),
+			fills: []fill{
+				{order.Bid, 8, 0, false},
+				{order.Bid, 8, 100, false},
+				{order.Bid, 3, 199, true},
+			},
+		},
+		{
+			name:   "fills are not recorded while frozen",
+			config: NewConfig(100, 0, 0, 1, 50),
+			fills: []fill{
+				{order.Bid, 1, 0, false},
+				{order.Bid, 1, 1, true},
+				{order.Bid, 1, 2, false},
+				{order.Bid, 1, 50, false},
+				{order.Bid, 1, 51, false},
+				{order.Bid, 1, 52, true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Set(1, 7, []int32{2, 1}, test.config)
+
+			for i, f := range test.fills {
+				symbolIDs, tripped := r.Record(1, 1, f.action, f.quantity, f.timestampNS)
+
+				if tripped != f.tripped {
+					t.Fatalf("fill %v: tripped %v, want %v", i, tripped, f.tripped)
+				}
+
+				if tripped && (len(symbolIDs) != 2 || symbolIDs[0] != 1 || symbolIDs[1] != 2) {
+					t.Errorf("fill %v: symbols %v", i, symbolIDs)
+				}
+
+				if tripped && !r.IsFrozen(1, 2, f.timestampNS) {
+					t.Errorf("fill %v: group not frozen", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	r := NewRegistry()
+	r.Set(1, 7, []int32{1}, NewConfig(100, 0, 0, 1, 50))
+	r.Record(1, 1, order.Bid, 1, 10)
+	r.Record(1, 1, order.Bid, 1, 10)
+
+	tests := []struct {
+		userID      int64
+		symbolID    int32
+		timestampNS int64
+		frozen      bool
+	}{
+		{1, 1, 10, true},
+		{1, 1, 59, true},
+		{1, 1, 60, false},
+		{1, 2, 10, false},
+		{2, 1, 10, false},
+	}
+
+	for _, test := range tests {
+		if frozen := r.IsFrozen(test.userID, test.symbolID, test.timestampNS); frozen != test.frozen {
+			t.Errorf("user %v symbol %v at %v: frozen %v, want %v",
+				test.userID, test.symbolID, test.timestampNS, frozen, test.frozen)
+		}
+	}
+
+	if !r.Reset(1, 7) || r.IsFrozen(1, 1, 10) {
+		t.Error("reset: group frozen")
+	}
+
+	if r.Reset(1, 8) {
+		t.Error("reset of unknown group")
+	}
+}
@@ -18,6 +18,12 @@ type Metadata struct {
 	_            struct{}
 }
 
+// Service flags of `Metadata`.
+const (
+	// Admin and operator commands may act on orders of other users.
+	OwnershipOverride int32 = 1 << iota
+)
+
 func (m *Metadata) Marshal(out *bytes.Buffer) error {
 	if err := serialization.WriteInt64(m.seq, out); err != nil {
 		return err
@@ -92,8 +98,19 @@ type Place struct {
 	timestamp     int64 // TODO make sure filled everywhere
 	action        Action
 	category      Category
-	metadata      Metadata
-	_             struct{}
+
+	// linked order group (OCO, bracket), 0 if not grouped
+	groupID   int64
+	groupRole GroupRole
+
+	// API client session, orders are cancelled on disconnect. 0 if none
+	sessionID int64
+
+	// unique per user, resubmissions are rejected. 0 if none
+	clientOrderID int64
+
+	metadata Metadata
+	_        struct{}
 }
 
 func NewPlace(
@@ -168,6 +185,35 @@ func (p *Place) Category() Category {
 	return p.category
 }
 
+func (p *Place) GroupID() int64 {
+	return p.groupID
+}
+
+func (p *Place) GroupRole() GroupRole {
+	return p.groupRole
+}
+
+func (p *Place) SetGroup(groupID int64, role GroupRole) {
+	p.groupID = groupID
+	p.groupRole = role
+}
+
+func (p *Place) SessionID() int64 {
+	return p.sessionID
+}
+
+func (p *Place) SetSession(sessionID int64) {
+	p.sessionID = sessionID
+}
+
+func (p *Place) ClientOrderID() int64 {
+	return p.clientOrderID
+}
+
+func (p *Place) SetClientOrderID(clientOrderID int64) {
+	p.clientOrderID = clientOrderID
+}
+
 func (p *Place) Seq() int64 {
 	return p.metadata.seq
 }
@@ -180,6 +226,10 @@ func (p *Place) TimestampNS() int64 {
 	return p.metadata.timestampNS
 }
 
+func (p *Place) SetTimestampNS(timestampNS int64) {
+	p.metadata.timestampNS = timestampNS
+}
+
 func (p *Place) Marshal(out *bytes.Buffer) error {
 	if err := p.metadata.Marshal(out); err != nil {
 		return err
@@ -210,12 +260,32 @@ func (p *Place) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
-	actionAndCategory := (int8(p.category) << 1) | int8(p.action)
+	actionAndCategory := (int8(p.category) << 2) | int8(p.action)
 
 	if err := serialization.WriteInt8(actionAndCategory, out); err != nil {
 		return err
 	}
 
+	if err := serialization.WriteInt64(p.groupID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt8(int8(p.groupRole), out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.sessionID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.clientOrderID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.timestamp, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -260,20 +330,58 @@ func (p *Place) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
-	code := actionAndCategory & 0b1
+	code := actionAndCategory & 0b11
 	action, ok := ActionFrom(code)
 
 	if !ok {
 		return fmt.Errorf("unmarshal: action: %v", code)
 	}
 
-	code = (actionAndCategory >> 1) & 0b1111
+	code = (actionAndCategory >> 2) & 0b1111
 	category, ok := categoryFrom(code)
 
 	if !ok {
 		return fmt.Errorf("unmarshal: category : %v", code)
 	}
 
+	groupID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	code, err = serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	var groupRole GroupRole
+
+	if groupID != 0 {
+		if groupRole, ok = GroupRoleFrom(code); !ok {
+			return fmt.Errorf("unmarshal: group role: %v", code)
+		}
+	}
+
+	sessionID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	p.orderID = orderID
 	p.userID = userID
 	p.price = price
@@ -283,6 +391,11 @@ func (p *Place) Unmarshal(in *bytes.Buffer) error {
 	p.userCookie = userCookie
 	p.action = action
 	p.category = category
+	p.groupID = groupID
+	p.groupRole = groupRole
+	p.sessionID = sessionID
+	p.clientOrderID = clientOrderID
+	p.timestamp = timestamp
 
 	return nil
 }
@@ -291,6 +404,10 @@ type Cancel struct {
 	orderID  int64
 	userID   int64
 	symbolID int32
+
+	// identifies the order when `orderID` is 0
+	clientOrderID int64
+
 	metadata Metadata
 	_        struct{}
 }
@@ -315,6 +432,10 @@ func (c *Cancel) SymbolID() int32 {
 	return c.symbolID
 }
 
+func (c *Cancel) ClientOrderID() int64 {
+	return c.clientOrderID
+}
+
 func (p *Cancel) Seq() int64 {
 	return p.metadata.seq
 }
@@ -323,10 +444,22 @@ func (p *Cancel) SetSeq(seq int64) {
 	p.metadata.seq = seq
 }
 
+func (p *Cancel) ServiceFlags() int32 {
+	return p.metadata.serviceFlags
+}
+
+func (p *Cancel) SetServiceFlags(flags int32) {
+	p.metadata.serviceFlags = flags
+}
+
 func (p *Cancel) TimestampNS() int64 {
 	return p.metadata.timestampNS
 }
 
+func (p *Cancel) SetTimestampNS(timestampNS int64) {
+	p.metadata.timestampNS = timestampNS
+}
+
 func (c *Cancel) Marshal(out *bytes.Buffer) error {
 	if err := c.metadata.Marshal(out); err != nil {
 		return err
@@ -341,6 +474,10 @@ func (c *Cancel) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := serialization.WriteInt64(c.clientOrderID, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -355,9 +492,16 @@ func (c *Cancel) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	c.orderID = orderID
 	c.userID = userID
 	c.symbolID = symbolID
+	c.clientOrderID = clientOrderID
 
 	return nil
 }
@@ -367,6 +511,10 @@ type Move struct {
 	userID   int64
 	symbolID int32
 	toPrice  int64
+
+	// identifies the order when `orderID` is 0
+	clientOrderID int64
+
 	metadata Metadata
 	_        struct{}
 }
@@ -391,6 +539,10 @@ func (m *Move) SymbolID() int32 {
 	return m.symbolID
 }
 
+func (m *Move) ClientOrderID() int64 {
+	return m.clientOrderID
+}
+
 func (m *Move) ToPrice() int64 {
 	return m.toPrice
 }
@@ -403,10 +555,22 @@ func (p *Move) SetSeq(seq int64) {
 	p.metadata.seq = seq
 }
 
+func (p *Move) ServiceFlags() int32 {
+	return p.metadata.serviceFlags
+}
+
+func (p *Move) SetServiceFlags(flags int32) {
+	p.metadata.serviceFlags = flags
+}
+
 func (p *Move) TimestampNS() int64 {
 	return p.metadata.timestampNS
 }
 
+func (p *Move) SetTimestampNS(timestampNS int64) {
+	p.metadata.timestampNS = timestampNS
+}
+
 func (m *Move) Marshal(out *bytes.Buffer) error {
 	if err := m.metadata.Marshal(out); err != nil {
 		return err
@@ -425,6 +589,10 @@ func (m *Move) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := serialization.WriteInt64(m.clientOrderID, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -445,10 +613,17 @@ func (m *Move) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	m.orderID = orderID
 	m.userID = userID
 	m.symbolID = symbolID
 	m.toPrice = toPrice
+	m.clientOrderID = clientOrderID
 
 	return nil
 }
@@ -460,6 +635,10 @@ type Reduce struct {
 
 	// quantity to be reduced, not to quantity
 	quantity int64
+
+	// identifies the order when `orderID` is 0
+	clientOrderID int64
+
 	metadata Metadata
 	_        struct{}
 }
@@ -492,6 +671,10 @@ func (r *Reduce) SymbolID() int32 {
 	return r.symbolID
 }
 
+func (r *Reduce) ClientOrderID() int64 {
+	return r.clientOrderID
+}
+
 func (r *Reduce) Quantity() int64 {
 	return r.quantity
 }
@@ -504,10 +687,22 @@ func (p *Reduce) SetSeq(seq int64) {
 	p.metadata.seq = seq
 }
 
+func (p *Reduce) ServiceFlags() int32 {
+	return p.metadata.serviceFlags
+}
+
+func (p *Reduce) SetServiceFlags(flags int32) {
+	p.metadata.serviceFlags = flags
+}
+
 func (p *Reduce) TimestampNS() int64 {
 	return p.metadata.timestampNS
 }
 
+func (p *Reduce) SetTimestampNS(timestampNS int64) {
+	p.metadata.timestampNS = timestampNS
+}
+
 func (r *Reduce) Marshal(out *bytes.Buffer) error {
 	if err := r.metadata.Marshal(out); err != nil {
 		return err
@@ -526,6 +721,10 @@ func (r *Reduce) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := serialization.WriteInt64(r.clientOrderID, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -546,10 +745,304 @@ func (r *Reduce) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	r.orderID = orderID
 	r.userID = userID
 	r.symbolID = symbolID
 	r.quantity = quanitity
+	r.clientOrderID = clientOrderID
+
+	return nil
+}
+
+/*
+ * Cancel/replace of a resting order.
+ * `price` 0 keeps the price, `quantity` is the new remained quantity,
+ * 0 keeps the quantity.
+ * Priority is kept only when the quantity is reduced at the same price.
+ * The order is identified by `orderID`, or by `origClientOrderID` when it is 0.
+ */
+type Amend struct {
+	orderID           int64
+	userID            int64
+	symbolID          int32
+	price             int64
+	quantity          int64
+	clientOrderID     int64 // new client order id, 0 if none
+	origClientOrderID int64
+	metadata          Metadata
+	_                 struct{}
+}
+
+func NewAmend(
+	orderID int64,
+	userID int64,
+	symbolID int32,
+	price int64,
+	quantity int64,
+	clientOrderID int64,
+) *Amend {
+	return &Amend{
+		orderID:       orderID,
+		userID:        userID,
+		symbolID:      symbolID,
+		price:         price,
+		quantity:      quantity,
+		clientOrderID: clientOrderID,
+	}
+}
+
+func (a *Amend) Code() int8 {
+	return 8
+}
+
+func (a *Amend) OrderID() int64 {
+	return a.orderID
+}
+
+func (a *Amend) UserID() int64 {
+	return a.userID
+}
+
+func (a *Amend) SymbolID() int32 {
+	return a.symbolID
+}
+
+func (a *Amend) Price() int64 {
+	return a.price
+}
+
+func (a *Amend) Quantity() int64 {
+	return a.quantity
+}
+
+func (a *Amend) ClientOrderID() int64 {
+	return a.clientOrderID
+}
+
+// Current client order id of the amended order.
+func (a *Amend) OrigClientOrderID() int64 {
+	return a.origClientOrderID
+}
+
+func (a *Amend) SetOrigClientOrderID(origClientOrderID int64) {
+	a.origClientOrderID = origClientOrderID
+}
+
+func (a *Amend) Seq() int64 {
+	return a.metadata.seq
+}
+
+func (a *Amend) SetSeq(seq int64) {
+	a.metadata.seq = seq
+}
+
+func (a *Amend) ServiceFlags() int32 {
+	return a.metadata.serviceFlags
+}
+
+func (a *Amend) SetServiceFlags(flags int32) {
+	a.metadata.serviceFlags = flags
+}
+
+func (a *Amend) TimestampNS() int64 {
+	return a.metadata.timestampNS
+}
+
+func (a *Amend) SetTimestampNS(timestampNS int64) {
+	a.metadata.timestampNS = timestampNS
+}
+
+func (a *Amend) Marshal(out *bytes.Buffer) error {
+	if err := a.metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := marshalCommon(
+		a.userID,
+		a.symbolID,
+		a.orderID,
+		out,
+	); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(a.price, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(a.quantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(a.clientOrderID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(a.origClientOrderID, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a *Amend) Unmarshal(in *bytes.Buffer) error {
+	if err := a.metadata.Unmarshal(in); err != nil {
+		return err
+	}
+
+	userID, symbolID, orderID, err := unmarshalCommon(in)
+
+	if err != nil {
+		return err
+	}
+
+	price, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	quantity, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	origClientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	a.orderID = orderID
+	a.userID = userID
+	a.symbolID = symbolID
+	a.price = price
+	a.quantity = quantity
+	a.clientOrderID = clientOrderID
+	a.origClientOrderID = origClientOrderID
+
+	return nil
+}
+
+// Cancels all orders of a user.
+// `symbolID` 0 means all symbols, `action` 0 means both sides.
+type MassCancel struct {
+	userID   int64
+	symbolID int32
+	action   Action
+	metadata Metadata
+	_        struct{}
+}
+
+func NewMassCancel(
+	userID int64,
+	symbolID int32,
+	action Action,
+) *MassCancel {
+	return &MassCancel{
+		userID:   userID,
+		symbolID: symbolID,
+		action:   action,
+	}
+}
+
+func (m *MassCancel) Code() int8 {
+	return 5
+}
+
+func (m *MassCancel) UserID() int64 {
+	return m.userID
+}
+
+func (m *MassCancel) SymbolID() int32 {
+	return m.symbolID
+}
+
+func (m *MassCancel) Action() Action {
+	return m.action
+}
+
+func (m *MassCancel) Seq() int64 {
+	return m.metadata.seq
+}
+
+func (m *MassCancel) SetSeq(seq int64) {
+	m.metadata.seq = seq
+}
+
+func (m *MassCancel) TimestampNS() int64 {
+	return m.metadata.timestampNS
+}
+
+func (m *MassCancel) Marshal(out *bytes.Buffer) error {
+	if err := m.metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(m.symbolID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt8(int8(m.action), out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *MassCancel) Unmarshal(in *bytes.Buffer) error {
+	if err := m.metadata.Unmarshal(in); err != nil {
+		return err
+	}
+
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbolID, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	code, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	var action Action
+
+	if code != 0 {
+		var ok bool
+
+		if action, ok = ActionFrom(code); !ok {
+			return fmt.Errorf("unmarshal: action: %v", code)
+		}
+	}
+
+	m.userID = userID
+	m.symbolID = symbolID
+	m.action = action
 
 	return nil
 }
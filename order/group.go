@@ -0,0 +1,36 @@
+package order
+
+// Role of an order inside a linked order group.
+type GroupRole int8
+
+const (
+	// OCO (One Cancels Other) - a fill or cancel of one leg cancels the others
+	OCOLeg GroupRole = iota + 1
+
+	// Bracket - entry plus take-profit plus stop-loss.
+	// Exit legs are held by the orderbook until the entry is completely filled,
+	// then take-profit rests in the book and stop-loss is armed.
+	// Exit legs are one-cancels-other.
+	BracketEntry
+	BracketTakeProfit
+	BracketStopLoss
+)
+
+var _groupRoles = map[int8]GroupRole{
+	int8(OCOLeg):            OCOLeg,
+	int8(BracketEntry):      BracketEntry,
+	int8(BracketTakeProfit): BracketTakeProfit,
+	int8(BracketStopLoss):   BracketStopLoss,
+}
+
+func GroupRoleFrom(code int8) (GroupRole, bool) {
+	role, ok := _groupRoles[code]
+
+	return role, ok
+}
+
+func (r GroupRole) IsBracket() bool {
+	return r == BracketEntry ||
+		r == BracketTakeProfit ||
+		r == BracketStopLoss
+}
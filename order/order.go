@@ -36,10 +36,16 @@ type Order struct {
 
 	// new orders - reserved price for fast moves of `GTC` bid orders in exchange mode
 	// TODO logic
-	reservedBidPrice int64  `json:"reservedBidPrice"`
-	timestamp        int64  `json:"timestamp"`
-	action           Action `json:"action"`
-	_                struct{}
+	reservedBidPrice int64
+	timestamp        int64
+	action           Action
+
+	// API client session, 0 if none
+	sessionID int64
+
+	// unique per user among live orders, 0 if none
+	clientOrderID int64
+	_             struct{}
 }
 
 func New(
@@ -51,6 +57,8 @@ func New(
 	reservedBidPrice int64,
 	timestamp int64,
 	action Action,
+	sessionID int64,
+	clientOrderID int64,
 ) *Order {
 	return &Order{
 		id:               id,
@@ -61,6 +69,8 @@ func New(
 		reservedBidPrice: reservedBidPrice,
 		timestamp:        timestamp,
 		action:           action,
+		sessionID:        sessionID,
+		clientOrderID:    clientOrderID,
 	}
 }
 
@@ -88,6 +98,18 @@ func (o *Order) Action() Action {
 	return o.action
 }
 
+func (o *Order) SessionID() int64 {
+	return o.sessionID
+}
+
+func (o *Order) ClientOrderID() int64 {
+	return o.clientOrderID
+}
+
+func (o *Order) SetClientOrderID(id int64) {
+	o.clientOrderID = id
+}
+
 func (o *Order) Remained() int64 {
 	return o.quantity - o.filled
 }
@@ -108,8 +130,21 @@ func (o *Order) Fill(quantity int64) error {
 	return nil
 }
 
+// Reduces the remained quantity, filled quantity is untouched.
 func (o *Order) Reduce(quantity int64) error {
-	return o.Fill(-quantity)
+	after := o.quantity - quantity
+
+	if quantity < 0 || after < o.filled {
+		return &QuantityError{
+			OrderID: o.id,
+			Before:  o.quantity,
+			After:   after,
+		}
+	}
+
+	o.quantity = after
+
+	return nil
 }
 
 // TODO Order fields are not exported.
@@ -156,6 +191,14 @@ func (o *Order) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := serialization.WriteInt64(o.sessionID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(o.clientOrderID, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -214,6 +257,18 @@ func (o *Order) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	sessionID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	clientOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	o.id = id
 	o.price = price
 	o.quantity = quantity
@@ -222,6 +277,8 @@ func (o *Order) Unmarshal(in *bytes.Buffer) error {
 	o.action = action
 	o.userID = userID
 	o.timestamp = timestamp
+	o.sessionID = sessionID
+	o.clientOrderID = clientOrderID
 
 	return nil
 }
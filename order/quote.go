@@ -0,0 +1,308 @@
+package order
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// A price level of a quote ladder.
+type Quote struct {
+	action   Action
+	price    int64
+	quantity int64
+	_        struct{}
+}
+
+func NewQuote(
+	action Action,
+	price int64,
+	quantity int64,
+) *Quote {
+	return &Quote{
+		action:   action,
+		price:    price,
+		quantity: quantity,
+	}
+}
+
+func (q *Quote) Action() Action {
+	return q.action
+}
+
+func (q *Quote) Price() int64 {
+	return q.price
+}
+
+func (q *Quote) Quantity() int64 {
+	return q.quantity
+}
+
+// Quote levels of a user on one symbol.
+// An empty ladder pulls the quotes of the symbol.
+type Ladder struct {
+	symbolID int32
+	quotes   []*Quote
+	_        struct{}
+}
+
+func NewLadder(
+	symbolID int32,
+	quotes ...*Quote,
+) *Ladder {
+	return &Ladder{
+		symbolID: symbolID,
+		quotes:   quotes,
+	}
+}
+
+func (l *Ladder) SymbolID() int32 {
+	return l.symbolID
+}
+
+func (l *Ladder) Quotes() []*Quote {
+	return l.quotes
+}
+
+/*
+ * Replaces quote ladders of a user on one or more symbols atomically.
+ * Previous quotes of the user on each symbol are cancelled and
+ * the levels are placed as GTC orders.
+ * Order ids are not carried per level, the level at index `i`
+ * (counted across all ladders) gets `firstOrderID + i`.
+ */
+type MassQuote struct {
+	userID       int64
+	sessionID    int64 // 0 if none
+	firstOrderID int64
+	ladders      []*Ladder
+	metadata     Metadata
+	_            struct{}
+}
+
+func NewMassQuote(
+	userID int64,
+	sessionID int64,
+	firstOrderID int64,
+	ladders ...*Ladder,
+) *MassQuote {
+	return &MassQuote{
+		userID:       userID,
+		sessionID:    sessionID,
+		firstOrderID: firstOrderID,
+		ladders:      ladders,
+	}
+}
+
+func (m *MassQuote) Code() int8 {
+	return 7
+}
+
+func (m *MassQuote) UserID() int64 {
+	return m.userID
+}
+
+func (m *MassQuote) SessionID() int64 {
+	return m.sessionID
+}
+
+func (m *MassQuote) FirstOrderID() int64 {
+	return m.firstOrderID
+}
+
+func (m *MassQuote) Ladders() []*Ladder {
+	return m.ladders
+}
+
+// Number of levels across all ladders.
+func (m *MassQuote) Size() int {
+	size := 0
+
+	for _, l := range m.ladders {
+		size += len(l.quotes)
+	}
+
+	return size
+}
+
+/*
+ * GTC orders of the levels per ladder.
+ * Reserved price of a bid is its price.
+ */
+func (m *MassQuote) Places() [][]*Place {
+	res := make([][]*Place, 0, len(m.ladders))
+	orderID := m.firstOrderID
+
+	for _, l := range m.ladders {
+		places := make([]*Place, 0, len(l.quotes))
+
+		for _, q := range l.quotes {
+			place := NewPlace(
+				orderID,
+				m.userID,
+				q.price,
+				q.quantity,
+				q.price,
+				l.symbolID,
+				m.metadata.timestampNS,
+				q.action,
+				GTC,
+			)
+			place.SetSession(m.sessionID)
+			place.metadata = m.metadata
+
+			places = append(places, place)
+			orderID++
+		}
+
+		res = append(res, places)
+	}
+
+	return res
+}
+
+func (m *MassQuote) Seq() int64 {
+	return m.metadata.seq
+}
+
+func (m *MassQuote) SetSeq(seq int64) {
+	m.metadata.seq = seq
+}
+
+func (m *MassQuote) TimestampNS() int64 {
+	return m.metadata.timestampNS
+}
+
+func (m *MassQuote) Marshal(out *bytes.Buffer) error {
+	if err := m.metadata.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.sessionID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.firstOrderID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(m.ladders)), out); err != nil {
+		return err
+	}
+
+	for _, l := range m.ladders {
+		if err := serialization.WriteInt32(l.symbolID, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(int32(len(l.quotes)), out); err != nil {
+			return err
+		}
+
+		for _, q := range l.quotes {
+			if err := serialization.WriteInt8(int8(q.action), out); err != nil {
+				return err
+			}
+
+			if err := serialization.WriteInt64(q.price, out); err != nil {
+				return err
+			}
+
+			if err := serialization.WriteInt64(q.quantity, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *MassQuote) Unmarshal(in *bytes.Buffer) error {
+	if err := m.metadata.Unmarshal(in); err != nil {
+		return err
+	}
+
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	firstOrderID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	ladders := make([]*Ladder, 0, size)
+
+	for ; size > 0; size-- {
+		symbolID, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		numQuotes, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		quotes := make([]*Quote, 0, numQuotes)
+
+		for ; numQuotes > 0; numQuotes-- {
+			code, err := serialization.ReadInt8(in)
+
+			if err != nil {
+				return err
+			}
+
+			action, ok := ActionFrom(code)
+
+			if !ok {
+				return fmt.Errorf("unmarshal: action: %v", code)
+			}
+
+			price, err := serialization.ReadInt64(in)
+
+			if err != nil {
+				return err
+			}
+
+			quantity, err := serialization.ReadInt64(in)
+
+			if err != nil {
+				return err
+			}
+
+			quotes = append(quotes, NewQuote(action, price, quantity))
+		}
+
+		ladders = append(ladders, NewLadder(symbolID, quotes...))
+	}
+
+	m.userID = userID
+	m.sessionID = sessionID
+	m.firstOrderID = firstOrderID
+	m.ladders = ladders
+
+	return nil
+}
@@ -113,8 +113,12 @@ func (buc *Bucket) IsValid() bool {
 }
 
 func (buc *Bucket) Match(
+	symbolID int32,
 	toCollect int64,
 	reservedBidPrice int64, // only for bids
+	takerOrderID int64,
+	takerUserID int64,
+	nextTradeID func() int64,
 ) *MatcherResult {
 	var (
 		collected       int64
@@ -154,10 +158,17 @@ func (buc *Bucket) Match(
 		}
 
 		e := event.NewTrade(
+			symbolID,
+			nextTradeID(),
 			ord.ID(),
 			ord.UserID(),
+			takerOrderID,
+			takerUserID,
 			ord.Remained() == 0,
 			collected == toCollect,
+			ord.Action(),
+			ord.Remained(),
+			toCollect-collected,
 			ord.Price(),
 			tradedQuantity,
 			bidderHoldPrice,
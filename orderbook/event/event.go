@@ -10,6 +10,7 @@ import (
 
 // TODO equals and hashCode overriden
 type Event interface {
+	SymbolID() int32 // book of the event
 	Next() Event
 	SetNext(Event)
 	FindTail() Event
@@ -21,10 +22,19 @@ type Event interface {
 // TODO rename
 // Can be triggered by place ORDER or for MOVE order command.
 type Trade struct {
+	symbolID            int32
+	tradeID             int64 // unique and monotonic per symbol
 	makerOrderID        int64
 	makerUserID         int64
+	takerOrderID        int64
+	takerUserID         int64
 	makerOrderCompleted bool
 	takerOrderCompleted bool
+	makerAction         order.Action
+
+	// quantities left after the trade
+	makerRemained int64
+	takerRemained int64
 
 	// actual price of the deal (from maker order)
 	price int64
@@ -39,25 +49,95 @@ type Trade struct {
 }
 
 func NewTrade(
+	symbolID int32,
+	tradeID int64,
 	makerOrderID int64,
 	makerUserID int64,
+	takerOrderID int64,
+	takerUserID int64,
 	makerOrderCompleted bool,
 	takerOrderCompleted bool,
+	makerAction order.Action,
+	makerRemained int64,
+	takerRemained int64,
 	price int64,
 	quantity int64, // traded quantity
 	bidderHoldPrice int64,
 ) *Trade {
 	return &Trade{
+		symbolID:            symbolID,
+		tradeID:             tradeID,
 		makerOrderID:        makerOrderID,
 		makerUserID:         makerUserID,
+		takerOrderID:        takerOrderID,
+		takerUserID:         takerUserID,
 		makerOrderCompleted: makerOrderCompleted,
 		takerOrderCompleted: takerOrderCompleted,
+		makerAction:         makerAction,
+		makerRemained:       makerRemained,
+		takerRemained:       takerRemained,
 		price:               price,
 		quantity:            quantity,
 		bidderHoldPrice:     bidderHoldPrice,
 	}
 }
 
+func (t *Trade) TradeID() int64 {
+	return t.tradeID
+}
+
+func (t *Trade) MakerOrderID() int64 {
+	return t.makerOrderID
+}
+
+func (t *Trade) MakerUserID() int64 {
+	return t.makerUserID
+}
+
+func (t *Trade) TakerOrderID() int64 {
+	return t.takerOrderID
+}
+
+func (t *Trade) TakerUserID() int64 {
+	return t.takerUserID
+}
+
+func (t *Trade) MakerRemained() int64 {
+	return t.makerRemained
+}
+
+func (t *Trade) TakerRemained() int64 {
+	return t.takerRemained
+}
+
+func (t *Trade) MakerOrderCompleted() bool {
+	return t.makerOrderCompleted
+}
+
+func (t *Trade) TakerOrderCompleted() bool {
+	return t.takerOrderCompleted
+}
+
+func (t *Trade) MakerAction() order.Action {
+	return t.makerAction
+}
+
+func (t *Trade) Price() int64 {
+	return t.price
+}
+
+func (t *Trade) Quantity() int64 {
+	return t.quantity
+}
+
+func (t *Trade) BidderHoldPrice() int64 {
+	return t.bidderHoldPrice
+}
+
+func (t *Trade) SymbolID() int32 {
+	return t.symbolID
+}
+
 func (t *Trade) Next() Event {
 	return t.next
 }
@@ -79,6 +159,7 @@ func (t *Trade) ChainSize() int32 {
 // TODO rename
 // After reduce order - risk engine should unlock deposit accordingly.
 type Reduce struct {
+	symbolID            int32
 	makerOrderID        int64
 	makerOrderCompleted bool
 	price               int64
@@ -91,6 +172,7 @@ type Reduce struct {
 }
 
 func NewReduce(
+	symbolID int32,
 	makerOrderID int64,
 	makerOrderCompleted bool,
 	price int64,
@@ -98,6 +180,7 @@ func NewReduce(
 	action order.Action,
 ) *Reduce {
 	return &Reduce{
+		symbolID:            symbolID,
 		makerOrderID:        makerOrderID,
 		makerOrderCompleted: makerOrderCompleted,
 		price:               price,
@@ -106,6 +189,30 @@ func NewReduce(
 	}
 }
 
+func (r *Reduce) MakerOrderID() int64 {
+	return r.makerOrderID
+}
+
+func (r *Reduce) MakerOrderCompleted() bool {
+	return r.makerOrderCompleted
+}
+
+func (r *Reduce) Price() int64 {
+	return r.price
+}
+
+func (r *Reduce) Quantity() int64 {
+	return r.quantity
+}
+
+func (r *Reduce) Action() order.Action {
+	return r.action
+}
+
+func (r *Reduce) SymbolID() int32 {
+	return r.symbolID
+}
+
 func (r *Reduce) Next() Event {
 	return r.next
 }
@@ -129,6 +236,7 @@ func (r *Reduce) ChainSize() int32 {
 // That basically means no ASK (or BID) orders left in the order book for any price.
 // Before being rejected active order can be partially filled.
 type Reject struct {
+	symbolID     int32
 	takerOrderID int64
 	price        int64
 	quantity     int64 // rejected quantity
@@ -138,12 +246,14 @@ type Reject struct {
 }
 
 func NewReject(
+	symbolID int32,
 	takerOrderID int64,
 	price int64,
 	quantity int64, // rejected quantity
 	action order.Action,
 ) *Reject {
 	return &Reject{
+		symbolID:     symbolID,
 		takerOrderID: takerOrderID,
 		price:        price,
 		quantity:     quantity,
@@ -151,6 +261,26 @@ func NewReject(
 	}
 }
 
+func (r *Reject) TakerOrderID() int64 {
+	return r.takerOrderID
+}
+
+func (r *Reject) Price() int64 {
+	return r.price
+}
+
+func (r *Reject) Quantity() int64 {
+	return r.quantity
+}
+
+func (r *Reject) Action() order.Action {
+	return r.action
+}
+
+func (r *Reject) SymbolID() int32 {
+	return r.symbolID
+}
+
 func (r *Reject) Next() Event {
 	return r.next
 }
@@ -167,6 +297,206 @@ func (r *Reject) ChainSize() int32 {
 	return chainSize(r)
 }
 
+// TODO equals and hashCode overriden
+// After amend order - followed by the trades of the replacing order, if any.
+// Released quantity is reported by the preceding reduce event.
+type Replace struct {
+	symbolID      int32
+	orderID       int64
+	clientOrderID int64 // new client order id, 0 if none
+	price         int64 // new price
+	quantity      int64 // new remained quantity, before matching
+	action        order.Action
+	priorityKept  bool
+	next          Event
+	_             struct{}
+}
+
+func NewReplace(
+	symbolID int32,
+	orderID int64,
+	clientOrderID int64,
+	price int64,
+	quantity int64,
+	action order.Action,
+	priorityKept bool,
+) *Replace {
+	return &Replace{
+		symbolID:      symbolID,
+		orderID:       orderID,
+		clientOrderID: clientOrderID,
+		price:         price,
+		quantity:      quantity,
+		action:        action,
+		priorityKept:  priorityKept,
+	}
+}
+
+func (r *Replace) OrderID() int64 {
+	return r.orderID
+}
+
+func (r *Replace) ClientOrderID() int64 {
+	return r.clientOrderID
+}
+
+func (r *Replace) Price() int64 {
+	return r.price
+}
+
+func (r *Replace) Quantity() int64 {
+	return r.quantity
+}
+
+func (r *Replace) Action() order.Action {
+	return r.action
+}
+
+func (r *Replace) PriorityKept() bool {
+	return r.priorityKept
+}
+
+func (r *Replace) SymbolID() int32 {
+	return r.symbolID
+}
+
+func (r *Replace) Next() Event {
+	return r.next
+}
+
+func (r *Replace) SetNext(next Event) {
+	r.next = next
+}
+
+func (r *Replace) FindTail() Event {
+	return findTail(r)
+}
+
+func (r *Replace) ChainSize() int32 {
+	return chainSize(r)
+}
+
+// TODO equals and hashCode overriden
+// Order rests in the book, after matching.
+type Accept struct {
+	symbolID int32
+	orderID  int64
+	userID   int64
+	price    int64
+	quantity int64 // remained quantity
+	action   order.Action
+	next     Event
+	_        struct{}
+}
+
+func NewAccept(
+	symbolID int32,
+	orderID int64,
+	userID int64,
+	price int64,
+	quantity int64,
+	action order.Action,
+) *Accept {
+	return &Accept{
+		symbolID: symbolID,
+		orderID:  orderID,
+		userID:   userID,
+		price:    price,
+		quantity: quantity,
+		action:   action,
+	}
+}
+
+func (a *Accept) OrderID() int64 {
+	return a.orderID
+}
+
+func (a *Accept) UserID() int64 {
+	return a.userID
+}
+
+func (a *Accept) Price() int64 {
+	return a.price
+}
+
+func (a *Accept) Quantity() int64 {
+	return a.quantity
+}
+
+func (a *Accept) Action() order.Action {
+	return a.action
+}
+
+func (a *Accept) SymbolID() int32 {
+	return a.symbolID
+}
+
+func (a *Accept) Next() Event {
+	return a.next
+}
+
+func (a *Accept) SetNext(next Event) {
+	a.next = next
+}
+
+func (a *Accept) FindTail() Event {
+	return findTail(a)
+}
+
+func (a *Accept) ChainSize() int32 {
+	return chainSize(a)
+}
+
+// TODO equals and hashCode overriden
+// Audit of a command on an order of another user, the command is rejected.
+type Unauthorized struct {
+	symbolID int32
+	orderID  int64
+	userID   int64 // issuer of the command
+	next     Event
+	_        struct{}
+}
+
+func NewUnauthorized(
+	symbolID int32,
+	orderID int64,
+	userID int64,
+) *Unauthorized {
+	return &Unauthorized{
+		symbolID: symbolID,
+		orderID:  orderID,
+		userID:   userID,
+	}
+}
+
+func (u *Unauthorized) OrderID() int64 {
+	return u.orderID
+}
+
+func (u *Unauthorized) UserID() int64 {
+	return u.userID
+}
+
+func (u *Unauthorized) SymbolID() int32 {
+	return u.symbolID
+}
+
+func (u *Unauthorized) Next() Event {
+	return u.next
+}
+
+func (u *Unauthorized) SetNext(next Event) {
+	u.next = next
+}
+
+func (u *Unauthorized) FindTail() Event {
+	return findTail(u)
+}
+
+func (u *Unauthorized) ChainSize() int32 {
+	return chainSize(u)
+}
+
 func findTail(e Event) Event {
 	for e.Next() != nil {
 		e = e.Next()
@@ -0,0 +1,616 @@
+package orderbook
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+/*
+ * Linked orders sharing the same `order.Place.GroupID()`.
+ * OCO: a fill or cancel of one leg cancels the other legs.
+ * Bracket: exit legs are held until the entry is completely filled,
+ * then take-profit rests in the book and stop-loss is armed.
+ * A fill or cancel of one exit leg cancels the other one.
+ * Cancelling the entry (even partially filled) discards the exit legs.
+ * A bracket is kept until all of its legs are received,
+ * legs received after its siblings are cancelled are rejected.
+ */
+// TODO partially filled entry, activate exits for the filled quantity?
+type group struct {
+	id        int64
+	userID    int64
+	bracket   bool
+	activated bool  // bracket entry is completely filled
+	entryID   int64 // 0 if entry not received yet
+	joined    uint8 // received bracket roles, bitmask of `roleBit`
+	closed    bool  // siblings cancelled, later legs are rejected
+
+	// legs resting in the book, orderID -> role
+	legs map[int64]order.GroupRole
+
+	// bracket exit legs waiting for the entry
+	pending []*order.Place
+
+	// armed stop-loss, placed into the book when a trade crosses its price
+	stop *order.Place
+	_    struct{}
+}
+
+func newGroup(
+	id int64,
+	userID int64,
+	bracket bool,
+) *group {
+	return &group{
+		id:      id,
+		userID:  userID,
+		bracket: bracket,
+		legs:    make(map[int64]order.GroupRole),
+	}
+}
+
+const allBracketRoles = 1<<order.BracketEntry | 1<<order.BracketTakeProfit | 1<<order.BracketStopLoss
+
+func roleBit(role order.GroupRole) uint8 {
+	return 1 << role
+}
+
+func (g *group) isEmpty() bool {
+	return len(g.legs) == 0 && len(g.pending) == 0 && g.stop == nil
+}
+
+// No leg is left and no more legs are expected.
+func (g *group) isDone() bool {
+	return g.isEmpty() && (!g.bracket || g.joined == allBracketRoles)
+}
+
+// sorted for deterministic processing
+func (g *group) legIDs() []int64 {
+	ids := make([]int64, 0, len(g.legs))
+
+	for id := range g.legs {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// pending and armed legs
+func (g *group) held() []*order.Place {
+	held := append([]*order.Place(nil), g.pending...)
+
+	if g.stop != nil {
+		held = append(held, g.stop)
+	}
+
+	return held
+}
+
+func stopTriggered(stop *order.Place, price int64) bool {
+	if stop.Action() == order.Ask {
+		return price <= stop.Price()
+	} else {
+		return price >= stop.Price()
+	}
+}
+
+func (g *group) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(g.id, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(g.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(g.bracket, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(g.activated, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(g.entryID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt8(int8(g.joined), out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(g.closed, out); err != nil {
+		return err
+	}
+
+	legIDs := g.legIDs()
+
+	if err := serialization.WriteInt32(int32(len(legIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range legIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt8(int8(g.legs[id]), out); err != nil {
+			return err
+		}
+	}
+
+	if err := serialization.WriteInt32(int32(len(g.pending)), out); err != nil {
+		return err
+	}
+
+	for _, place := range g.pending {
+		if err := place.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	if err := serialization.WriteBool(g.stop != nil, out); err != nil {
+		return err
+	}
+
+	if g.stop != nil {
+		if err := g.stop.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *group) Unmarshal(in *bytes.Buffer) error {
+	id, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	bracket, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	activated, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	entryID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	joined, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	closed, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	legs := make(map[int64]order.GroupRole, size)
+
+	for ; size > 0; size-- {
+		orderID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		code, err := serialization.ReadInt8(in)
+
+		if err != nil {
+			return err
+		}
+
+		role, ok := order.GroupRoleFrom(code)
+
+		if !ok {
+			return fmt.Errorf("group unmarshal: role: %v", code)
+		}
+
+		legs[orderID] = role
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	pending := make([]*order.Place, 0, size)
+
+	for ; size > 0; size-- {
+		place := &order.Place{}
+
+		if err := place.Unmarshal(in); err != nil {
+			return err
+		}
+
+		pending = append(pending, place)
+	}
+
+	armed, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
+	var stop *order.Place
+
+	if armed {
+		stop = &order.Place{}
+
+		if err := stop.Unmarshal(in); err != nil {
+			return err
+		}
+	}
+
+	g.id = id
+	g.userID = userID
+	g.bracket = bracket
+	g.activated = activated
+	g.entryID = entryID
+	g.joined = uint8(joined)
+	g.closed = closed
+	g.legs = legs
+	g.pending = pending
+	g.stop = stop
+
+	return nil
+}
+
+/*
+ * Validates a grouped order and registers it in its group.
+ * Returns `held` if the order is kept by the group
+ * and must not be placed into the book (yet).
+ */
+func (n *Naive) joinGroup(
+	place *order.Place,
+) (g *group, held bool, code resultcode.ResultCode) {
+	orderID := place.OrderID()
+	role := place.GroupRole()
+
+	if _, ok := n.orderGroups[orderID]; ok {
+		return nil, false, resultcode.MatchingDuplicateOrderId
+	}
+
+	g, ok := n.groups[place.GroupID()]
+
+	if !ok {
+		g = newGroup(place.GroupID(), place.UserID(), role.IsBracket())
+	} else if g.userID != place.UserID() || g.bracket != role.IsBracket() {
+		return nil, false, resultcode.MatchingInvalidOrderGroup
+	}
+
+	if role.IsBracket() {
+		if g.joined&roleBit(role) != 0 {
+			return nil, false, resultcode.MatchingInvalidOrderGroup
+		}
+
+		g.joined |= roleBit(role)
+	}
+
+	// siblings already cancelled, the group is kept only to reject late legs
+	if g.closed {
+		if g.isDone() {
+			delete(n.groups, g.id)
+		}
+
+		return nil, false, resultcode.MatchingInvalidOrderGroup
+	}
+
+	switch role {
+	case order.OCOLeg:
+	case order.BracketEntry:
+		g.entryID = orderID
+	case order.BracketTakeProfit, order.BracketStopLoss:
+		if !g.activated {
+			g.pending = append(g.pending, place)
+			held = true
+		} else if role == order.BracketStopLoss {
+			g.stop = place
+			held = true
+		}
+	default:
+		return nil, false, resultcode.MatchingInvalidOrderGroup
+	}
+
+	n.groups[g.id] = g
+	n.orderGroups[orderID] = g.id
+
+	return g, held, resultcode.Success
+}
+
+func (n *Naive) leaveGroup(g *group, orderID int64) {
+	delete(g.legs, orderID)
+	delete(n.orderGroups, orderID)
+
+	if g.isDone() {
+		delete(n.groups, g.id)
+	}
+}
+
+// Places a grouped order as a resting leg, events are appended to `res`.
+func (n *Naive) placeLeg(
+	g *group,
+	place *order.Place,
+	res *MatcherResult,
+) {
+	orderID := place.OrderID()
+	g.legs[orderID] = place.GroupRole()
+	n.orderGroups[orderID] = g.id
+
+	legResult := n.placeGTC(place)
+	n.settleGroups(orderID, legResult)
+
+	if _, ok := n.orders[orderID]; !ok {
+		n.leaveGroup(g, orderID)
+	}
+
+	res.Append(legResult.Head)
+}
+
+/*
+ * Applies group rules to the trades of a command:
+ * fills of grouped orders cancel or activate their siblings,
+ * and the last trade price may trigger armed stop-losses.
+ * Resulting events are appended to `res` within the same command.
+ */
+func (n *Naive) settleGroups(
+	takerOrderID int64,
+	res *MatcherResult,
+) {
+	if len(n.groups) == 0 {
+		return
+	}
+
+	var trades []*event.Trade
+
+	for e := res.Head; e != nil; e = e.Next() {
+		if trade, ok := e.(*event.Trade); ok {
+			trades = append(trades, trade)
+		}
+	}
+
+	if len(trades) == 0 {
+		return
+	}
+
+	for _, trade := range trades {
+		n.onGroupFill(trade.MakerOrderID(), trade.MakerOrderCompleted(), res)
+	}
+
+	last := trades[len(trades)-1]
+	n.onGroupFill(takerOrderID, last.TakerOrderCompleted(), res)
+	n.triggerStops(last.Price(), res)
+}
+
+func (n *Naive) onGroupFill(
+	orderID int64,
+	completed bool,
+	res *MatcherResult,
+) {
+	groupID, ok := n.orderGroups[orderID]
+
+	if !ok {
+		return
+	}
+
+	g := n.groups[groupID]
+	role, ok := g.legs[orderID]
+
+	if !ok {
+		return
+	}
+
+	if role == order.BracketEntry {
+		if completed {
+			n.activateGroup(g, res)
+			n.leaveGroup(g, orderID)
+		}
+
+		return
+	}
+
+	n.cancelSiblings(g, orderID, res)
+
+	if completed {
+		n.leaveGroup(g, orderID)
+	}
+}
+
+// Cancelled or completely reduced grouped order.
+func (n *Naive) onGroupCancel(
+	orderID int64,
+	res *MatcherResult,
+) {
+	groupID, ok := n.orderGroups[orderID]
+
+	if !ok {
+		return
+	}
+
+	g := n.groups[groupID]
+	n.cancelSiblings(g, orderID, res)
+	n.leaveGroup(g, orderID)
+}
+
+// Cancels all legs except `orderID`, resting legs are reduced
+// and held legs are rejected.
+func (n *Naive) cancelSiblings(
+	g *group,
+	orderID int64,
+	res *MatcherResult,
+) {
+	for _, id := range g.legIDs() {
+		if id == orderID {
+			continue
+		}
+
+		n.leaveGroup(g, id)
+
+		if ord, ok := n.orders[id]; ok {
+			res.Append(n.reduce(ord, ord.Remained()).Head)
+		}
+	}
+
+	for _, place := range g.held() {
+		delete(n.orderGroups, place.OrderID())
+		res.Append(event.NewReject(
+			n.symbol.ID(),
+			place.OrderID(),
+			place.Price(),
+			place.Quantity(),
+			place.Action(),
+		))
+	}
+
+	g.pending = nil
+	g.stop = nil
+	g.closed = true
+
+	if g.isDone() {
+		delete(n.groups, g.id)
+	}
+}
+
+// Cancels an order which is held by its group (not in the book).
+func (n *Naive) cancelHeldLeg(
+	orderID int64,
+) (*MatcherResult, bool) {
+	groupID, ok := n.orderGroups[orderID]
+
+	if !ok {
+		return nil, false
+	}
+
+	g := n.groups[groupID]
+
+	var (
+		cancelled *order.Place
+		pending   []*order.Place
+	)
+
+	for _, place := range g.pending {
+		if place.OrderID() == orderID {
+			cancelled = place
+		} else {
+			pending = append(pending, place)
+		}
+	}
+
+	if g.stop != nil && g.stop.OrderID() == orderID {
+		cancelled = g.stop
+		g.stop = nil
+	}
+
+	if cancelled == nil {
+		return nil, false
+	}
+
+	g.pending = pending
+	n.leaveGroup(g, orderID)
+
+	e := event.NewReject(
+		n.symbol.ID(),
+		cancelled.OrderID(),
+		cancelled.Price(),
+		cancelled.Quantity(),
+		cancelled.Action(),
+	)
+
+	return &MatcherResult{
+		Head: e,
+		Tail: e,
+		Code: resultcode.Success,
+	}, true
+}
+
+func (n *Naive) activateGroup(
+	g *group,
+	res *MatcherResult,
+) {
+	g.activated = true
+	pending := g.pending
+	g.pending = nil
+
+	// armed first, so that an immediate take-profit fill disarms it
+	for _, place := range pending {
+		if place.GroupRole() == order.BracketStopLoss {
+			g.stop = place
+		}
+	}
+
+	for _, place := range pending {
+		if place.GroupRole() == order.BracketTakeProfit {
+			n.placeLeg(g, place, res)
+		}
+	}
+}
+
+func (n *Naive) triggerStops(
+	price int64,
+	res *MatcherResult,
+) {
+	var triggered []*group
+
+	for _, g := range n.groups {
+		if g.stop != nil && stopTriggered(g.stop, price) {
+			triggered = append(triggered, g)
+		}
+	}
+
+	sort.Slice(triggered, func(i, j int) bool {
+		return triggered[i].id < triggered[j].id
+	})
+
+	for _, g := range triggered {
+		stop := g.stop
+
+		// disarmed by a previously triggered stop
+		if stop == nil {
+			continue
+		}
+
+		g.stop = nil
+		g.legs[stop.OrderID()] = stop.GroupRole()
+
+		// stop-loss fired, take-profit is not needed anymore
+		n.cancelSiblings(g, stop.OrderID(), res)
+		n.placeLeg(g, stop, res)
+	}
+}
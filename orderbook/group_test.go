@@ -0,0 +1,232 @@
+package orderbook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const (
+	trader int64 = 1
+	maker  int64 = 2
+)
+
+type groupStep struct {
+	place     *order.Place          // nil means mass cancel of the trader
+	code      resultcode.ResultCode // 0 means success
+	resting   []int64               // orders expected in the book after the step
+	held      []int64               // orders expected to be held by the group after the step
+	hasGroup  bool
+	cancelled []int64 // orders expected to be gone after the step
+}
+
+func grouped(
+	orderID int64,
+	userID int64,
+	price int64,
+	quantity int64,
+	action order.Action,
+	role order.GroupRole,
+) *order.Place {
+	place := order.NewPlace(orderID, userID, price, quantity, price, 0, 0, action, order.GTC)
+
+	if role != 0 {
+		place.SetGroup(7, role)
+	}
+
+	return place
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []groupStep
+	}{
+		{
+			name: "exits before entry fill",
+			steps: []groupStep{
+				{place: grouped(1, trader, 100, 10, order.Bid, order.BracketEntry), resting: []int64{1}, hasGroup: true},
+				{place: grouped(2, trader, 110, 10, order.Ask, order.BracketTakeProfit), held: []int64{2}, hasGroup: true},
+				{place: grouped(3, trader, 90, 10, order.Ask, order.BracketStopLoss), held: []int64{2, 3}, hasGroup: true},
+				{place: grouped(4, maker, 100, 10, order.Ask, 0), resting: []int64{2}, held: []int64{3}, hasGroup: true, cancelled: []int64{1}},
+			},
+		},
+		{
+			name: "entry filled before exits",
+			steps: []groupStep{
+				{place: grouped(4, maker, 100, 10, order.Ask, 0), resting: []int64{4}},
+				{place: grouped(1, trader, 100, 10, order.Bid, order.BracketEntry), hasGroup: true, cancelled: []int64{1, 4}},
+				{place: grouped(2, trader, 110, 10, order.Ask, order.BracketTakeProfit), resting: []int64{2}, hasGroup: true},
+				{place: grouped(3, trader, 90, 10, order.Ask, order.BracketStopLoss), resting: []int64{2}, held: []int64{3}, hasGroup: true},
+			},
+		},
+		{
+			name: "take-profit fill cancels stop-loss",
+			steps: []groupStep{
+				{place: grouped(4, maker, 100, 10, order.Ask, 0), resting: []int64{4}},
+				{place: grouped(1, trader, 100, 10, order.Bid, order.BracketEntry), hasGroup: true},
+				{place: grouped(3, trader, 90, 10, order.Ask, order.BracketStopLoss), held: []int64{3}, hasGroup: true},
+				{place: grouped(2, trader, 110, 10, order.Ask, order.BracketTakeProfit), resting: []int64{2}, held: []int64{3}, hasGroup: true},
+				{place: grouped(5, maker, 110, 10, order.Bid, 0), cancelled: []int64{2, 3}},
+			},
+		},
+		{
+			name: "entry cancelled before exits",
+			steps: []groupStep{
+				{place: grouped(1, trader, 100, 10, order.Bid, order.BracketEntry), resting: []int64{1}, hasGroup: true},
+				{hasGroup: true, cancelled: []int64{1}},
+				{place: grouped(2, trader, 110, 10, order.Ask, order.BracketTakeProfit), code: resultcode.MatchingInvalidOrderGroup, hasGroup: true, cancelled: []int64{2}},
+				{place: grouped(3, trader, 90, 10, order.Ask, order.BracketStopLoss), code: resultcode.MatchingInvalidOrderGroup, cancelled: []int64{3}},
+			},
+		},
+		{
+			name: "duplicate role",
+			steps: []groupStep{
+				{place: grouped(1, trader, 100, 10, order.Bid, order.BracketEntry), resting: []int64{1}, hasGroup: true},
+				{place: grouped(2, trader, 101, 10, order.Bid, order.BracketEntry), code: resultcode.MatchingInvalidOrderGroup, resting: []int64{1}, hasGroup: true},
+			},
+		},
+		{
+			name: "oco fill cancels sibling",
+			steps: []groupStep{
+				{place: grouped(1, trader, 100, 10, order.Bid, order.OCOLeg), resting: []int64{1}, hasGroup: true},
+				{place: grouped(2, trader, 120, 10, order.Ask, order.OCOLeg), resting: []int64{1, 2}, hasGroup: true},
+				{place: grouped(3, maker, 100, 4, order.Ask, 0), resting: []int64{1}, hasGroup: true, cancelled: []int64{2}},
+				{place: grouped(4, maker, 100, 6, order.Ask, 0), cancelled: []int64{1}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := NewNaive(&symbol.Symbol{})
+
+			for i, step := range test.steps {
+				var res *MatcherResult
+
+				if step.place != nil {
+					res = n.PlaceGTC(step.place)
+				} else {
+					res = n.MassCancel(order.NewMassCancel(trader, 0, 0))
+				}
+
+				want := step.code
+
+				if want == 0 {
+					want = resultcode.Success
+				}
+
+				if res.Code != want {
+					t.Fatalf("step %v: code %v, want %v", i, res.Code, want)
+				}
+
+				for _, orderID := range step.resting {
+					if _, ok := n.orders[orderID]; !ok {
+						t.Errorf("step %v: order %v not resting", i, orderID)
+					}
+				}
+
+				g, ok := n.groups[7]
+
+				if ok != step.hasGroup {
+					t.Fatalf("step %v: group exists %v, want %v", i, ok, step.hasGroup)
+				}
+
+				heldIDs := make(map[int64]bool)
+
+				if ok {
+					for _, place := range g.held() {
+						heldIDs[place.OrderID()] = true
+					}
+				}
+
+				if len(heldIDs) != len(step.held) {
+					t.Errorf("step %v: %v held, want %v", i, len(heldIDs), len(step.held))
+				}
+
+				for _, orderID := range step.held {
+					if !heldIDs[orderID] {
+						t.Errorf("step %v: order %v not held", i, orderID)
+					}
+				}
+
+				for _, orderID := range step.cancelled {
+					if _, ok := n.orders[orderID]; ok || heldIDs[orderID] {
+						t.Errorf("step %v: order %v still active", i, orderID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHeld(t *testing.T) {
+	g := newGroup(7, trader, true)
+	g.pending = make([]*order.Place, 0, 4)
+	g.pending = append(g.pending, grouped(2, trader, 110, 10, order.Ask, order.BracketTakeProfit))
+	g.stop = grouped(3, trader, 90, 10, order.Ask, order.BracketStopLoss)
+
+	held := g.held()
+	g.pending = append(g.pending, grouped(4, trader, 120, 10, order.Ask, order.BracketTakeProfit))
+
+	if len(held) != 2 || held[1].OrderID() != 3 {
+		t.Errorf("held legs changed by a later pending leg")
+	}
+}
+
+func TestHeldSnapshot(t *testing.T) {
+	n := NewNaive(&symbol.Symbol{})
+
+	legs := []struct {
+		orderID int64
+		price   int64
+		action  order.Action
+		role    order.GroupRole
+	}{
+		{1, 100, order.Bid, order.BracketEntry},
+		{2, 110, order.Ask, order.BracketTakeProfit},
+		{3, 90, order.Ask, order.BracketStopLoss},
+	}
+
+	for _, leg := range legs {
+		place := order.NewPlace(leg.orderID, trader, leg.price, 10, leg.price, 0, leg.orderID*10, leg.action, order.GTC)
+		place.SetGroup(7, leg.role)
+
+		if res := n.PlaceGTC(place); res.Code != resultcode.Success {
+			t.Fatalf("place %v: %v", leg.orderID, res.Code)
+		}
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := n.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &Naive{}
+
+	if err := restored.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	g, ok := restored.groups[7]
+
+	if !ok {
+		t.Fatal("group not restored")
+	}
+
+	held := g.held()
+
+	if len(held) != 2 {
+		t.Fatalf("%v held legs, want 2", len(held))
+	}
+
+	for _, place := range held {
+		if place.Timestamp() != place.OrderID()*10 {
+			t.Errorf("leg %v: timestamp %v, want %v", place.OrderID(), place.Timestamp(), place.OrderID()*10)
+		}
+	}
+}
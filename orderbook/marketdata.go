@@ -1,5 +1,9 @@
 package orderbook
 
+import (
+	"github.com/xerexchain/matching-engine/order"
+)
+
 const (
 	_l2Size = 32
 )
@@ -91,3 +95,77 @@ func (l *L2MarketData) SetBidQuantityAt(index int32, quantity int64) {
 func (l *L2MarketData) SetNumBidOrdersAt(index int32, num int32) {
 	l.numBidOrders[index] = num
 }
+
+func (l *L2MarketData) AskPriceAt(index int32) int64 {
+	return l.askPrices[index]
+}
+
+func (l *L2MarketData) AskQuantityAt(index int32) int64 {
+	return l.askQuantites[index]
+}
+
+func (l *L2MarketData) NumAskOrdersAt(index int32) int32 {
+	return l.numAskOrders[index]
+}
+
+func (l *L2MarketData) BidPriceAt(index int32) int64 {
+	return l.bidPrices[index]
+}
+
+func (l *L2MarketData) BidQuantityAt(index int32) int64 {
+	return l.bidQuantities[index]
+}
+
+func (l *L2MarketData) NumBidOrdersAt(index int32) int32 {
+	return l.numBidOrders[index]
+}
+
+type L2DeltaKind int8
+
+const (
+	L2LevelAdded L2DeltaKind = iota + 1
+	L2LevelChanged
+	L2LevelRemoved
+)
+
+// New state of a price level, quantity is 0 if the level is removed.
+type L2Delta struct {
+	kind      L2DeltaKind
+	action    order.Action
+	price     int64
+	quantity  int64
+	numOrders int32
+	_         struct{}
+}
+
+func (d *L2Delta) Kind() L2DeltaKind {
+	return d.kind
+}
+
+func (d *L2Delta) Action() order.Action {
+	return d.action
+}
+
+func (d *L2Delta) Price() int64 {
+	return d.price
+}
+
+func (d *L2Delta) Quantity() int64 {
+	return d.quantity
+}
+
+func (d *L2Delta) NumOrders() int32 {
+	return d.numOrders
+}
+
+type levelKey struct {
+	action order.Action
+	price  int64
+	_      struct{}
+}
+
+type level struct {
+	quantity  int64
+	numOrders int32
+	_         struct{}
+}
@@ -0,0 +1,198 @@
+package orderbook
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+type L3EventKind int8
+
+const (
+	L3OrderAdded L3EventKind = iota + 1
+	L3OrderModified
+	L3OrderExecuted // deleted if quantity is 0
+	L3OrderDeleted
+)
+
+/*
+ * Order-level change of the book (market by order).
+ * Order ids are public ids, not the ids of the engine.
+ * A modified order keeps its queue position,
+ * an added order is at the end of its price level.
+ */
+type L3Event struct {
+	kind     L3EventKind
+	orderID  int64
+	action   order.Action
+	price    int64
+	quantity int64 // remained
+	executed int64 // only for executions
+	tradeID  int64 // only for executions
+	_        struct{}
+}
+
+func (e *L3Event) Kind() L3EventKind {
+	return e.kind
+}
+
+func (e *L3Event) OrderID() int64 {
+	return e.orderID
+}
+
+func (e *L3Event) Action() order.Action {
+	return e.action
+}
+
+func (e *L3Event) Price() int64 {
+	return e.price
+}
+
+func (e *L3Event) Quantity() int64 {
+	return e.quantity
+}
+
+func (e *L3Event) Executed() int64 {
+	return e.executed
+}
+
+func (e *L3Event) TradeID() int64 {
+	return e.tradeID
+}
+
+// Resting order of the L3 snapshot, with public id.
+type L3Order struct {
+	orderID  int64
+	action   order.Action
+	price    int64
+	quantity int64
+	_        struct{}
+}
+
+func (o *L3Order) OrderID() int64 {
+	return o.orderID
+}
+
+func (o *L3Order) Action() order.Action {
+	return o.action
+}
+
+func (o *L3Order) Price() int64 {
+	return o.price
+}
+
+func (o *L3Order) Quantity() int64 {
+	return o.quantity
+}
+
+func (n *Naive) recordL3(
+	kind L3EventKind,
+	ord *order.Order,
+	executed int64,
+	tradeID int64,
+) {
+	n.l3 = append(n.l3, &L3Event{
+		kind:     kind,
+		orderID:  n.publicIDs[ord.ID()],
+		action:   ord.Action(),
+		price:    ord.Price(),
+		quantity: ord.Remained(),
+		executed: executed,
+		tradeID:  tradeID,
+	})
+}
+
+// Order-level changes since the last call, in order of occurrence.
+func (n *Naive) L3Events() []*L3Event {
+	events := n.l3
+	n.l3 = nil
+
+	return events
+}
+
+// Resting orders in priority order, asks first.
+func (n *Naive) L3Orders() []*L3Order {
+	askOrders := n.AskOrders()
+	bidOrders := n.BidOrders()
+	orders := make([]*L3Order, 0, len(askOrders)+len(bidOrders))
+
+	for _, v := range append(askOrders, bidOrders...) {
+		ord := v.(*order.Order)
+		orders = append(orders, &L3Order{
+			orderID:  n.publicIDs[ord.ID()],
+			action:   ord.Action(),
+			price:    ord.Price(),
+			quantity: ord.Remained(),
+		})
+	}
+
+	return orders
+}
+
+func (n *Naive) marshalPublicIDs(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(n.lastPublicID, out); err != nil {
+		return err
+	}
+
+	orderIDs := make([]int64, 0, len(n.publicIDs))
+
+	for id := range n.publicIDs {
+		orderIDs = append(orderIDs, id)
+	}
+
+	sort.Slice(orderIDs, func(i, j int) bool {
+		return orderIDs[i] < orderIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(orderIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range orderIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(n.publicIDs[id], out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalPublicIDs(in *bytes.Buffer) (int64, map[int64]int64, error) {
+	lastPublicID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	publicIDs := make(map[int64]int64, size)
+
+	for ; size > 0; size-- {
+		orderID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		publicID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		publicIDs[orderID] = publicID
+	}
+
+	return lastPublicID, publicIDs, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/google/btree"
 	"github.com/xerexchain/matching-engine/order"
@@ -34,7 +35,27 @@ type MatcherResult struct {
 	Head event.Event
 	Tail event.Event
 	Code resultcode.ResultCode
-	_    struct{}
+
+	// per item of multi-item commands (mass quote levels, batch commands)
+	Codes []resultcode.ResultCode
+	_     struct{}
+}
+
+// Appends a chain of events to the end of the result.
+func (m *MatcherResult) Append(head event.Event) {
+	if head == nil {
+		return
+	}
+
+	if m.Head == nil {
+		m.Head = head
+	} else if m.Tail == nil {
+		m.Head.FindTail().SetNext(head)
+	} else {
+		m.Tail.SetNext(head)
+	}
+
+	m.Tail = head.FindTail()
 }
 
 type _OrderBook interface {
@@ -56,7 +77,42 @@ type Naive struct {
 	bidBuckets *btree.BTree
 	symbol     Symbol
 	orders     map[int64]*order.Order // used for reverse lookup
-	_          struct{}
+
+	// userID -> orderID -> order
+	userOrders map[int64]map[int64]*order.Order
+
+	// (userID, clientOrderID) -> orderID of live orders
+	clientOrders map[clientOrderKey]int64
+
+	// linked order groups (OCO, bracket)
+	groups      map[int64]*group
+	orderGroups map[int64]int64 // orderID -> groupID
+
+	// userID -> orderIDs of the last mass quote
+	// may contain ids of filled or cancelled orders
+	quotes map[int64][]int64
+
+	// id of the last trade, trade ids are generated by the book
+	// so that replaying the journal reproduces them
+	lastTradeID int64
+
+	// price levels changed since the last `L2Deltas`, with the state before
+	// not serialized, deltas are taken after each command
+	touched map[levelKey]level
+
+	// orderID -> public id of resting orders, for the L3 feed
+	publicIDs    map[int64]int64
+	lastPublicID int64
+
+	// order-level changes since the last `L3Events`, not serialized
+	l3 []*L3Event
+
+	// trades since the last `Trades`, not serialized
+	trades []*event.Trade
+
+	// userID -> resting quantity changes since the last `PendingChanges`, not serialized
+	pending map[int64]*PendingChange
+	_       struct{}
 }
 
 func NewNaive(symbol_ Symbol) *Naive {
@@ -65,7 +121,92 @@ func NewNaive(symbol_ Symbol) *Naive {
 		bidBuckets: btree.New(_btreeDegree),
 		symbol:     symbol_,
 		orders:     make(map[int64]*order.Order),
+		userOrders: make(map[int64]map[int64]*order.Order),
+
+		clientOrders: make(map[clientOrderKey]int64),
+
+		groups:      make(map[int64]*group),
+		orderGroups: make(map[int64]int64),
+
+		quotes: make(map[int64][]int64),
+
+		touched: make(map[levelKey]level),
+
+		publicIDs: make(map[int64]int64),
+
+		pending: make(map[int64]*PendingChange),
+	}
+}
+
+func (n *Naive) index(ord *order.Order) {
+	n.orders[ord.ID()] = ord
+	userOrders, ok := n.userOrders[ord.UserID()]
+
+	if !ok {
+		userOrders = make(map[int64]*order.Order)
+		n.userOrders[ord.UserID()] = userOrders
+	}
+
+	userOrders[ord.ID()] = ord
+
+	if ord.ClientOrderID() != 0 {
+		n.clientOrders[clientOrderKey{userID: ord.UserID(), clientOrderID: ord.ClientOrderID()}] = ord.ID()
+	}
+}
+
+func (n *Naive) unindex(orderID int64) {
+	ord, ok := n.orders[orderID]
+
+	if !ok {
+		return
+	}
+
+	delete(n.orders, orderID)
+	userOrders := n.userOrders[ord.UserID()]
+	delete(userOrders, orderID)
+
+	if len(userOrders) == 0 {
+		delete(n.userOrders, ord.UserID())
+	}
+
+	if ord.ClientOrderID() != 0 {
+		delete(n.clientOrders, clientOrderKey{userID: ord.UserID(), clientOrderID: ord.ClientOrderID()})
+	}
+}
+
+type clientOrderKey struct {
+	userID        int64
+	clientOrderID int64
+	_             struct{}
+}
+
+// Live order of the user by client order id.
+func (n *Naive) OrderByClientID(
+	userID int64,
+	clientOrderID int64,
+) (*order.Order, bool) {
+	orderID, ok := n.clientOrders[clientOrderKey{userID: userID, clientOrderID: clientOrderID}]
+
+	if !ok {
+		return nil, false
+	}
+
+	ord, ok := n.orders[orderID]
+
+	return ord, ok
+}
+
+// Commands identify orders by `orderID`, or by client order id when it is 0.
+func (n *Naive) resolveOrderID(
+	orderID int64,
+	userID int64,
+	clientOrderID int64,
+) int64 {
+	if orderID != 0 || clientOrderID == 0 {
+		return orderID
 	}
+
+	return n.clientOrders[clientOrderKey{userID: userID, clientOrderID: clientOrderID}]
 }
 
 func (n *Naive) sameBucketsAs(
@@ -148,6 +289,12 @@ func (n *Naive) budgetToFill(
 	return budget, collected
 }
 
+func (n *Naive) nextTradeID() int64 {
+	n.lastTradeID++
+
+	return n.lastTradeID
+}
+
 func (n *Naive) match(
 	command *order.Place, // TODO rename
 ) *MatcherResult {
@@ -156,22 +303,43 @@ func (n *Naive) match(
 		tail         *event.Trade
 		emptyBuckets []*bucket.Bucket
 		pivot        = bucket.With(command.Price())
+		makerAction  = order.Bid
 	)
 
+	if command.Action() == order.Bid {
+		makerAction = order.Ask
+	}
+
 	f := func(item btree.Item) bool {
 		if command.Quantity() == 0 {
 			return false
 		}
 
 		bucket_ := item.(*bucket.Bucket)
+		n.touch(makerAction, bucket_.Price())
 
 		res := bucket_.Match(
+			n.symbol.ID(),
 			command.Quantity(),
 			command.ReservedPrice(),
+			command.OrderID(),
+			command.UserID(),
+			n.nextTradeID,
 		)
 
+		for trade := res.Head; trade != nil; trade, _ = trade.Next().(*event.Trade) {
+			if ord, ok := n.orders[trade.MakerOrderID()]; ok {
+				n.recordL3(L3OrderExecuted, ord, trade.Quantity(), trade.TradeID())
+			}
+
+			n.removePending(trade.MakerUserID(), trade.MakerAction(), trade.Price(), trade.Quantity())
+
+			n.trades = append(n.trades, trade)
+		}
+
 		for _, orderID := range res.RemovedOrders {
-			delete(n.orders, orderID)
+			n.unindex(orderID)
+			delete(n.publicIDs, orderID)
 		}
 
 		if tail == nil {
@@ -205,11 +373,25 @@ func (n *Naive) match(
 		targetBuckets.Delete(bucket_)
 	}
 
-	return &MatcherResult{
-		Head: head,
-		Tail: tail,
+	res := &MatcherResult{
 		Code: resultcode.Success,
 	}
+
+	// avoid typed nil events
+	if head != nil {
+		res.Head = head
+		res.Tail = tail
+	}
+
+	return res
+}
+
+// Trades since the last call, in order of execution.
+func (n *Naive) Trades() []*event.Trade {
+	trades := n.trades
+	n.trades = nil
+
+	return trades
 }
 
 func (n *Naive) Symbol() Symbol {
@@ -224,8 +406,116 @@ func (n *Naive) NumBidBuckets() int32 {
 	return int32(n.bidBuckets.Len())
 }
 
+// Best price level of resting orders of the side.
+func (n *Naive) Best(
+	action order.Action,
+) (price int64, quantity int64, ok bool) {
+	f := func(item btree.Item) bool {
+		bucket_ := item.(*bucket.Bucket)
+		price = bucket_.Price()
+		quantity = bucket_.TotalQuantity()
+		ok = true
+
+		return false
+	}
+
+	if action == order.Ask {
+		n.askBuckets.Ascend(f)
+	} else {
+		n.bidBuckets.Descend(f)
+	}
+
+	return price, quantity, ok
+}
+
+/*
+ * Matches the command against resting orders without resting or rejecting
+ * the remainder, the quantity of the command is reduced by the filled quantity.
+ * Group rules apply to fills of resting grouped orders.
+ * Used by engines coordinating several books (spreads).
+ */
+func (n *Naive) Match(
+	command *order.Place,
+) *MatcherResult {
+	res := n.match(command)
+	n.settleGroups(command.OrderID(), res)
+
+	return res
+}
+
+/*
+ * Fills `quantity` of the command against implied liquidity at `price`,
+ * the maker order belongs to another book (e.g. a spread order) and does not rest in this one.
+ * The quantity of the command is reduced by `quantity`.
+ */
+func (n *Naive) MatchImplied(
+	command *order.Place,
+	makerOrderID int64,
+	makerUserID int64,
+	makerOrderCompleted bool,
+	makerRemained int64,
+	price int64,
+	quantity int64,
+) *MatcherResult {
+	makerAction := order.Bid
+	bidderHoldPrice := price
+
+	if command.Action() == order.Bid {
+		makerAction = order.Ask
+		bidderHoldPrice = command.ReservedPrice()
+	}
+
+	command.Reduce(quantity)
+
+	trade := event.NewTrade(
+		n.symbol.ID(),
+		n.nextTradeID(),
+		makerOrderID,
+		makerUserID,
+		command.OrderID(),
+		command.UserID(),
+		makerOrderCompleted,
+		command.Quantity() == 0,
+		makerAction,
+		makerRemained,
+		command.Quantity(),
+		price,
+		quantity,
+		bidderHoldPrice,
+	)
+	n.trades = append(n.trades, trade)
+
+	return &MatcherResult{
+		Code: resultcode.Success,
+		Head: trade,
+		Tail: trade,
+	}
+}
+
 func (n *Naive) PlaceGTC(
 	gtc *order.Place,
+) *MatcherResult {
+	if gtc.GroupID() == 0 {
+		res := n.placeGTC(gtc)
+		n.settleGroups(gtc.OrderID(), res)
+
+		return res
+	}
+
+	g, held, code := n.joinGroup(gtc)
+	res := &MatcherResult{
+		Code: code,
+	}
+
+	if code == resultcode.Success && !held {
+		n.placeLeg(g, gtc, res)
+	}
+
+	return res
+}
+
+func (n *Naive) placeGTC(
+	gtc *order.Place,
 ) *MatcherResult {
 	res := n.match(gtc)
 
@@ -237,6 +527,7 @@ func (n *Naive) PlaceGTC(
 		log.Printf("duplicate order id: %v", gtc.OrderID())
 
 		e := event.NewReject(
+			n.symbol.ID(),
 			gtc.OrderID(),
 			gtc.Price(),
 			gtc.Quantity(),
@@ -245,10 +536,15 @@ func (n *Naive) PlaceGTC(
 		e.SetNext(res.Head)
 		res.Head = e
 
+		if res.Tail == nil {
+			res.Tail = e
+		}
+
 		return res
 	}
 
 	targetBuckets := n.sameBucketsAs(gtc.Action())
+	n.touch(gtc.Action(), gtc.Price())
 
 	bucket_, ok := n.findBucket(gtc.Price(), targetBuckets)
 
@@ -267,24 +563,47 @@ func (n *Naive) PlaceGTC(
 		gtc.ReservedPrice(),
 		gtc.Timestamp(), // TODO current time?
 		gtc.Action(),
+		gtc.SessionID(),
+		gtc.ClientOrderID(),
 	)
 
 	bucket_.Put(ord)
-	n.orders[ord.ID()] = ord
+	n.index(ord)
+	n.addPending(ord.UserID(), ord.Action(), ord.Price(), ord.Remained())
+	n.lastPublicID++
+	n.publicIDs[ord.ID()] = n.lastPublicID
+	n.recordL3(L3OrderAdded, ord, 0, 0)
+	res.Append(event.NewAccept(
+		n.symbol.ID(),
+		ord.ID(),
+		ord.UserID(),
+		ord.Price(),
+		ord.Remained(),
+		ord.Action(),
+	))
 
 	return res
 }
 
+// Only GTC orders can be grouped.
 func (n *Naive) PlaceIOC(
 	ioc *order.Place,
 ) *MatcherResult {
+	if ioc.GroupID() != 0 {
+		return &MatcherResult{
+			Code: resultcode.MatchingInvalidOrderGroup,
+		}
+	}
+
 	res := n.match(ioc)
+	n.settleGroups(ioc.OrderID(), res)
 
 	if ioc.Quantity() == 0 {
 		return res
 	}
 
 	e := event.NewReject(
+		n.symbol.ID(),
 		ioc.OrderID(),
 		ioc.Price(),
 		ioc.Quantity(),
@@ -293,12 +612,23 @@ func (n *Naive) PlaceIOC(
 	e.SetNext(res.Head)
 	res.Head = e
 
+	if res.Tail == nil {
+		res.Tail = e
+	}
+
 	return res
 }
 
+// Only GTC orders can be grouped.
 func (n *Naive) PlaceFOKBudget(
 	fok *order.Place,
 ) *MatcherResult {
+	if fok.GroupID() != 0 {
+		return &MatcherResult{
+			Code: resultcode.MatchingInvalidOrderGroup,
+		}
+	}
+
 	action := fok.Action()
 	price := fok.Price()
 	quantity := fok.Quantity()
@@ -308,9 +638,13 @@ func (n *Naive) PlaceFOKBudget(
 	if collected == quantity || ((price == budget) ||
 		((action == order.Ask) && (budget <= price)) ||
 		((action == order.Bid) && (budget > price))) {
-		return n.match(fok)
+		res := n.match(fok)
+		n.settleGroups(fok.OrderID(), res)
+
+		return res
 	} else {
 		e := event.NewReject(
+			n.symbol.ID(),
 			fok.OrderID(),
 			fok.Price(),
 			fok.Quantity(),
@@ -325,11 +659,10 @@ func (n *Naive) PlaceFOKBudget(
 	}
 }
 
-// TODO check order.userID == cmd.userID (auth)
 func (n *Naive) Move(
 	command *order.Move, // TODO rename
 ) *MatcherResult {
-	orderID := command.OrderID()
+	orderID := n.resolveOrderID(command.OrderID(), command.UserID(), command.ClientOrderID())
 	toPrice := command.ToPrice()
 	ord, ok := n.orders[orderID]
 
@@ -339,6 +672,10 @@ func (n *Naive) Move(
 		}
 	}
 
+	if res := n.checkOwner(ord.UserID(), command.UserID(), command.ServiceFlags(), orderID); res != nil {
+		return res
+	}
+
 	if toPrice <= 0 || toPrice == ord.Price() {
 		return &MatcherResult{
 			// TODO proper response code
@@ -365,33 +702,156 @@ func (n *Naive) Move(
 		ord.Action(),
 		order.GTC,
 	)
+	gtc.SetSession(ord.SessionID())
+	gtc.SetClientOrderID(ord.ClientOrderID())
 
-	// TODO rename
-	reduce := order.NewReduce(
-		ord.ID(),
+	// group rules are not applied to the internal reduce
+	res := n.reduce(ord, ord.Remained())
+	res.Append(event.NewReplace(
 		n.symbol.ID(),
-		ord.Remained(),
-	)
+		gtc.OrderID(),
+		gtc.ClientOrderID(),
+		gtc.Price(),
+		gtc.Quantity(),
+		gtc.Action(),
+		false, /*priorityKept*/
+	))
 
-	// TODO rename
-	reduceResult := n.Reduce(reduce)
-	// TODO rename
 	placeResult := n.PlaceGTC(gtc)
+	res.Append(placeResult.Head)
+	res.Code = placeResult.Code // TODO success?
 
-	reduceResult.Tail.SetNext(placeResult.Head)
+	return res
+}
 
-	return &MatcherResult{
-		Head: reduceResult.Head,
-		Tail: placeResult.Tail,
-		Code: placeResult.Code, // TODO success?
+/*
+ * Priority is kept when only the quantity is reduced,
+ * otherwise the order is removed and placed again (and may match).
+ */
+func (n *Naive) Amend(
+	command *order.Amend,
+) *MatcherResult {
+	orderID := n.resolveOrderID(command.OrderID(), command.UserID(), command.OrigClientOrderID())
+	ord, ok := n.orders[orderID]
+
+	if !ok {
+		return &MatcherResult{
+			Code: resultcode.MatchingUnknownOrderID,
+		}
+	}
+
+	if res := n.checkOwner(ord.UserID(), command.UserID(), command.ServiceFlags(), ord.ID()); res != nil {
+		return res
 	}
+
+	price := command.Price()
+	quantity := command.Quantity()
+
+	// TODO negative prices of spreads
+	if price < 0 {
+		return &MatcherResult{
+			Code: resultcode.MatchingMoveFailedPriceInvalid,
+		}
+	}
+
+	if price == 0 {
+		price = ord.Price()
+	}
+
+	if quantity < 0 {
+		return &MatcherResult{
+			Code: resultcode.MatchingReduceFailedWrongQuantity,
+		}
+	}
+
+	if quantity == 0 {
+		quantity = ord.Remained()
+	}
+
+	clientOrderID := ord.ClientOrderID()
+
+	if command.ClientOrderID() != 0 && command.ClientOrderID() != clientOrderID {
+		if _, ok := n.OrderByClientID(ord.UserID(), command.ClientOrderID()); ok {
+			return &MatcherResult{
+				Code: resultcode.MatchingDuplicateClientOrderID,
+			}
+		}
+
+		clientOrderID = command.ClientOrderID()
+	}
+
+	// reserved price risk check for exchange bids
+	if ord.Action() == order.Bid && price != ord.Price() && price > ord.ReservedBidPrice() {
+		return &MatcherResult{
+			Code: resultcode.MatchingMoveFailedPriceOverRiskLimit,
+		}
+	}
+
+	if price == ord.Price() && quantity <= ord.Remained() {
+		res := &MatcherResult{
+			Code: resultcode.Success,
+		}
+
+		if quantity < ord.Remained() {
+			res = n.reduce(ord, ord.Remained()-quantity)
+		}
+
+		if clientOrderID != ord.ClientOrderID() && ord.Remained() > 0 {
+			n.unindex(ord.ID())
+			ord.SetClientOrderID(clientOrderID)
+			n.index(ord)
+		}
+
+		res.Append(event.NewReplace(
+			n.symbol.ID(),
+			ord.ID(),
+			clientOrderID,
+			price,
+			ord.Remained(),
+			ord.Action(),
+			true, /*priorityKept*/
+		))
+
+		return res
+	}
+
+	gtc := order.NewPlace(
+		ord.ID(),
+		ord.UserID(),
+		price,
+		quantity,
+		ord.ReservedBidPrice(),
+		n.symbol.ID(),
+		ord.Timestamp(), // TODO current time?
+		ord.Action(),
+		order.GTC,
+	)
+	gtc.SetSession(ord.SessionID())
+	gtc.SetClientOrderID(clientOrderID)
+
+	// group rules are not applied to the internal reduce
+	res := n.reduce(ord, ord.Remained())
+	res.Append(event.NewReplace(
+		n.symbol.ID(),
+		gtc.OrderID(),
+		gtc.ClientOrderID(),
+		gtc.Price(),
+		gtc.Quantity(),
+		gtc.Action(),
+		false, /*priorityKept*/
+	))
+
+	placeResult := n.PlaceGTC(gtc)
+	res.Append(placeResult.Head)
+	res.Code = placeResult.Code
+
+	return res
 }
 
-// TODO check order.userID == cmd.userID (auth)
 func (n *Naive) Reduce(
 	command *order.Reduce, // TODO rename
 ) *MatcherResult {
-	orderID := command.OrderID()
+	orderID := n.resolveOrderID(command.OrderID(), command.UserID(), command.ClientOrderID())
 	quantity := command.Quantity()
 
 	if quantity <= 0 {
@@ -408,11 +868,31 @@ func (n *Naive) Reduce(
 		}
 	}
 
+	if res := n.checkOwner(ord.UserID(), command.UserID(), command.ServiceFlags(), orderID); res != nil {
+		return res
+	}
+
+	res := n.reduce(ord, quantity)
+
+	if ord.Remained() == 0 {
+		n.onGroupCancel(orderID, res)
+	}
+
+	return res
+}
+
+func (n *Naive) reduce(
+	ord *order.Order,
+	quantity int64,
+) *MatcherResult {
+	orderID := ord.ID()
+
 	if quantity > ord.Remained() {
 		quantity = ord.Remained()
 	}
 
 	targetBuckets := n.sameBucketsAs(ord.Action())
+	n.touch(ord.Action(), ord.Price())
 
 	bucket_, ok := n.findBucket(ord.Price(), targetBuckets)
 
@@ -422,6 +902,7 @@ func (n *Naive) Reduce(
 	}
 
 	bucket_.Reduce(quantity)
+	n.removePending(ord.UserID(), ord.Action(), ord.Price(), quantity)
 
 	if err := ord.Reduce(quantity); err != nil {
 		// not possible state
@@ -429,15 +910,20 @@ func (n *Naive) Reduce(
 	}
 
 	if ord.Remained() == 0 {
-		delete(n.orders, orderID)
+		n.recordL3(L3OrderDeleted, ord, 0, 0)
+		n.unindex(orderID)
+		delete(n.publicIDs, orderID)
 		bucket_.Remove(orderID)
 
 		if bucket_.TotalQuantity() == 0 {
 			targetBuckets.Delete(bucket_)
 		}
+	} else {
+		n.recordL3(L3OrderModified, ord, 0, 0)
 	}
 
 	e := event.NewReduce(
+		n.symbol.ID(),
 		orderID,
 		ord.Remained() == 0, /*makerOrderCompleted*/
 		ord.Price(),
@@ -452,49 +938,237 @@ func (n *Naive) Reduce(
 	}
 }
 
-// TODO check order.userID == cmd.userID (auth)
 func (n *Naive) Cancel(
 	command *order.Cancel, // TODO rename
 ) *MatcherResult {
-	orderID := command.OrderID()
+	orderID := n.resolveOrderID(command.OrderID(), command.UserID(), command.ClientOrderID())
 	ord, ok := n.orders[orderID]
 
 	if !ok {
+		if groupID, ok := n.orderGroups[orderID]; ok {
+			ownerID := n.groups[groupID].userID
+
+			if res := n.checkOwner(ownerID, command.UserID(), command.ServiceFlags(), orderID); res != nil {
+				return res
+			}
+		}
+
+		if res, ok := n.cancelHeldLeg(orderID); ok {
+			return res
+		}
+
 		return &MatcherResult{
 			Code: resultcode.MatchingUnknownOrderID,
 		}
 	}
 
-	// TODO rename
-	reduce := order.NewReduce(
-		ord.ID(),
-		n.symbol.ID(),
-		ord.Remained(),
-	)
+	if res := n.checkOwner(ord.UserID(), command.UserID(), command.ServiceFlags(), orderID); res != nil {
+		return res
+	}
 
-	return n.Reduce(reduce)
+	res := n.reduce(ord, ord.Remained())
+	n.onGroupCancel(orderID, res)
+
+	return res
 }
 
-// TODO performance
-func (n *Naive) UserOrders(
+/*
+ * Orders can be changed only by their owner,
+ * unless the command has `order.OwnershipOverride` service flag, set by the router for admins.
+ * Returns nil if allowed, otherwise the rejected result with an audit event.
+ */
+func (n *Naive) checkOwner(
+	ownerID int64,
 	userID int64,
-) []*order.Order {
-	var userOrders []*order.Order
+	serviceFlags int32,
+	orderID int64,
+) *MatcherResult {
+	if ownerID == userID || serviceFlags&order.OwnershipOverride != 0 {
+		return nil
+	}
 
-	f := func(item btree.Item) bool {
-		bucket_ := item.(*bucket.Bucket)
+	e := event.NewUnauthorized(n.symbol.ID(), orderID, userID)
+
+	return &MatcherResult{
+		Head: e,
+		Tail: e,
+		Code: resultcode.AuthOrderNotOwned,
+	}
+}
+
+/*
+ * Cancels all orders of a user, optionally only one side (`action` 0 means both).
+ * Emits one reduce event per resting order,
+ * orders held by groups (bracket exit legs) are rejected.
+ */
+func (n *Naive) MassCancel(
+	command *order.MassCancel,
+) *MatcherResult {
+	return n.cancelUserOrders(command.UserID(), command.Action(), 0)
+}
+
+// Cancels all orders of a user placed through the session.
+func (n *Naive) CancelSession(
+	userID int64,
+	sessionID int64,
+) *MatcherResult {
+	return n.cancelUserOrders(userID, 0, sessionID)
+}
+
+// Cancels orders of all users, including held legs of groups, e.g. on delisting.
+func (n *Naive) CancelAll() *MatcherResult {
+	seen := make(map[int64]struct{}, len(n.userOrders))
+	userIDs := make([]int64, 0, len(n.userOrders))
+
+	for userID := range n.userOrders {
+		seen[userID] = struct{}{}
+		userIDs = append(userIDs, userID)
+	}
+
+	for _, g := range n.groups {
+		if _, ok := seen[g.userID]; !ok {
+			seen[g.userID] = struct{}{}
+			userIDs = append(userIDs, g.userID)
+		}
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+
+	res := &MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, userID := range userIDs {
+		res.Append(n.cancelUserOrders(userID, 0, 0).Head)
+	}
+
+	return res
+}
+
+// `action` and `sessionID` filters are ignored when 0.
+func (n *Naive) cancelUserOrders(
+	userID int64,
+	action order.Action,
+	sessionID int64,
+) *MatcherResult {
+	orderIDs := make([]int64, 0, len(n.userOrders[userID]))
+
+	for orderID := range n.userOrders[userID] {
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	// deterministic order of events
+	sort.Slice(orderIDs, func(i, j int) bool {
+		return orderIDs[i] < orderIDs[j]
+	})
+
+	res := &MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, orderID := range orderIDs {
+		ord, ok := n.orders[orderID]
+
+		// already cancelled as a group sibling
+		if !ok {
+			continue
+		}
+
+		if action != 0 && ord.Action() != action {
+			continue
+		}
+
+		if sessionID != 0 && ord.SessionID() != sessionID {
+			continue
+		}
+
+		reduceResult := n.reduce(ord, ord.Remained())
+		n.onGroupCancel(orderID, reduceResult)
+		res.Append(reduceResult.Head)
+	}
+
+	groupIDs := make([]int64, 0)
+
+	for groupID, g := range n.groups {
+		if g.userID == userID {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+
+	sort.Slice(groupIDs, func(i, j int) bool {
+		return groupIDs[i] < groupIDs[j]
+	})
+
+	for _, groupID := range groupIDs {
+		g, ok := n.groups[groupID]
 
-		bucket_.ForEachOrder(func(ord *order.Order) {
-			if userID == ord.UserID() {
-				userOrders = append(userOrders, ord)
+		if !ok {
+			continue
+		}
+
+		for _, place := range g.held() {
+			if action != 0 && place.Action() != action {
+				continue
 			}
-		})
 
+			if sessionID != 0 && place.SessionID() != sessionID {
+				continue
+			}
+
+			if cancelResult, ok := n.cancelHeldLeg(place.OrderID()); ok {
+				res.Append(cancelResult.Head)
+			}
+		}
+	}
+
+	return res
+}
+
+// Orders of the user resting in the book or held by its order groups (bracket exits, stops).
+func (n *Naive) HasOrders(userID int64) bool {
+	if len(n.userOrders[userID]) > 0 {
 		return true
 	}
 
-	n.askBuckets.Ascend(f)
-	n.bidBuckets.Descend(f)
+	for _, g := range n.groups {
+		if g.userID == userID && (len(g.pending) > 0 || g.stop != nil) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Served from the per-user index, costs O(k log k) for k orders of the user.
+// Asks come first, then bids, in price priority. Same price orders by id.
+func (n *Naive) UserOrders(
+	userID int64,
+) []*order.Order {
+	userOrders := make([]*order.Order, 0, len(n.userOrders[userID]))
+
+	for _, ord := range n.userOrders[userID] {
+		userOrders = append(userOrders, ord)
+	}
+
+	sort.Slice(userOrders, func(i, j int) bool {
+		a, b := userOrders[i], userOrders[j]
+
+		if a.Action() != b.Action() {
+			return a.Action() == order.Ask
+		}
+
+		if a.Price() != b.Price() {
+			if a.Action() == order.Ask {
+				return a.Price() < b.Price()
+			}
+
+			return a.Price() > b.Price()
+		}
+
+		return a.ID() < b.ID()
+	})
 
 	return userOrders
 }
@@ -580,6 +1254,84 @@ func (n *Naive) FillBids(size int32, marketData *L2MarketData) {
 	marketData.LimitBidViewTo(size)
 }
 
+// Records the state of the level before its first change.
+func (n *Naive) touch(
+	action order.Action,
+	price int64,
+) {
+	k := levelKey{action: action, price: price}
+
+	if _, ok := n.touched[k]; ok {
+		return
+	}
+
+	n.touched[k] = n.level(action, price)
+}
+
+func (n *Naive) level(
+	action order.Action,
+	price int64,
+) level {
+	bucket_, ok := n.findBucket(price, n.sameBucketsAs(action))
+
+	if !ok {
+		return level{}
+	}
+
+	return level{
+		quantity:  bucket_.TotalQuantity(),
+		numOrders: bucket_.NumOrders(),
+	}
+}
+
+/*
+ * Price levels changed since the last call, asks first, by price.
+ * Levels changed back to their previous state are skipped.
+ */
+func (n *Naive) L2Deltas() []*L2Delta {
+	if len(n.touched) == 0 {
+		return nil
+	}
+
+	deltas := make([]*L2Delta, 0, len(n.touched))
+
+	for k, before := range n.touched {
+		after := n.level(k.action, k.price)
+
+		if after == before {
+			continue
+		}
+
+		d := &L2Delta{
+			kind:      L2LevelChanged,
+			action:    k.action,
+			price:     k.price,
+			quantity:  after.quantity,
+			numOrders: after.numOrders,
+		}
+
+		if before.quantity == 0 {
+			d.kind = L2LevelAdded
+		} else if after.quantity == 0 {
+			d.kind = L2LevelRemoved
+		}
+
+		deltas = append(deltas, d)
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].action != deltas[j].action {
+			return deltas[i].action < deltas[j].action
+		}
+
+		return deltas[i].price < deltas[j].price
+	})
+
+	n.touched = make(map[levelKey]level)
+
+	return deltas
+}
+
 func (n *Naive) IsValid() bool {
 	ok := true
 
@@ -600,7 +1352,17 @@ func (n *Naive) IsValid() bool {
 
 	n.askBuckets.Descend(f)
 
-	return ok
+	if !ok {
+		return false
+	}
+
+	numIndexed := 0
+
+	for _, userOrders := range n.userOrders {
+		numIndexed += len(userOrders)
+	}
+
+	return numIndexed == len(n.orders)
 }
 
 func (n *Naive) Hash() uint64 {
@@ -644,9 +1406,82 @@ func (n *Naive) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	groupIDs := make([]int64, 0, len(n.groups))
+
+	for id := range n.groups {
+		groupIDs = append(groupIDs, id)
+	}
+
+	sort.Slice(groupIDs, func(i, j int) bool {
+		return groupIDs[i] < groupIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(groupIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range groupIDs {
+		if err := n.groups[id].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	if err := n.marshalQuotes(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(n.lastTradeID, out); err != nil {
+		return err
+	}
+
+	if err := n.marshalPublicIDs(out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+/*
+ * Changes not taken yet by `L2Deltas`, `L3Events`, `Trades` and `PendingChanges`.
+ * They are not serialized, so a book restored by `Unmarshal` within a command
+ * gets them back with `SetUntaken`.
+ */
+type Untaken struct {
+	touched map[levelKey]level
+	l3      []*L3Event
+	trades  []*event.Trade
+	pending map[int64]*PendingChange
+	_       struct{}
+}
+
+// Copy of the changes not taken yet.
+func (n *Naive) Untaken() *Untaken {
+	u := &Untaken{
+		touched: make(map[levelKey]level, len(n.touched)),
+		l3:      append([]*L3Event(nil), n.l3...),
+		trades:  append([]*event.Trade(nil), n.trades...),
+		pending: make(map[int64]*PendingChange, len(n.pending)),
+	}
+
+	for k, v := range n.touched {
+		u.touched[k] = v
+	}
+
+	for userID, c := range n.pending {
+		copy_ := *c
+		u.pending[userID] = &copy_
+	}
+
+	return u
+}
+
+func (n *Naive) SetUntaken(u *Untaken) {
+	n.touched = u.touched
+	n.l3 = u.l3
+	n.trades = u.trades
+	n.pending = u.pending
+}
+
 func (n *Naive) Unmarshal(in *bytes.Buffer) error {
 	d, err := serialization.ReadInt8(in)
 
@@ -703,6 +1538,51 @@ func (n *Naive) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	numGroups, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[int64]*group, numGroups)
+	orderGroups := make(map[int64]int64)
+
+	for ; numGroups > 0; numGroups-- {
+		g := &group{}
+
+		if err := g.Unmarshal(in); err != nil {
+			return err
+		}
+
+		groups[g.id] = g
+
+		for orderID := range g.legs {
+			orderGroups[orderID] = g.id
+		}
+
+		for _, place := range g.held() {
+			orderGroups[place.OrderID()] = g.id
+		}
+	}
+
+	quotes, err := unmarshalQuotes(in)
+
+	if err != nil {
+		return err
+	}
+
+	lastTradeID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	lastPublicID, publicIDs, err := unmarshalPublicIDs(in)
+
+	if err != nil {
+		return err
+	}
+
 	var numOrders int64 = 0
 
 	counter := func(item btree.Item) bool {
@@ -715,14 +1595,13 @@ func (n *Naive) Unmarshal(in *bytes.Buffer) error {
 	askBuckets.Ascend(counter)
 	bidBuckets.Descend(counter)
 
-	orders := make(map[int64]*order.Order, numOrders)
+	n.orders = make(map[int64]*order.Order, numOrders)
+	n.userOrders = make(map[int64]map[int64]*order.Order)
+	n.clientOrders = make(map[clientOrderKey]int64)
 
 	appender := func(item btree.Item) bool {
 		bucket_ := item.(*bucket.Bucket)
-
-		bucket_.ForEachOrder(func(ord *order.Order) {
-			orders[ord.ID()] = ord
-		})
+		bucket_.ForEachOrder(n.index)
 
 		return true
 	}
@@ -733,7 +1612,16 @@ func (n *Naive) Unmarshal(in *bytes.Buffer) error {
 	n.askBuckets = askBuckets
 	n.bidBuckets = bidBuckets
 	n.symbol = symbol_
-	n.orders = orders
+	n.groups = groups
+	n.orderGroups = orderGroups
+	n.quotes = quotes
+	n.lastTradeID = lastTradeID
+	n.touched = make(map[levelKey]level)
+	n.publicIDs = publicIDs
+	n.lastPublicID = lastPublicID
+	n.l3 = nil
+	n.trades = nil
+	n.pending = make(map[int64]*PendingChange)
 
 	return nil
 }
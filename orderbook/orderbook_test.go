@@ -0,0 +1,145 @@
+package orderbook
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+// Cancel commands are built by unmarshalling only.
+func cancel(
+	t *testing.T,
+	orderID int64,
+	userID int64,
+) *order.Cancel {
+	out := &bytes.Buffer{}
+
+	if err := (&order.Metadata{}).Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt64(userID, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt32(0, out); err != nil {
+		t.Fatal(err)
+	}
+
+	// order id, client order id
+	for _, v := range []int64{orderID, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := order.NewCancel()
+
+	if err := c.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func reduce(orderID int64, quantity int64) *order.Reduce {
+	r := order.NewReduce(orderID, 0, quantity)
+	r.SetServiceFlags(order.OwnershipOverride)
+
+	return r
+}
+
+func TestUserOrders(t *testing.T) {
+	n := NewNaive(&symbol.Symbol{})
+
+	steps := []struct {
+		name   string
+		exec   func() *MatcherResult
+		orders []int64 // of the trader, asks first, then bids, in price priority
+	}{
+		{
+			name: "place",
+			exec: func() *MatcherResult {
+				n.PlaceGTC(order.NewPlace(1, trader, 100, 10, 100, 0, 0, order.Bid, order.GTC))
+				n.PlaceGTC(order.NewPlace(2, trader, 99, 5, 99, 0, 0, order.Bid, order.GTC))
+
+				return n.PlaceGTC(order.NewPlace(3, trader, 110, 5, 110, 0, 0, order.Ask, order.GTC))
+			},
+			orders: []int64{3, 1, 2},
+		},
+		{
+			name: "partial fill",
+			exec: func() *MatcherResult {
+				return n.PlaceGTC(order.NewPlace(10, maker, 100, 4, 100, 0, 0, order.Ask, order.GTC))
+			},
+			orders: []int64{3, 1, 2},
+		},
+		{
+			name:   "reduce to zero",
+			exec:   func() *MatcherResult { return n.Reduce(reduce(2, 5)) },
+			orders: []int64{3, 1},
+		},
+		{
+			name:   "amend",
+			exec:   func() *MatcherResult { return n.Amend(order.NewAmend(1, trader, 0, 98, 6, 0)) },
+			orders: []int64{3, 1},
+		},
+		{
+			name:   "cancel",
+			exec:   func() *MatcherResult { return n.Cancel(cancel(t, 3, trader)) },
+			orders: []int64{1},
+		},
+		{
+			name: "fill",
+			exec: func() *MatcherResult {
+				return n.PlaceGTC(order.NewPlace(11, maker, 98, 6, 98, 0, 0, order.Ask, order.GTC))
+			},
+		},
+	}
+
+	for _, step := range steps {
+		if res := step.exec(); res.Code != resultcode.Success {
+			t.Fatalf("%v: %v", step.name, res.Code)
+		}
+
+		orders := n.UserOrders(trader)
+
+		if len(orders) != len(step.orders) {
+			t.Fatalf("%v: %v orders, want %v", step.name, len(orders), len(step.orders))
+		}
+
+		for i, ord := range orders {
+			if ord.ID() != step.orders[i] {
+				t.Errorf("%v: order %v id %v, want %v", step.name, i, ord.ID(), step.orders[i])
+			}
+		}
+
+		if len(n.UserOrders(maker)) != 0 {
+			t.Errorf("%v: filled orders of the maker indexed", step.name)
+		}
+
+		indexed := 0
+
+		for userID, userOrders := range n.userOrders {
+			if len(userOrders) == 0 {
+				t.Errorf("%v: empty index of user %v", step.name, userID)
+			}
+
+			for orderID, ord := range userOrders {
+				if n.orders[orderID] != ord {
+					t.Errorf("%v: order %v indexed but not in the book", step.name, orderID)
+				}
+			}
+
+			indexed += len(userOrders)
+		}
+
+		if indexed != len(n.orders) {
+			t.Errorf("%v: %v indexed, %v in the book", step.name, indexed, len(n.orders))
+		}
+	}
+}
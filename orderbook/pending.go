@@ -0,0 +1,107 @@
+package orderbook
+
+import (
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+)
+
+/*
+ * Resting quantity of the orders of a user added to and removed from the book
+ * since the last `PendingChanges`, used to hold and release the margin
+ * of pending orders (see `riskengine.Hold` and `riskengine.Release`).
+ * Orders held by groups are not resting.
+ */
+type PendingChange struct {
+	userID int64
+
+	// indexed by `side`
+	added   [2]pendingQuantity
+	removed [2]pendingQuantity
+	_       struct{}
+}
+
+type pendingQuantity struct {
+	quantity int64
+	amount   int64 // sum of price * quantity
+}
+
+func side(action order.Action) int {
+	if action == order.Ask {
+		return 0
+	}
+
+	return 1
+}
+
+func (c *PendingChange) UserID() int64 {
+	return c.userID
+}
+
+func (c *PendingChange) Added(action order.Action) int64 {
+	return c.added[side(action)].quantity
+}
+
+func (c *PendingChange) AddedAmount(action order.Action) int64 {
+	return c.added[side(action)].amount
+}
+
+func (c *PendingChange) Removed(action order.Action) int64 {
+	return c.removed[side(action)].quantity
+}
+
+func (c *PendingChange) RemovedAmount(action order.Action) int64 {
+	return c.removed[side(action)].amount
+}
+
+func (n *Naive) pendingOf(userID int64) *PendingChange {
+	c, ok := n.pending[userID]
+
+	if !ok {
+		c = &PendingChange{
+			userID: userID,
+		}
+		n.pending[userID] = c
+	}
+
+	return c
+}
+
+func (n *Naive) addPending(
+	userID int64,
+	action order.Action,
+	price int64,
+	quantity int64,
+) {
+	q := &n.pendingOf(userID).added[side(action)]
+	q.quantity += quantity
+	q.amount += price * quantity
+}
+
+func (n *Naive) removePending(
+	userID int64,
+	action order.Action,
+	price int64,
+	quantity int64,
+) {
+	q := &n.pendingOf(userID).removed[side(action)]
+	q.quantity += quantity
+	q.amount += price * quantity
+}
+
+// Changes since the last call, sorted by user id.
+func (n *Naive) PendingChanges() []*PendingChange {
+	changes := make([]*PendingChange, 0, len(n.pending))
+
+	for _, c := range n.pending {
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].userID < changes[j].userID
+	})
+
+	n.pending = make(map[int64]*PendingChange)
+
+	return changes
+}
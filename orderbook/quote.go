@@ -0,0 +1,188 @@
+package orderbook
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// Checks a quote level before any level of the mass quote is applied.
+func (n *Naive) ValidateQuote(
+	place *order.Place,
+) resultcode.ResultCode {
+	if place.Price() <= 0 || place.Quantity() <= 0 {
+		return resultcode.MatchingInvalidQuote
+	}
+
+	if _, ok := n.orders[place.OrderID()]; ok {
+		return resultcode.MatchingDuplicateOrderId
+	}
+
+	if _, ok := n.orderGroups[place.OrderID()]; ok {
+		return resultcode.MatchingDuplicateOrderId
+	}
+
+	return resultcode.Success
+}
+
+// Bids of a ladder must be below its asks, otherwise the levels would trade against each other.
+func ValidateLadder(
+	places []*order.Place,
+) resultcode.ResultCode {
+	var (
+		bestBid int64 = math.MinInt64
+		bestAsk int64 = math.MaxInt64
+	)
+
+	for _, place := range places {
+		if place.Action() == order.Bid && place.Price() > bestBid {
+			bestBid = place.Price()
+		}
+
+		if place.Action() == order.Ask && place.Price() < bestAsk {
+			bestAsk = place.Price()
+		}
+	}
+
+	if bestBid >= bestAsk {
+		return resultcode.MatchingCrossedQuote
+	}
+
+	return resultcode.Success
+}
+
+/*
+ * Cancels the quotes of the user left from the previous mass quote
+ * and places the new levels as GTC orders.
+ * Levels must be validated (`ValidateQuote`), a crossed ladder is rejected as a whole.
+ * `Codes` of the result holds one ack per level.
+ */
+func (n *Naive) ReplaceQuotes(
+	userID int64,
+	places []*order.Place,
+) *MatcherResult {
+	if code := ValidateLadder(places); code != resultcode.Success {
+		codes := make([]resultcode.ResultCode, len(places))
+
+		for i := range codes {
+			codes[i] = code
+		}
+
+		return &MatcherResult{
+			Code:  code,
+			Codes: codes,
+		}
+	}
+
+	res := &MatcherResult{
+		Code:  resultcode.Success,
+		Codes: make([]resultcode.ResultCode, 0, len(places)),
+	}
+
+	for _, orderID := range n.quotes[userID] {
+		if ord, ok := n.orders[orderID]; ok {
+			res.Append(n.reduce(ord, ord.Remained()).Head)
+		}
+	}
+
+	orderIDs := make([]int64, 0, len(places))
+
+	for _, place := range places {
+		placeResult := n.placeGTC(place)
+		n.settleGroups(place.OrderID(), placeResult)
+		res.Append(placeResult.Head)
+		res.Codes = append(res.Codes, resultcode.Success)
+
+		if _, ok := n.orders[place.OrderID()]; ok {
+			orderIDs = append(orderIDs, place.OrderID())
+		}
+	}
+
+	if len(orderIDs) == 0 {
+		delete(n.quotes, userID)
+	} else {
+		n.quotes[userID] = orderIDs
+	}
+
+	return res
+}
+
+func (n *Naive) marshalQuotes(out *bytes.Buffer) error {
+	userIDs := make([]int64, 0, len(n.quotes))
+
+	for userID := range n.quotes {
+		userIDs = append(userIDs, userID)
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(userIDs)), out); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		orderIDs := n.quotes[userID]
+
+		if err := serialization.WriteInt64(userID, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(int32(len(orderIDs)), out); err != nil {
+			return err
+		}
+
+		for _, orderID := range orderIDs {
+			if err := serialization.WriteInt64(orderID, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func unmarshalQuotes(in *bytes.Buffer) (map[int64][]int64, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[int64][]int64, size)
+
+	for ; size > 0; size-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		numOrders, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		orderIDs := make([]int64, 0, numOrders)
+
+		for ; numOrders > 0; numOrders-- {
+			orderID, err := serialization.ReadInt64(in)
+
+			if err != nil {
+				return nil, err
+			}
+
+			orderIDs = append(orderIDs, orderID)
+		}
+
+		quotes[userID] = orderIDs
+	}
+
+	return quotes, nil
+}
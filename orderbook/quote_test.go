@@ -0,0 +1,77 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+func quoteLevel(
+	orderID int64,
+	action order.Action,
+	price int64,
+) *order.Place {
+	return order.NewPlace(orderID, trader, price, 10, price, 0, 0, action, order.GTC)
+}
+
+func TestReplaceQuotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		levels  []*order.Place
+		code    resultcode.ResultCode
+		resting []int64 // orders resting after the replace, the previous quote is order 1
+	}{
+		{
+			name:    "one side",
+			levels:  []*order.Place{quoteLevel(2, order.Bid, 100), quoteLevel(3, order.Bid, 99)},
+			code:    resultcode.Success,
+			resting: []int64{2, 3},
+		},
+		{
+			name:    "bids below asks",
+			levels:  []*order.Place{quoteLevel(2, order.Bid, 100), quoteLevel(3, order.Ask, 101)},
+			code:    resultcode.Success,
+			resting: []int64{2, 3},
+		},
+		{
+			name:    "locked",
+			levels:  []*order.Place{quoteLevel(2, order.Bid, 100), quoteLevel(3, order.Ask, 100)},
+			code:    resultcode.MatchingCrossedQuote,
+			resting: []int64{1},
+		},
+		{
+			name:    "crossed by a deeper level",
+			levels:  []*order.Place{quoteLevel(2, order.Bid, 100), quoteLevel(3, order.Ask, 105), quoteLevel(4, order.Ask, 99)},
+			code:    resultcode.MatchingCrossedQuote,
+			resting: []int64{1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := NewNaive(&symbol.Symbol{})
+			n.ReplaceQuotes(trader, []*order.Place{quoteLevel(1, order.Bid, 90)})
+			res := n.ReplaceQuotes(trader, test.levels)
+
+			if res.Code != test.code {
+				t.Fatalf("code %v, want %v", res.Code, test.code)
+			}
+
+			if len(res.Codes) != len(test.levels) {
+				t.Errorf("%v codes, want %v", len(res.Codes), len(test.levels))
+			}
+
+			if len(n.orders) != len(test.resting) {
+				t.Errorf("%v orders resting, want %v", len(n.orders), len(test.resting))
+			}
+
+			for _, orderID := range test.resting {
+				if _, ok := n.orders[orderID]; !ok {
+					t.Errorf("order %v not resting", orderID)
+				}
+			}
+		})
+	}
+}
@@ -46,6 +46,28 @@ func (m *Margin) SetUserID(id int64) {
 	m.userID = id
 }
 
+func (m *Margin) UserID() int64 {
+	return m.userID
+}
+
+func (m *Margin) SymbolID() int32 {
+	return m.symbolID
+}
+
+func (m *Margin) Currency() int32 {
+	return m.currency
+}
+
+// Open quantity, positive for long and negative for short positions.
+func (m *Margin) SignedQuantity() int64 {
+	return m.openQuantity * int64(m.direction)
+}
+
+// Realized profit.
+func (m *Margin) Profit() int64 {
+	return m.profit
+}
+
 // Check if position is empty (no pending orders, no open trades) - can remove it from hashmap
 func (m *Margin) IsEmpty() bool {
 	return m.direction == _empty &&
@@ -73,7 +95,32 @@ func (m *Margin) PendingRelease(action order.Action, quantity int64) {
 	// TODO check for negative values
 }
 
-// TODO relation of `symbol_` and `symbolID`
+// Quantity of the resting orders of the side.
+func (m *Margin) Pending(action order.Action) int64 {
+	if action == order.Ask {
+		return m.pendingSellQuantity
+	}
+
+	return m.pendingBuyQuantity
+}
+
+// Holds the resting orders of a command in bulk, e.g. the levels of a mass quote.
+func (m *Margin) PendingHoldAll(hold *riskengine.Hold) {
+	m.PendingHold(order.Ask, hold.AskQuantity())
+	m.PendingHold(order.Bid, hold.BidQuantity())
+}
+
+// Releases pending holds of cancelled or filled orders in bulk.
+func (m *Margin) PendingReleaseAll(release *riskengine.Release) {
+	m.PendingRelease(order.Ask, release.AskQuantity())
+	m.PendingRelease(order.Bid, release.BidQuantity())
+}
+
+/*
+ * `rec` is the mark price of the symbol (see `riskengine.MarkPrice`),
+ * best prices of a thin book can be manipulated.
+ * TODO relation of `symbol_` and `symbolID`
+ */
 func (m *Margin) EstimateProfit(
 	symbol_ symbol.FutureContract,
 	rec riskengine.LastPriceCacheRecord, // TODO rename
@@ -114,6 +161,44 @@ func (m *Margin) EstimateProfit(
 	}
 }
 
+// Equity (`balance` plus unrealized profit at the mark price `rec`) is below the required margin.
+func (m *Margin) IsLiquidatable(
+	symbol_ symbol.FutureContract,
+	rec riskengine.LastPriceCacheRecord,
+	balance int64,
+) bool {
+	if m.direction == _empty {
+		return false
+	}
+
+	return balance+m.EstimateProfit(symbol_, rec) < m.CalculateRequiredMarginForFutures(symbol_)
+}
+
+/*
+ * Price at which equity (`balance` plus profit) of the position is zero,
+ * rounded against the position so that the loss is not understated.
+ * At least 1, 0 if the position is empty.
+ */
+func (m *Margin) BankruptcyPrice(balance int64) int64 {
+	var price int64
+	equity := balance + m.profit
+
+	switch m.direction {
+	case _long:
+		price = (m.openPriceSum - equity + m.openQuantity - 1) / m.openQuantity
+	case _short:
+		price = (m.openPriceSum + equity) / m.openQuantity
+	default:
+		return 0
+	}
+
+	if price < 1 {
+		return 1
+	}
+
+	return price
+}
+
 // TODO rename
 func (m *Margin) marginBuymarginSell(
 	symbol_ symbol.FutureContract,
@@ -189,6 +274,25 @@ func (m *Margin) UpdateForMarginTrade(
 	return quantityToOpen, nil
 }
 
+// Applies a trade not held by `PendingHold` (e.g. positions updated from trades of the matching engine).
+func (m *Margin) ApplyTrade(
+	action order.Action,
+	quantity int64,
+	price int64,
+) error {
+	quantityToOpen, err := m.CloseCurrentPositionFutures(action, quantity, price)
+
+	if err != nil {
+		return err
+	}
+
+	if quantityToOpen > 0 {
+		return m.OpenPositionMargin(action, quantityToOpen, price)
+	}
+
+	return nil
+}
+
 func (m *Margin) CloseCurrentPositionFutures(
 	action order.Action,
 	tradeQuantity int64,
@@ -259,7 +363,7 @@ func (m *Margin) ValidateInternalState() error {
 		)
 	}
 
-	if m.direction == _empty && (m.openQuantity <= 0 || m.openPriceSum <= 0) {
+	if m.direction != _empty && (m.openQuantity <= 0 || m.openPriceSum <= 0) {
 		const msg = "margin: userId %v, position %v, totalQuantity %v, openPriceSum %v"
 
 		return fmt.Errorf(
@@ -0,0 +1,1569 @@
+package matchingengine
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/bus"
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/dedup"
+	"github.com/xerexchain/matching-engine/marketdata"
+	"github.com/xerexchain/matching-engine/mmp"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/session"
+	"github.com/xerexchain/matching-engine/spread"
+	"github.com/xerexchain/matching-engine/stats"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+// TODO sharding by `cfg.Performance.NumMatchingEngines`
+// TODO OrderBookRequest, PersistStateMatching
+// FIX race condition, concurrency
+
+// Status and roles of users, see `userengine.Engine`.
+type Users interface {
+	IsActive(userID int64) bool // registered and not suspended
+	IsAdmin(userID int64) bool
+	Liquidation(userID int64, symbolID int32, orderID int64) (*cmd.Liquidate, bool)
+}
+
+// Routes commands to the orderbook of their symbol.
+type Router struct {
+	books     map[int32]*orderbook.Naive
+	sessions  *session.Registry
+	mmp       *mmp.Registry
+	spreads   *spread.Engine
+	clientIDs *dedup.Window
+	bus       *bus.Bus // nil if results are not published
+	feed      *marketdata.Feed
+	stats     *stats.Stats
+	marks     *riskengine.Marks
+	users     Users // nil if users are not checked, not serialized
+
+	// symbolID -> trades of the last command, not serialized
+	lastTrades map[int32][]*event.Trade
+
+	// risk holds of orders added to and removed from the books
+	// by the last command, by symbol and user, not serialized
+	lastHolds    []*riskengine.Hold
+	lastReleases []*riskengine.Release
+
+	// books delisted by the last command, published once more, not serialized
+	delisted []*orderbook.Naive
+	_        struct{}
+}
+
+func NewRouter() *Router {
+	r := &Router{
+		books:     make(map[int32]*orderbook.Naive),
+		sessions:  session.NewRegistry(),
+		mmp:       mmp.NewRegistry(),
+		clientIDs: dedup.NewWindow(dedup.DefaultSize),
+		feed:      marketdata.NewFeed(marketdata.DefaultSnapshotInterval),
+		stats:     newStats(),
+		marks:     riskengine.NewMarks(riskengine.DefaultMaxPremiumBP, riskengine.DefaultEMAPeriods),
+	}
+	r.spreads = spread.NewEngine(r, r.protect)
+
+	return r
+}
+
+func (r *Router) AddBook(
+	book *orderbook.Naive,
+) resultcode.ResultCode {
+	symbolID := book.Symbol().ID()
+
+	if _, ok := r.books[symbolID]; ok {
+		return resultcode.MatchingOrderBookAlreadyExists
+	}
+
+	if spread_, ok := book.Symbol().(*symbol.Spread); ok {
+		if code := r.spreads.Validate(spread_); code != resultcode.Success {
+			return code
+		}
+	}
+
+	r.books[symbolID] = book
+
+	return resultcode.Success
+}
+
+// Results of processed commands are published to `b`.
+func (r *Router) SetBus(b *bus.Bus) {
+	r.bus = b
+}
+
+// Orders of unknown and suspended users are rejected, liquidations are checked against the positions of users.
+func (r *Router) SetUsers(u Users) {
+	r.users = u
+}
+
+// Replaces the default market statistics, e.g. to configure candle intervals.
+func (r *Router) SetStats(s *stats.Stats) {
+	r.stats = s
+}
+
+// Replaces the default mark prices, e.g. to configure the premium bound.
+func (r *Router) SetMarks(m *riskengine.Marks) {
+	r.marks = m
+}
+
+func newStats() *stats.Stats {
+	s, err := stats.New(
+		stats.DefaultTapeSize,
+		stats.DefaultCandlesSize,
+		stats.DefaultIntervals,
+	)
+
+	// default intervals are valid
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func (r *Router) Book(symbolID int32) (*orderbook.Naive, bool) {
+	book, ok := r.books[symbolID]
+
+	return book, ok
+}
+
+// Orders of the user in any book, e.g. orders of exchange pairs not held by margin positions.
+func (r *Router) HasOrders(userID int64) bool {
+	for _, book := range r.books {
+		if book.HasOrders(userID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sorted for deterministic processing
+func (r *Router) symbolIDs() []int32 {
+	ids := make([]int32, 0, len(r.books))
+
+	for id := range r.books {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+/*
+ * Cancel-on-disconnect: orders of sessions timed out
+ * by the command timestamp are cancelled before the command,
+ * their events are prepended to the result.
+ * The result and market data updates of changed books are published to the bus, if set.
+ */
+func (r *Router) Process(
+	command cmd.Command,
+) *orderbook.MatcherResult {
+	res := r.expireSessions(command.TimestampNS())
+	commandResult := r.process(command)
+	res.Append(commandResult.Head)
+	res.Code = commandResult.Code
+	res.Codes = commandResult.Codes
+
+	// taken even if not published, seqs must not depend on subscribers
+	updates, snapshots, l3Updates := r.publishMarketData(command.TimestampNS())
+	r.recordStats(command.TimestampNS())
+
+	if r.bus != nil {
+		envelope := bus.NewEnvelope(command, res)
+		envelope.SetL2(updates, snapshots)
+		envelope.SetL3(l3Updates)
+
+		if err := r.bus.Publish(envelope); err != nil {
+			log.Printf("publish: %v", err)
+		}
+	}
+
+	return res
+}
+
+func (r *Router) publishMarketData(
+	timestampNS int64,
+) ([]*marketdata.Update, []*marketdata.Snapshot, []*marketdata.L3Update) {
+	var (
+		updates   []*marketdata.Update
+		snapshots []*marketdata.Snapshot
+		l3Updates []*marketdata.L3Update
+	)
+
+	books := make([]*orderbook.Naive, 0, len(r.books)+len(r.delisted))
+
+	for _, symbolID := range r.symbolIDs() {
+		books = append(books, r.books[symbolID])
+	}
+
+	books = append(books, r.delisted...)
+
+	for _, book := range books {
+		update, snapshot := r.feed.Publish(book, timestampNS)
+
+		if update != nil {
+			updates = append(updates, update)
+		}
+
+		if snapshot != nil {
+			snapshots = append(snapshots, snapshot)
+		}
+
+		if l3Update := r.feed.PublishL3(book, timestampNS); l3Update != nil {
+			l3Updates = append(l3Updates, l3Update)
+		}
+	}
+
+	return updates, snapshots, l3Updates
+}
+
+func (r *Router) recordStats(timestampNS int64) {
+	r.lastTrades = make(map[int32][]*event.Trade)
+	r.lastHolds = nil
+	r.lastReleases = nil
+
+	for _, symbolID := range r.symbolIDs() {
+		trades := r.books[symbolID].Trades()
+		r.stats.Record(symbolID, timestampNS, trades)
+
+		if len(trades) > 0 {
+			r.lastTrades[symbolID] = trades
+		}
+
+		for _, change := range r.books[symbolID].PendingChanges() {
+			if hold := riskengine.NewHold(symbolID, change); !hold.IsEmpty() {
+				r.lastHolds = append(r.lastHolds, hold)
+			}
+
+			if release := riskengine.NewRelease(symbolID, change); !release.IsEmpty() {
+				r.lastReleases = append(r.lastReleases, release)
+			}
+		}
+	}
+
+	r.delisted = nil
+}
+
+// Trades of the last command by symbol, e.g. to update positions.
+func (r *Router) LastTrades() map[int32][]*event.Trade {
+	return r.lastTrades
+}
+
+/*
+ * Holds of the orders which started resting in the books by the last command,
+ * by symbol and user. Holds of delisted books are not reported.
+ */
+func (r *Router) LastHolds() []*riskengine.Hold {
+	return r.lastHolds
+}
+
+// Holds freed by the orders which left the books (or were reduced) by the last command.
+func (r *Router) LastReleases() []*riskengine.Release {
+	return r.lastReleases
+}
+
+// Trade tape, candles or 24h ticker of a symbol.
+func (r *Router) Query(q *stats.Query) *stats.Report {
+	if _, ok := r.books[q.SymbolID()]; !ok {
+		return stats.NewReport(resultcode.MatchingInvalidOrderBookId)
+	}
+
+	return r.stats.Query(q)
+}
+
+// Mark price for unrealized profit and liquidation of positions.
+func (r *Router) MarkPrice(symbolID int32) (*riskengine.MarkPrice, bool) {
+	return r.marks.Get(symbolID)
+}
+
+func (r *Router) setIndexPrice(
+	command *cmd.SetIndexPrice,
+) *orderbook.MatcherResult {
+	book, ok := r.books[command.SymbolID]
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderBookId,
+		}
+	}
+
+	if command.Price <= 0 {
+		return &orderbook.MatcherResult{
+			Code: resultcode.RiskInvalidIndexPrice,
+		}
+	}
+
+	var mid int64
+	askPrice, _, askOK := book.Best(order.Ask)
+	bidPrice, _, bidOK := book.Best(order.Bid)
+
+	if askOK && bidOK {
+		mid = (askPrice + bidPrice) / 2
+	}
+
+	r.marks.Update(command.SymbolID, command.Price, mid, command.TimestampNS())
+
+	return &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+}
+
+// Full L2 book at the last update, for recovery of feed clients.
+func (r *Router) L2Snapshot(
+	symbolID int32,
+	timestampNS int64,
+) (*marketdata.Snapshot, bool) {
+	book, ok := r.books[symbolID]
+
+	if !ok {
+		return nil, false
+	}
+
+	return r.feed.Snapshot(book, timestampNS), true
+}
+
+// Resting orders in priority order at the last L3 update, for recovery of feed clients.
+func (r *Router) L3Snapshot(
+	symbolID int32,
+	timestampNS int64,
+) (*marketdata.L3Snapshot, bool) {
+	book, ok := r.books[symbolID]
+
+	if !ok {
+		return nil, false
+	}
+
+	return r.feed.L3Snapshot(book, timestampNS), true
+}
+
+func (r *Router) process(
+	command cmd.Command,
+) *orderbook.MatcherResult {
+	switch c := command.(type) {
+	case *order.Place:
+		if !r.isActive(c.UserID()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthInvalidUser,
+			}
+		}
+
+		if code := r.checkSession(c.UserID(), c.SessionID()); code != resultcode.Success {
+			return &orderbook.MatcherResult{
+				Code: code,
+			}
+		}
+
+		if r.mmp.IsFrozen(c.UserID(), c.SymbolID(), c.TimestampNS()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.MatchingMMPFrozen,
+			}
+		}
+
+		return r.withBook(c.SymbolID(), func(book *orderbook.Naive) *orderbook.MatcherResult {
+			if r.isTradingClosed(book, c.TimestampNS()) {
+				return &orderbook.MatcherResult{
+					Code: resultcode.MatchingTradingClosed,
+				}
+			}
+
+			if code := r.checkClientOrderID(book, c); code != resultcode.Success {
+				return &orderbook.MatcherResult{
+					Code: code,
+				}
+			}
+
+			var res *orderbook.MatcherResult
+
+			// settled by the spread engine
+			if isSpread(book) {
+				res = r.spreads.Place(book, c)
+			} else {
+				res = r.placeOutright(book, c)
+			}
+
+			if res.Code == resultcode.Success || res.Code == resultcode.Accepted {
+				r.recordClientOrderID(c)
+			}
+
+			return res
+		})
+	case *order.Cancel:
+		r.authorize(c)
+
+		return r.withBook(c.SymbolID(), func(book *orderbook.Naive) *orderbook.MatcherResult {
+			return book.Cancel(c)
+		})
+	case *order.Move:
+		r.authorize(c)
+
+		if !r.isActive(c.UserID()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthInvalidUser,
+			}
+		}
+
+		if code := r.checkSession(c.UserID(), 0); code != resultcode.Success {
+			return &orderbook.MatcherResult{
+				Code: code,
+			}
+		}
+
+		if r.mmp.IsFrozen(c.UserID(), c.SymbolID(), c.TimestampNS()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.MatchingMMPFrozen,
+			}
+		}
+
+		res := r.withBook(c.SymbolID(), func(book *orderbook.Naive) *orderbook.MatcherResult {
+			if r.isTradingClosed(book, c.TimestampNS()) {
+				return &orderbook.MatcherResult{
+					Code: resultcode.MatchingTradingClosed,
+				}
+			}
+
+			return book.Move(c)
+		})
+
+		return r.protect(c.SymbolID(), c.TimestampNS(), res)
+	case *order.Reduce:
+		r.authorize(c)
+
+		return r.withBook(c.SymbolID(), func(book *orderbook.Naive) *orderbook.MatcherResult {
+			return book.Reduce(c)
+		})
+	case *order.Amend:
+		r.authorize(c)
+
+		if !r.isActive(c.UserID()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthInvalidUser,
+			}
+		}
+
+		if code := r.checkSession(c.UserID(), 0); code != resultcode.Success {
+			return &orderbook.MatcherResult{
+				Code: code,
+			}
+		}
+
+		if r.mmp.IsFrozen(c.UserID(), c.SymbolID(), c.TimestampNS()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.MatchingMMPFrozen,
+			}
+		}
+
+		res := r.withBook(c.SymbolID(), func(book *orderbook.Naive) *orderbook.MatcherResult {
+			if r.isTradingClosed(book, c.TimestampNS()) {
+				return &orderbook.MatcherResult{
+					Code: resultcode.MatchingTradingClosed,
+				}
+			}
+
+			if code := r.checkAmendClientOrderID(book, c); code != resultcode.Success {
+				return &orderbook.MatcherResult{
+					Code: code,
+				}
+			}
+
+			res := book.Amend(c)
+
+			if res.Code == resultcode.Success && c.ClientOrderID() != 0 {
+				r.clientIDs.Add(c.UserID(), c.ClientOrderID())
+			}
+
+			return res
+		})
+
+		return r.protect(c.SymbolID(), c.TimestampNS(), res)
+	case *order.MassCancel:
+		return r.massCancel(c)
+	case *order.MassQuote:
+		if !r.isActive(c.UserID()) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthInvalidUser,
+			}
+		}
+
+		if code := r.checkSession(c.UserID(), c.SessionID()); code != resultcode.Success {
+			return &orderbook.MatcherResult{
+				Code: code,
+			}
+		}
+
+		return r.massQuote(c)
+	case *cmd.Batch:
+		return r.batch(c)
+	case *cmd.AddSymbols:
+		return r.addSymbols(c)
+	case *cmd.Heartbeat:
+		return &orderbook.MatcherResult{
+			Code: r.sessions.Heartbeat(
+				c.UserId,
+				c.SessionID,
+				c.TimeoutNS,
+				c.TimestampNS(),
+			),
+		}
+	case *cmd.KillSwitch:
+		s, code := r.sessions.Kill(c.UserId, c.SessionID)
+
+		if code != resultcode.Success {
+			return &orderbook.MatcherResult{
+				Code: code,
+			}
+		}
+
+		return r.cancelSession(s)
+	case *cmd.ResetKillSwitch:
+		if !r.isAdmin(c.AdminId) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthNotAdmin,
+			}
+		}
+
+		r.sessions.ResetKill(c.UserId)
+
+		return &orderbook.MatcherResult{
+			Code: resultcode.Success,
+		}
+	case *cmd.SetMMP:
+		return r.setMMP(c)
+	case *cmd.ResetMMP:
+		if !r.isAdmin(c.AdminId) {
+			return &orderbook.MatcherResult{
+				Code: resultcode.AuthNotAdmin,
+			}
+		}
+
+		code := resultcode.Success
+
+		if !r.mmp.Reset(c.UserId, c.GroupID) {
+			code = resultcode.MatchingInvalidMMPGroup
+		}
+
+		return &orderbook.MatcherResult{
+			Code: code,
+		}
+	case *cmd.SetIndexPrice:
+		return r.setIndexPrice(c)
+	case *cmd.Settle:
+		return r.settle(c)
+	case *cmd.Liquidate:
+		return r.liquidate(c)
+	case *cmd.Reset:
+		r.books = make(map[int32]*orderbook.Naive)
+		r.sessions = session.NewRegistry()
+		r.mmp = mmp.NewRegistry()
+		r.clientIDs = dedup.NewWindow(dedup.DefaultSize)
+		r.feed = marketdata.NewFeed(marketdata.DefaultSnapshotInterval)
+		r.stats.Reset()
+		r.marks.Reset()
+		r.lastTrades = make(map[int32][]*event.Trade)
+		r.lastHolds = nil
+		r.lastReleases = nil
+		r.delisted = nil
+
+		return &orderbook.MatcherResult{
+			Code: resultcode.Success,
+		}
+	case *cmd.BalanceAdj, *cmd.AddUser, *cmd.SetAdmin, *cmd.SuspendUser, *cmd.ResumeUser,
+		*cmd.AddSubAccount, *cmd.Transfer, *cmd.Funding:
+		// processed by the user engine, see `Users`
+		return &orderbook.MatcherResult{
+			Code: resultcode.Success,
+		}
+	default:
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingUnsupportedCommand,
+		}
+	}
+}
+
+// true if users are not checked
+func (r *Router) isActive(userID int64) bool {
+	return r.users == nil || r.users.IsActive(userID)
+}
+
+// false if users are not checked
+func (r *Router) isAdmin(userID int64) bool {
+	return r.users != nil && r.users.IsAdmin(userID)
+}
+
+type ownedCommand interface {
+	UserID() int64
+	ServiceFlags() int32
+	SetServiceFlags(flags int32)
+}
+
+/*
+ * `order.OwnershipOverride` is granted by the admin role of the user,
+ * the flag sent by clients is ignored.
+ */
+func (r *Router) authorize(command ownedCommand) {
+	flags := command.ServiceFlags() &^ order.OwnershipOverride
+
+	if r.isAdmin(command.UserID()) {
+		flags |= order.OwnershipOverride
+	}
+
+	command.SetServiceFlags(flags)
+}
+
+func (r *Router) withBook(
+	symbolID int32,
+	f func(*orderbook.Naive) *orderbook.MatcherResult,
+) *orderbook.MatcherResult {
+	book, ok := r.books[symbolID]
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderBookId,
+		}
+	}
+
+	return f(book)
+}
+
+func (r *Router) checkSession(
+	userID int64,
+	sessionID int64,
+) resultcode.ResultCode {
+	if r.sessions.IsKilled(userID) {
+		return resultcode.MatchingKillSwitchActive
+	}
+
+	if sessionID != 0 && !r.sessions.IsOpen(userID, sessionID) {
+		return resultcode.MatchingInvalidSession
+	}
+
+	return resultcode.Success
+}
+
+// Cancels orders of the session across all symbols.
+func (r *Router) cancelSession(
+	s *session.Session,
+) *orderbook.MatcherResult {
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, symbolID := range r.symbolIDs() {
+		book := r.books[symbolID]
+		res.Append(book.CancelSession(s.UserID(), s.ID()).Head)
+	}
+
+	return res
+}
+
+func (r *Router) expireSessions(
+	timestampNS int64,
+) *orderbook.MatcherResult {
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, s := range r.sessions.Expire(timestampNS) {
+		res.Append(r.cancelSession(s).Head)
+	}
+
+	return res
+}
+
+func (r *Router) setMMP(
+	command *cmd.SetMMP,
+) *orderbook.MatcherResult {
+	if len(command.SymbolIDs) == 0 || command.WindowNS <= 0 || command.CooldownNS < 0 {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidMMPGroup,
+		}
+	}
+
+	for _, symbolID := range command.SymbolIDs {
+		if _, ok := r.books[symbolID]; !ok {
+			return &orderbook.MatcherResult{
+				Code: resultcode.MatchingInvalidOrderBookId,
+			}
+		}
+	}
+
+	r.mmp.Set(
+		command.UserId,
+		command.GroupID,
+		command.SymbolIDs,
+		mmp.NewConfig(
+			command.WindowNS,
+			command.MaxQuantity,
+			command.MaxDelta,
+			command.MaxTrades,
+			command.CooldownNS,
+		),
+	)
+
+	return &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+}
+
+/*
+ * Feeds maker fills of the result to market maker protection.
+ * When the protection of a maker trips, its resting orders in the group
+ * are cancelled, the cancel events are appended to the result.
+ */
+func (r *Router) protect(
+	symbolID int32,
+	timestampNS int64,
+	res *orderbook.MatcherResult,
+) *orderbook.MatcherResult {
+	var tripped []*order.MassCancel
+
+	for e := res.Head; e != nil; e = e.Next() {
+		trade, ok := e.(*event.Trade)
+
+		if !ok {
+			continue
+		}
+
+		symbolIDs, ok := r.mmp.Record(
+			trade.MakerUserID(),
+			symbolID,
+			trade.MakerAction(),
+			trade.Quantity(),
+			timestampNS,
+		)
+
+		if !ok {
+			continue
+		}
+
+		for _, id := range symbolIDs {
+			tripped = append(tripped, order.NewMassCancel(trade.MakerUserID(), id, 0))
+		}
+	}
+
+	for _, command := range tripped {
+		if book, ok := r.books[command.SymbolID()]; ok {
+			res.Append(book.MassCancel(command).Head)
+		}
+	}
+
+	return res
+}
+
+/*
+ * New orders of a future are rejected from its last trading time,
+ * orders of a spread when any of its legs is closed.
+ * Cancels and reduces are accepted until final settlement.
+ */
+func (r *Router) isTradingClosed(
+	book *orderbook.Naive,
+	timestampNS int64,
+) bool {
+	switch symbol_ := book.Symbol().(type) {
+	case *symbol.FutureContract:
+		return symbol_.IsTradingClosed(timestampNS)
+	case *symbol.Spread:
+		for _, leg := range symbol_.Legs() {
+			if legBook, ok := r.books[leg.SymbolID()]; ok && r.isTradingClosed(legBook, timestampNS) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+ * Cancels all resting orders of an expired future and delists it,
+ * along with spreads having it as a leg.
+ * Positions are closed at the settlement price by the user engine.
+ */
+func (r *Router) settle(
+	command *cmd.Settle,
+) *orderbook.MatcherResult {
+	book, ok := r.books[command.SymbolID]
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderBookId,
+		}
+	}
+
+	future, ok := book.Symbol().(*symbol.FutureContract)
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.UnsupportedSymbolType,
+		}
+	}
+
+	if !future.IsExpired(command.TimestampNS()) {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingSymbolNotExpired,
+		}
+	}
+
+	if command.Price <= 0 {
+		return &orderbook.MatcherResult{
+			Code: resultcode.RiskInvalidSettlementPrice,
+		}
+	}
+
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, symbolID := range r.symbolIDs() {
+		spread_, ok := r.books[symbolID].Symbol().(*symbol.Spread)
+
+		if !ok {
+			continue
+		}
+
+		for _, leg := range spread_.Legs() {
+			if leg.SymbolID() == command.SymbolID {
+				res.Append(r.delist(symbolID).Head)
+
+				break
+			}
+		}
+	}
+
+	res.Append(r.delist(command.SymbolID).Head)
+
+	return res
+}
+
+/*
+ * Liquidation orders bypass sessions, market maker protection
+ * and the trading cutoff, positions must be reducible until settlement.
+ * The position must be liquidatable at the current mark price,
+ * the side and the bankruptcy price are recomputed and
+ * the close is capped at the position, see `Users.Liquidation`.
+ */
+func (r *Router) liquidate(
+	command *cmd.Liquidate,
+) *orderbook.MatcherResult {
+	book, ok := r.books[command.SymbolID]
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderBookId,
+		}
+	}
+
+	switch book.Symbol().(type) {
+	case *symbol.FutureContract, *symbol.PerpetualSwap:
+	default:
+		return &orderbook.MatcherResult{
+			Code: resultcode.UnsupportedSymbolType,
+		}
+	}
+
+	if command.Quantity <= 0 || command.Price <= 0 {
+		return &orderbook.MatcherResult{
+			Code: resultcode.RiskInvalidLiquidation,
+		}
+	}
+
+	if r.users != nil {
+		liquidation, ok := r.users.Liquidation(command.UserId, command.SymbolID, command.OrderID)
+
+		if !ok {
+			return &orderbook.MatcherResult{
+				Code: resultcode.RiskInvalidLiquidation,
+			}
+		}
+
+		command.Action = liquidation.Action
+		command.Price = liquidation.Price
+
+		if command.Quantity > liquidation.Quantity {
+			command.Quantity = liquidation.Quantity
+		}
+	}
+
+	res := book.MassCancel(order.NewMassCancel(command.UserId, command.SymbolID, 0))
+	res.Code = resultcode.Success
+
+	place := order.NewPlace(
+		command.OrderID,
+		command.UserId,
+		command.Price,
+		command.Quantity,
+		command.Price,
+		command.SymbolID,
+		command.TimestampNS(),
+		command.Action,
+		order.IOC,
+	)
+	res.Append(r.protect(command.SymbolID, command.TimestampNS(), book.PlaceIOC(place)).Head)
+
+	return res
+}
+
+func (r *Router) delist(symbolID int32) *orderbook.MatcherResult {
+	book := r.books[symbolID]
+	res := book.CancelAll()
+	delete(r.books, symbolID)
+	r.marks.Remove(symbolID)
+	r.delisted = append(r.delisted, book)
+
+	return res
+}
+
+func isSpread(book *orderbook.Naive) bool {
+	_, ok := book.Symbol().(*symbol.Spread)
+
+	return ok
+}
+
+/*
+ * GTC and IOC orders of a leg take implied-in liquidity of its spreads,
+ * the remainder is placed into the book.
+ */
+func (r *Router) placeOutright(
+	book *orderbook.Naive,
+	command *order.Place,
+) *orderbook.MatcherResult {
+	spreadIDs := r.spreadIDsOf(command.SymbolID())
+	category := command.Category()
+
+	if len(spreadIDs) == 0 || command.GroupID() != 0 ||
+		(category != order.GTC && category != order.IOC) {
+		return r.protect(command.SymbolID(), command.TimestampNS(), place(book, command))
+	}
+
+	res := r.spreads.MatchImpliedIn(book, command, spreadIDs)
+
+	if command.Quantity() == 0 {
+		return res
+	}
+
+	rest := r.protect(command.SymbolID(), command.TimestampNS(), place(book, command))
+	res.Append(rest.Head)
+	res.Code = rest.Code
+
+	return res
+}
+
+// Spreads having the symbol as a leg, sorted.
+func (r *Router) spreadIDsOf(symbolID int32) []int32 {
+	var spreadIDs []int32
+
+	for _, id := range r.symbolIDs() {
+		spread_, ok := r.books[id].Symbol().(*symbol.Spread)
+
+		if !ok {
+			continue
+		}
+
+		for _, leg := range spread_.Legs() {
+			if leg.SymbolID() == symbolID {
+				spreadIDs = append(spreadIDs, id)
+			}
+		}
+	}
+
+	return spreadIDs
+}
+
+/*
+ * Books changed by commands of the symbol, legs for spreads,
+ * spreads and their legs for legs (implied-in).
+ */
+func (r *Router) affectedSymbolIDs(symbolID int32) []int32 {
+	book, ok := r.books[symbolID]
+
+	if !ok {
+		return nil
+	}
+
+	spread_, ok := book.Symbol().(*symbol.Spread)
+
+	if !ok {
+		symbolIDs := []int32{symbolID}
+
+		for _, spreadID := range r.spreadIDsOf(symbolID) {
+			symbolIDs = append(symbolIDs, r.affectedSymbolIDs(spreadID)...)
+		}
+
+		return symbolIDs
+	}
+
+	symbolIDs := []int32{symbolID}
+
+	for _, leg := range spread_.Legs() {
+		symbolIDs = append(symbolIDs, leg.SymbolID())
+	}
+
+	return symbolIDs
+}
+
+/*
+ * Client order ids are unique per user among live orders
+ * and within the dedup window, so that resubmissions are rejected.
+ * Ids of placed orders are recorded by `recordClientOrderID`.
+ */
+func (r *Router) checkClientOrderID(
+	book *orderbook.Naive,
+	command *order.Place,
+) resultcode.ResultCode {
+	clientOrderID := command.ClientOrderID()
+
+	if clientOrderID == 0 {
+		return resultcode.Success
+	}
+
+	if r.clientIDs.Contains(command.UserID(), clientOrderID) {
+		return resultcode.MatchingDuplicateClientOrderID
+	}
+
+	if _, ok := book.OrderByClientID(command.UserID(), clientOrderID); ok {
+		return resultcode.MatchingDuplicateClientOrderID
+	}
+
+	return resultcode.Success
+}
+
+// Rejected orders do not use up their client order ids.
+func (r *Router) recordClientOrderID(
+	command *order.Place,
+) {
+	if command.ClientOrderID() != 0 {
+		r.clientIDs.Add(command.UserID(), command.ClientOrderID())
+	}
+}
+
+/*
+ * A new client order id of an amend is subject to the dedup window,
+ * live orders of the book are checked by the book.
+ */
+func (r *Router) checkAmendClientOrderID(
+	book *orderbook.Naive,
+	command *order.Amend,
+) resultcode.ResultCode {
+	clientOrderID := command.ClientOrderID()
+
+	if clientOrderID == 0 || clientOrderID == command.OrigClientOrderID() {
+		return resultcode.Success
+	}
+
+	// kept by the amended order
+	if ord, ok := book.OrderByClientID(command.UserID(), clientOrderID); ok && ord.ID() == command.OrderID() {
+		return resultcode.Success
+	}
+
+	if r.clientIDs.Contains(command.UserID(), clientOrderID) {
+		return resultcode.MatchingDuplicateClientOrderID
+	}
+
+	return resultcode.Success
+}
+
+func place(
+	book *orderbook.Naive,
+	command *order.Place,
+) *orderbook.MatcherResult {
+	switch command.Category() {
+	case order.GTC:
+		return book.PlaceGTC(command)
+	case order.IOC:
+		return book.PlaceIOC(command)
+	case order.FOCBudget:
+		return book.PlaceFOKBudget(command)
+	default:
+		// TODO IOCBudget, FOC
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingUnsupportedOrderType,
+		}
+	}
+}
+
+/*
+ * Cancels orders of a user per symbol (all symbols if `symbolID` is 0).
+ * Holds of the cancelled orders are released in bulk per symbol, see `LastReleases`.
+ */
+func (r *Router) MassCancel(
+	command *order.MassCancel,
+) map[int32]*orderbook.MatcherResult {
+	results := make(map[int32]*orderbook.MatcherResult)
+
+	if command.SymbolID() != 0 {
+		if book, ok := r.books[command.SymbolID()]; ok {
+			results[command.SymbolID()] = book.MassCancel(command)
+		}
+
+		return results
+	}
+
+	for _, symbolID := range r.symbolIDs() {
+		results[symbolID] = r.books[symbolID].MassCancel(command)
+	}
+
+	return results
+}
+
+func (r *Router) massCancel(
+	command *order.MassCancel,
+) *orderbook.MatcherResult {
+	if command.SymbolID() != 0 {
+		if _, ok := r.books[command.SymbolID()]; !ok {
+			return &orderbook.MatcherResult{
+				Code: resultcode.MatchingInvalidOrderBookId,
+			}
+		}
+	}
+
+	results := r.MassCancel(command)
+	symbolIDs := make([]int32, 0, len(results))
+
+	for symbolID := range results {
+		symbolIDs = append(symbolIDs, symbolID)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+
+	for _, symbolID := range symbolIDs {
+		res.Append(results[symbolID].Head)
+	}
+
+	return res
+}
+
+/*
+ * All levels are validated before any ladder is replaced,
+ * so a mass quote with an invalid level is rejected as a whole.
+ * `Codes` of the result holds one ack per level.
+ */
+func (r *Router) massQuote(
+	command *order.MassQuote,
+) *orderbook.MatcherResult {
+	var (
+		ladders = command.Ladders()
+		places  = command.Places()
+		codes   = make([]resultcode.ResultCode, 0, command.Size())
+		failed  = resultcode.Success
+		seen    = make(map[int32]struct{}, len(ladders))
+	)
+
+	for i, ladder := range ladders {
+		symbolID := ladder.SymbolID()
+		book, ok := r.books[symbolID]
+		ladderCode := resultcode.Success
+
+		if !ok {
+			ladderCode = resultcode.MatchingInvalidOrderBookId
+		} else if isSpread(book) {
+			ladderCode = resultcode.UnsupportedSymbolType
+		} else if _, duplicate := seen[symbolID]; duplicate {
+			ladderCode = resultcode.MatchingInvalidQuote
+		} else if r.mmp.IsFrozen(command.UserID(), symbolID, command.TimestampNS()) {
+			ladderCode = resultcode.MatchingMMPFrozen
+		} else if r.isTradingClosed(book, command.TimestampNS()) {
+			ladderCode = resultcode.MatchingTradingClosed
+		} else {
+			ladderCode = orderbook.ValidateLadder(places[i])
+		}
+
+		seen[symbolID] = struct{}{}
+
+		if ladderCode != resultcode.Success && failed == resultcode.Success {
+			failed = ladderCode
+		}
+
+		for _, place := range places[i] {
+			code := ladderCode
+
+			if code == resultcode.Success {
+				code = book.ValidateQuote(place)
+			}
+
+			if code != resultcode.Success && failed == resultcode.Success {
+				failed = code
+			}
+
+			codes = append(codes, code)
+		}
+	}
+
+	if failed != resultcode.Success {
+		for i, code := range codes {
+			if code == resultcode.Success {
+				codes[i] = resultcode.MatchingBatchAborted
+			}
+		}
+
+		return &orderbook.MatcherResult{
+			Code:  failed,
+			Codes: codes,
+		}
+	}
+
+	res := &orderbook.MatcherResult{
+		Code:  resultcode.Success,
+		Codes: make([]resultcode.ResultCode, 0, len(codes)),
+	}
+
+	for i, ladder := range ladders {
+		book := r.books[ladder.SymbolID()]
+		quoteResult := r.protect(
+			ladder.SymbolID(),
+			command.TimestampNS(),
+			book.ReplaceQuotes(command.UserID(), places[i]),
+		)
+		res.Append(quoteResult.Head)
+		res.Codes = append(res.Codes, quoteResult.Codes...)
+	}
+
+	return res
+}
+
+type batchItem interface {
+	UserID() int64
+	SymbolID() int32
+	SetTimestampNS(timestampNS int64)
+}
+
+/*
+ * Items are applied in order with the timestamp of the batch. Affected books,
+ * including books of the market maker protection groups that fills may trip,
+ * market maker protection and client order id state are snapshotted before the first item,
+ * when an item fails the snapshots are restored and events of the applied items are dropped.
+ * `Codes` of the result holds one code per item.
+ */
+func (r *Router) batch(
+	command *cmd.Batch,
+) *orderbook.MatcherResult {
+	codes := make([]resultcode.ResultCode, len(command.Commands))
+	symbolIDs := make([]int32, 0, len(command.Commands))
+	seen := make(map[int32]struct{}, len(command.Commands))
+
+	for i, c := range command.Commands {
+		codes[i] = resultcode.Success
+
+		switch c.(type) {
+		case *order.Place, *order.Cancel, *order.Move, *order.Reduce, *order.Amend:
+			item := c.(batchItem)
+
+			if item.UserID() != command.UserId {
+				codes[i] = resultcode.AuthInvalidUser
+			} else if _, ok := r.books[item.SymbolID()]; !ok {
+				codes[i] = resultcode.MatchingInvalidOrderBookId
+			} else {
+				for _, affected := range r.affectedSymbolIDs(item.SymbolID()) {
+					// fills may trip market maker protections and cancel orders in their groups
+					for _, symbolID := range append([]int32{affected}, r.mmp.GroupSymbolIDs(affected)...) {
+						if _, ok := seen[symbolID]; !ok {
+							seen[symbolID] = struct{}{}
+							symbolIDs = append(symbolIDs, symbolID)
+						}
+					}
+				}
+			}
+		default:
+			codes[i] = resultcode.MatchingUnsupportedCommand
+		}
+	}
+
+	if code := resultcode.MergeToFirstFailed(codes...); code != resultcode.Success &&
+		code != resultcode.Accepted {
+		return abortBatch(code, codes)
+	}
+
+	snapshot, err := r.snapshot(symbolIDs)
+
+	if err != nil {
+		log.Printf("batch snapshot: %v", err)
+
+		return &orderbook.MatcherResult{
+			Code: resultcode.StatePersistMatchingEngineFailed,
+		}
+	}
+
+	res := &orderbook.MatcherResult{}
+
+	for i, c := range command.Commands {
+		c.(batchItem).SetTimestampNS(command.TimestampNS())
+		itemResult := r.process(c)
+		codes[i] = itemResult.Code
+
+		if code := itemResult.Code; code != resultcode.Success && code != resultcode.Accepted {
+			/*
+			 * The snapshot was taken by this process, it fails to restore only on a bug.
+			 * Items of the aborted batch can't be undone otherwise,
+			 * the state would diverge from the journal.
+			 */
+			if err := r.restore(snapshot); err != nil {
+				panic(fmt.Errorf("batch restore: %w", err))
+			}
+
+			return abortBatch(code, codes)
+		}
+
+		res.Append(itemResult.Head)
+	}
+
+	res.Code = resultcode.MergeToFirstFailed(codes...)
+	res.Codes = codes
+
+	return res
+}
+
+func abortBatch(
+	code resultcode.ResultCode,
+	codes []resultcode.ResultCode,
+) *orderbook.MatcherResult {
+	for i, c := range codes {
+		if c == resultcode.Success || c == resultcode.Accepted {
+			codes[i] = resultcode.MatchingBatchAborted
+		}
+	}
+
+	return &orderbook.MatcherResult{
+		Code:  code,
+		Codes: codes,
+	}
+}
+
+// State touched by commands of the given symbols.
+type snapshot struct {
+	books     map[int32]*bytes.Buffer
+	untaken   map[int32]*orderbook.Untaken
+	mmp       *bytes.Buffer
+	clientIDs *bytes.Buffer
+	_         struct{}
+}
+
+func (r *Router) snapshot(
+	symbolIDs []int32,
+) (*snapshot, error) {
+	s := &snapshot{
+		books:     make(map[int32]*bytes.Buffer, len(symbolIDs)),
+		untaken:   make(map[int32]*orderbook.Untaken, len(symbolIDs)),
+		mmp:       &bytes.Buffer{},
+		clientIDs: &bytes.Buffer{},
+	}
+
+	for _, symbolID := range symbolIDs {
+		book, ok := r.books[symbolID]
+
+		if !ok {
+			continue
+		}
+
+		out := &bytes.Buffer{}
+
+		if err := book.Marshal(out); err != nil {
+			return nil, err
+		}
+
+		s.books[symbolID] = out
+		s.untaken[symbolID] = book.Untaken()
+	}
+
+	if err := r.mmp.Marshal(s.mmp); err != nil {
+		return nil, err
+	}
+
+	if err := r.clientIDs.Marshal(s.clientIDs); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// State is changed only if the whole snapshot is restored.
+func (r *Router) restore(
+	s *snapshot,
+) error {
+	books := make(map[int32]*orderbook.Naive, len(s.books))
+
+	for symbolID, in := range s.books {
+		book := &orderbook.Naive{}
+
+		if err := book.Unmarshal(in); err != nil {
+			return err
+		}
+
+		// changes of the commands before the batch, e.g. session expiries
+		book.SetUntaken(s.untaken[symbolID])
+		books[symbolID] = book
+	}
+
+	protections := mmp.NewRegistry()
+
+	if err := protections.Unmarshal(s.mmp); err != nil {
+		return err
+	}
+
+	clientIDs := &dedup.Window{}
+
+	if err := clientIDs.Unmarshal(s.clientIDs); err != nil {
+		return err
+	}
+
+	for symbolID, book := range books {
+		r.books[symbolID] = book
+	}
+
+	r.mmp = protections
+	r.clientIDs = clientIDs
+
+	return nil
+}
+
+func (r *Router) addSymbols(
+	command *cmd.AddSymbols,
+) *orderbook.MatcherResult {
+	codes := make([]resultcode.ResultCode, 0, len(command.Symbols))
+	symbolIDs := make([]int32, 0, len(command.Symbols))
+
+	for symbolID := range command.Symbols {
+		symbolIDs = append(symbolIDs, symbolID)
+	}
+
+	// spreads after outrights, legs may be added by the same command
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		_, iSpread := command.Symbols[symbolIDs[i]].(*symbol.Spread)
+		_, jSpread := command.Symbols[symbolIDs[j]].(*symbol.Spread)
+
+		if iSpread != jSpread {
+			return jSpread
+		}
+
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	for _, symbolID := range symbolIDs {
+		codes = append(codes, r.AddBook(orderbook.NewNaive(command.Symbols[symbolID])))
+	}
+
+	return &orderbook.MatcherResult{
+		Code: resultcode.MergeToFirstFailed(codes...),
+	}
+}
+
+func (r *Router) Marshal(out *bytes.Buffer) error {
+	symbolIDs := r.symbolIDs()
+
+	if err := serialization.WriteInt32(int32(len(symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, symbolID := range symbolIDs {
+		if err := r.books[symbolID].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	if err := r.sessions.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := r.mmp.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := r.clientIDs.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := r.feed.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := r.stats.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := r.marks.Marshal(out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Router) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	books := make(map[int32]*orderbook.Naive, size)
+
+	for ; size > 0; size-- {
+		book := &orderbook.Naive{}
+
+		if err := book.Unmarshal(in); err != nil {
+			return err
+		}
+
+		books[book.Symbol().ID()] = book
+	}
+
+	sessions := session.NewRegistry()
+
+	if err := sessions.Unmarshal(in); err != nil {
+		return err
+	}
+
+	protections := mmp.NewRegistry()
+
+	if err := protections.Unmarshal(in); err != nil {
+		return err
+	}
+
+	clientIDs := &dedup.Window{}
+
+	if err := clientIDs.Unmarshal(in); err != nil {
+		return err
+	}
+
+	feed := &marketdata.Feed{}
+
+	if err := feed.Unmarshal(in); err != nil {
+		return err
+	}
+
+	stats_ := &stats.Stats{}
+
+	if err := stats_.Unmarshal(in); err != nil {
+		return err
+	}
+
+	marks := &riskengine.Marks{}
+
+	if err := marks.Unmarshal(in); err != nil {
+		return err
+	}
+
+	r.books = books
+	r.sessions = sessions
+	r.mmp = protections
+	r.clientIDs = clientIDs
+	r.feed = feed
+	r.stats = stats_
+	r.marks = marks
+
+	return nil
+}
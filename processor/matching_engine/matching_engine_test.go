@@ -0,0 +1,886 @@
+package matchingengine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/bus"
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const (
+	symbolA int32 = 1
+	symbolB int32 = 2
+	admin   int64 = 9
+)
+
+type users struct {
+	suspended map[int64]bool
+	admins    map[int64]bool
+}
+
+// all users are registered
+func (u *users) IsActive(userID int64) bool {
+	return !u.suspended[userID]
+}
+
+func (u *users) IsAdmin(userID int64) bool {
+	return u.admins[userID]
+}
+
+func (u *users) Liquidation(
+	userID int64,
+	symbolID int32,
+	orderID int64,
+) (*cmd.Liquidate, bool) {
+	return nil, false
+}
+
+// Future contract without expiry.
+func newFuture(t *testing.T, symbolID int32) *symbol.FutureContract {
+	out := &bytes.Buffer{}
+
+	for _, code := range []int8{2, 1} {
+		if err := serialization.WriteInt8(code, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int32{symbolID, 1, 2} {
+		if err := serialization.WriteInt32(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int64{1, 1, 0, 0, 10, 10, 0, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := symbol.Unmarshal(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*symbol.FutureContract)
+}
+
+func newTestRouter(t *testing.T) *Router {
+	r := NewRouter()
+	r.SetUsers(&users{
+		suspended: map[int64]bool{},
+		admins:    map[int64]bool{admin: true},
+	})
+
+	for _, symbolID := range []int32{symbolA, symbolB} {
+		if code := r.AddBook(orderbook.NewNaive(newFuture(t, symbolID))); code != resultcode.Success {
+			t.Fatalf("add book %v: %v", symbolID, code)
+		}
+	}
+
+	return r
+}
+
+func gtc(
+	orderID int64,
+	userID int64,
+	symbolID int32,
+	action order.Action,
+	price int64,
+	quantity int64,
+) *order.Place {
+	return order.NewPlace(orderID, userID, price, quantity, price, symbolID, 0, action, order.GTC)
+}
+
+func withClientID(place *order.Place, clientOrderID int64) *order.Place {
+	place.SetClientOrderID(clientOrderID)
+
+	return place
+}
+
+func inSession(place *order.Place, sessionID int64) *order.Place {
+	place.SetSession(sessionID)
+
+	return place
+}
+
+// Order commands have no setters of metadata and of some fields,
+// they are built from their serialized form.
+type unmarshalable interface {
+	Marshal(out *bytes.Buffer) error
+	Unmarshal(in *bytes.Buffer) error
+}
+
+func writeMetadata(t *testing.T, timestampNS int64, out *bytes.Buffer) {
+	if err := serialization.WriteInt64(0, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt64(timestampNS, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt32(0, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt64(0, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func move(
+	t *testing.T,
+	orderID int64,
+	userID int64,
+	symbolID int32,
+	toPrice int64,
+) *order.Move {
+	out := &bytes.Buffer{}
+	writeMetadata(t, 0, out)
+
+	if err := serialization.WriteInt64(userID, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serialization.WriteInt32(symbolID, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []int64{orderID, toPrice, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := order.NewMove()
+
+	if err := m.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// Copy of the command with the metadata timestamp set.
+func at(
+	t *testing.T,
+	command unmarshalable,
+	copy_ unmarshalable,
+	timestampNS int64,
+) {
+	out := &bytes.Buffer{}
+
+	if err := command.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	b := out.Bytes()
+	patched := &bytes.Buffer{}
+	writeMetadata(t, timestampNS, patched)
+	copy(b[8:16], patched.Bytes()[8:16])
+
+	if err := copy_.Unmarshal(bytes.NewBuffer(b)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type step struct {
+	command cmd.Command
+	code    resultcode.ResultCode
+	resting []int64 // orders of `symbolA` expected in the book after the step
+	gone    []int64 // orders of `symbolA` expected not to be in the book
+}
+
+func runSteps(t *testing.T, r *Router, steps []step) {
+	t.Helper()
+
+	for i, s := range steps {
+		res := r.Process(s.command)
+
+		if res.Code != s.code {
+			t.Fatalf("step %v %T: code %v, want %v", i, s.command, res.Code, s.code)
+		}
+
+		book, _ := r.Book(symbolA)
+		resting := make(map[int64]bool)
+
+		for _, userID := range []int64{1, 2, 3} {
+			for _, ord := range book.UserOrders(userID) {
+				resting[ord.ID()] = true
+			}
+		}
+
+		for _, orderID := range s.resting {
+			if !resting[orderID] {
+				t.Errorf("step %v: order %v not resting", i, orderID)
+			}
+		}
+
+		for _, orderID := range s.gone {
+			if resting[orderID] {
+				t.Errorf("step %v: order %v still resting", i, orderID)
+			}
+		}
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	const ok = resultcode.Success
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "kill cancels session orders and blocks the user",
+			steps: []step{
+				{command: &cmd.Heartbeat{UserId: 1, SessionID: 5, TimeoutNS: 1 << 60}, code: ok},
+				{command: inSession(gtc(1, 1, symbolA, order.Bid, 100, 10), 5), code: ok, resting: []int64{1}},
+				{command: gtc(2, 1, symbolA, order.Bid, 99, 10), code: ok, resting: []int64{1, 2}},
+				{command: &cmd.KillSwitch{UserId: 1, SessionID: 5}, code: ok, resting: []int64{2}, gone: []int64{1}},
+				{command: gtc(3, 1, symbolA, order.Bid, 98, 10), code: resultcode.MatchingKillSwitchActive, gone: []int64{3}},
+				{command: move(t, 2, 1, symbolA, 97), code: resultcode.MatchingKillSwitchActive},
+				{command: order.NewAmend(2, 1, symbolA, 0, 5, 0), code: resultcode.MatchingKillSwitchActive},
+				{command: gtc(4, 2, symbolA, order.Ask, 110, 10), code: ok, resting: []int64{4}},
+			},
+		},
+		{
+			name: "only admins reset the kill switch",
+			steps: []step{
+				{command: &cmd.Heartbeat{UserId: 1, SessionID: 5, TimeoutNS: 1 << 60}, code: ok},
+				{command: &cmd.KillSwitch{UserId: 1, SessionID: 5}, code: ok},
+				{command: &cmd.ResetKillSwitch{UserId: 1, AdminId: 1}, code: resultcode.AuthNotAdmin},
+				{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: resultcode.MatchingKillSwitchActive},
+				{command: &cmd.ResetKillSwitch{UserId: 1, AdminId: admin}, code: ok},
+				{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: ok, resting: []int64{1}},
+			},
+		},
+		{
+			name: "kill of a session of another user",
+			steps: []step{
+				{command: &cmd.Heartbeat{UserId: 1, SessionID: 5, TimeoutNS: 1 << 60}, code: ok},
+				{command: &cmd.KillSwitch{UserId: 2, SessionID: 5}, code: resultcode.MatchingInvalidSession},
+				{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: ok, resting: []int64{1}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runSteps(t, newTestRouter(t), test.steps)
+		})
+	}
+}
+
+func TestMMP(t *testing.T) {
+	const ok = resultcode.Success
+
+	setMMP := &cmd.SetMMP{
+		UserId:      1,
+		GroupID:     7,
+		SymbolIDs:   []int32{symbolA, symbolB},
+		WindowNS:    1000,
+		MaxQuantity: 3,
+		CooldownNS:  1 << 60,
+	}
+
+	tests := []struct {
+		name     string
+		steps    []step
+		restingB bool // order 3 of user 1 on `symbolB` after the steps
+	}{
+		{
+			name: "limit not exceeded",
+			steps: []step{
+				{command: setMMP, code: ok},
+				{command: gtc(1, 1, symbolA, order.Ask, 100, 5), code: ok},
+				{command: gtc(3, 1, symbolB, order.Ask, 100, 5), code: ok},
+				{command: gtc(2, 2, symbolA, order.Bid, 100, 3), code: ok, resting: []int64{1}},
+				{command: move(t, 1, 1, symbolA, 101), code: ok, resting: []int64{1}},
+			},
+			restingB: true,
+		},
+		{
+			name: "trip cancels the group and freezes the user",
+			steps: []step{
+				{command: setMMP, code: ok},
+				{command: gtc(1, 1, symbolA, order.Ask, 100, 5), code: ok},
+				{command: gtc(3, 1, symbolB, order.Ask, 100, 5), code: ok},
+				{command: gtc(2, 2, symbolA, order.Bid, 100, 4), code: ok, gone: []int64{1}},
+				{command: gtc(4, 1, symbolA, order.Ask, 100, 5), code: resultcode.MatchingMMPFrozen, gone: []int64{4}},
+				{command: move(t, 1, 1, symbolA, 101), code: resultcode.MatchingMMPFrozen},
+				{command: order.NewAmend(1, 1, symbolA, 0, 1, 0), code: resultcode.MatchingMMPFrozen},
+				{command: gtc(5, 2, symbolA, order.Ask, 100, 5), code: ok, resting: []int64{5}},
+				{command: &cmd.ResetMMP{UserId: 1, GroupID: 7, AdminId: 1}, code: resultcode.AuthNotAdmin},
+				{command: gtc(4, 1, symbolA, order.Ask, 101, 5), code: resultcode.MatchingMMPFrozen, gone: []int64{4}},
+				{command: &cmd.ResetMMP{UserId: 1, GroupID: 7, AdminId: admin}, code: ok},
+				{command: gtc(4, 1, symbolA, order.Ask, 101, 5), code: ok, resting: []int64{4}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			runSteps(t, r, test.steps)
+
+			book, _ := r.Book(symbolB)
+
+			if resting := len(book.UserOrders(1)) == 1; resting != test.restingB {
+				t.Errorf("order on the other symbol of the group resting %v, want %v", resting, test.restingB)
+			}
+		})
+	}
+}
+
+func TestMassQuoteCrossed(t *testing.T) {
+	r := newTestRouter(t)
+	res := r.Process(order.NewMassQuote(
+		1,
+		0,
+		1,
+		order.NewLadder(symbolA, order.NewQuote(order.Bid, 100, 5), order.NewQuote(order.Ask, 101, 5)),
+		order.NewLadder(symbolB, order.NewQuote(order.Bid, 100, 5), order.NewQuote(order.Ask, 100, 5)),
+	))
+
+	if res.Code != resultcode.MatchingCrossedQuote {
+		t.Fatalf("code %v, want %v", res.Code, resultcode.MatchingCrossedQuote)
+	}
+
+	want := []resultcode.ResultCode{
+		resultcode.MatchingBatchAborted,
+		resultcode.MatchingBatchAborted,
+		resultcode.MatchingCrossedQuote,
+		resultcode.MatchingCrossedQuote,
+	}
+
+	if len(res.Codes) != len(want) {
+		t.Fatalf("codes %v, want %v", res.Codes, want)
+	}
+
+	for i := range want {
+		if res.Codes[i] != want[i] {
+			t.Errorf("level %v: code %v, want %v", i, res.Codes[i], want[i])
+		}
+	}
+
+	for _, symbolID := range []int32{symbolA, symbolB} {
+		if book, _ := r.Book(symbolID); len(book.UserOrders(1)) != 0 {
+			t.Errorf("symbol %v: levels placed", symbolID)
+		}
+	}
+}
+
+func batch(
+	userID int64,
+	timestampNS int64,
+	commands ...cmd.Command,
+) *cmd.Batch {
+	return &cmd.Batch{
+		UserId:   userID,
+		Commands: commands,
+		Metadata: cmd.Metadata{TimestampNs: timestampNS},
+	}
+}
+
+func TestBatch(t *testing.T) {
+	const ok = resultcode.Success
+
+	setMMP := &cmd.SetMMP{
+		UserId:      1,
+		GroupID:     7,
+		SymbolIDs:   []int32{symbolA, symbolB},
+		WindowNS:    1000,
+		MaxQuantity: 3,
+		CooldownNS:  1 << 60,
+	}
+
+	tests := []struct {
+		name     string
+		steps    []step
+		restingB bool // order 3 of user 1 on `symbolB` after the steps
+		released bool // margin of user 1 released by the last step
+	}{
+		{
+			name: "failed item restores the book",
+			steps: []step{
+				{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: ok},
+				{
+					command: batch(2, 0, gtc(2, 2, symbolA, order.Ask, 100, 10), move(t, 99, 2, symbolA, 100)),
+					code:    resultcode.MatchingUnknownOrderID,
+					resting: []int64{1},
+					gone:    []int64{2},
+				},
+			},
+		},
+		{
+			name: "orders cancelled by a trip in a failed batch are restored",
+			steps: []step{
+				{command: setMMP, code: ok},
+				{command: gtc(1, 1, symbolA, order.Ask, 100, 5), code: ok},
+				{command: gtc(3, 1, symbolB, order.Ask, 100, 5), code: ok},
+				{
+					command: batch(2, 0, gtc(2, 2, symbolA, order.Bid, 100, 4), move(t, 99, 2, symbolA, 100)),
+					code:    resultcode.MatchingUnknownOrderID,
+					resting: []int64{1},
+				},
+				{command: gtc(4, 1, symbolA, order.Ask, 101, 5), code: ok, resting: []int64{1, 4}},
+			},
+			restingB: true,
+		},
+		{
+			name: "items take the timestamp of the batch",
+			steps: []step{
+				{command: setMMP, code: ok},
+				{command: gtc(1, 1, symbolA, order.Ask, 100, 5), code: ok},
+				{command: gtc(3, 1, symbolB, order.Ask, 100, 5), code: ok},
+				{command: gtc(2, 2, symbolA, order.Bid, 100, 2), code: ok},
+				// the first fill is out of the window at 5000
+				{command: batch(2, 5000, gtc(4, 2, symbolA, order.Bid, 100, 2)), code: ok, resting: []int64{1}},
+			},
+			restingB: true,
+		},
+		{
+			name: "changes of expired sessions are kept",
+			steps: []step{
+				{command: &cmd.Heartbeat{UserId: 1, SessionID: 5, TimeoutNS: 10}, code: ok},
+				{command: inSession(gtc(1, 1, symbolA, order.Bid, 100, 10), 5), code: ok, resting: []int64{1}},
+				{
+					command: batch(2, 100, gtc(2, 2, symbolA, order.Ask, 110, 10), move(t, 99, 2, symbolA, 100)),
+					code:    resultcode.MatchingUnknownOrderID,
+					gone:    []int64{1, 2},
+				},
+			},
+			released: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			runSteps(t, r, test.steps)
+
+			book, _ := r.Book(symbolB)
+
+			if resting := len(book.UserOrders(1)) == 1; resting != test.restingB {
+				t.Errorf("order on the other symbol of the group resting %v, want %v", resting, test.restingB)
+			}
+
+			released := false
+
+			for _, release := range r.LastReleases() {
+				released = released || release.UserID() == 1 && release.BidQuantity() == 10
+			}
+
+			if released != test.released {
+				t.Errorf("released %v, want %v", released, test.released)
+			}
+		})
+	}
+}
+
+func TestImpliedIn(t *testing.T) {
+	const spreadAB int32 = 3
+
+	// maker, taker, price, quantity
+	type fill [4]int64
+
+	tests := []struct {
+		name     string
+		resting  []*order.Place
+		place    *order.Place
+		fills    map[int32][]fill
+		restingA int // resting orders of `symbolA` after the place
+	}{
+		{
+			name: "implied-in better than the leg book",
+			resting: []*order.Place{
+				gtc(1, 2, spreadAB, order.Bid, 5, 3),
+				gtc(2, 3, symbolB, order.Bid, 100, 10),
+				gtc(3, 3, symbolA, order.Bid, 104, 10),
+			},
+			place: gtc(4, 1, symbolA, order.Ask, 100, 5),
+			fills: map[int32][]fill{
+				symbolA:  {{2, 1, 105, 3}, {3, 1, 104, 2}},
+				symbolB:  {{3, 2, 100, 3}},
+				spreadAB: {{2, 0, 5, 3}},
+			},
+			restingA: 1,
+		},
+		{
+			name: "leg book first on ties",
+			resting: []*order.Place{
+				gtc(1, 2, spreadAB, order.Bid, 5, 3),
+				gtc(2, 3, symbolB, order.Bid, 100, 10),
+				gtc(3, 3, symbolA, order.Bid, 105, 2),
+			},
+			place: gtc(4, 1, symbolA, order.Ask, 105, 4),
+			fills: map[int32][]fill{
+				symbolA:  {{3, 1, 105, 2}, {2, 1, 105, 2}},
+				symbolB:  {{3, 2, 100, 2}},
+				spreadAB: {{2, 0, 5, 2}},
+			},
+		},
+		{
+			name: "implied-in worse than the limit",
+			resting: []*order.Place{
+				gtc(1, 2, spreadAB, order.Bid, 5, 3),
+				gtc(2, 3, symbolB, order.Bid, 100, 10),
+			},
+			place:    gtc(4, 1, symbolA, order.Ask, 106, 5),
+			fills:    map[int32][]fill{},
+			restingA: 1,
+		},
+		{
+			name: "sell leg of the spread",
+			resting: []*order.Place{
+				gtc(1, 2, spreadAB, order.Bid, 5, 3),
+				gtc(2, 3, symbolA, order.Ask, 110, 10),
+			},
+			place: gtc(4, 1, symbolB, order.Bid, 106, 5),
+			fills: map[int32][]fill{
+				symbolA:  {{3, 2, 110, 3}},
+				symbolB:  {{2, 1, 105, 3}},
+				spreadAB: {{2, 0, 5, 3}},
+			},
+			restingA: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			spread := symbol.NewSpread(spreadAB, symbol.NewLeg(symbolA, 1), symbol.NewLeg(symbolB, -1))
+
+			if code := r.AddBook(orderbook.NewNaive(spread)); code != resultcode.Success {
+				t.Fatalf("add spread: %v", code)
+			}
+
+			for _, place := range test.resting {
+				if res := r.Process(place); res.Code != resultcode.Success {
+					t.Fatalf("resting order %v: %v", place.OrderID(), res.Code)
+				}
+			}
+
+			if res := r.Process(test.place); res.Code != resultcode.Success {
+				t.Fatalf("code %v", res.Code)
+			}
+
+			for _, symbolID := range []int32{symbolA, symbolB, spreadAB} {
+				trades := r.LastTrades()[symbolID]
+
+				if len(trades) != len(test.fills[symbolID]) {
+					t.Fatalf("symbol %v: %v trades, want %v", symbolID, len(trades), len(test.fills[symbolID]))
+				}
+
+				for i, trade := range trades {
+					got := fill{trade.MakerUserID(), trade.TakerUserID(), trade.Price(), trade.Quantity()}
+
+					if got != test.fills[symbolID][i] {
+						t.Errorf("symbol %v trade %v: %v, want %v", symbolID, i, got, test.fills[symbolID][i])
+					}
+				}
+			}
+
+			book, _ := r.Book(symbolA)
+
+			if resting := len(book.AskOrders()) + len(book.BidOrders()); resting != test.restingA {
+				t.Errorf("%v resting orders of symbol A, want %v", resting, test.restingA)
+			}
+		})
+	}
+}
+
+func amend(
+	orderID int64,
+	origClientOrderID int64,
+	quantity int64,
+	clientOrderID int64,
+) *order.Amend {
+	a := order.NewAmend(orderID, 1, symbolA, 0, quantity, clientOrderID)
+	a.SetOrigClientOrderID(origClientOrderID)
+
+	return a
+}
+
+func TestAmendClientOrderID(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []cmd.Command
+		code     resultcode.ResultCode
+		replaced int64 // client order id of the replace event of the last command, -1 if none
+	}{
+		{
+			name:     "by client order id",
+			commands: []cmd.Command{amend(0, 11, 5, 0)},
+			code:     resultcode.Success,
+			replaced: 11,
+		},
+		{
+			name:     "new client order id",
+			commands: []cmd.Command{amend(0, 11, 5, 12)},
+			code:     resultcode.Success,
+			replaced: 12,
+		},
+		{
+			name:     "new client order id on a new price",
+			commands: []cmd.Command{order.NewAmend(1, 1, symbolA, 99, 0, 12)},
+			code:     resultcode.Success,
+			replaced: 12,
+		},
+		{
+			name:     "unknown client order id",
+			commands: []cmd.Command{amend(0, 13, 5, 0)},
+			code:     resultcode.MatchingUnknownOrderID,
+			replaced: -1,
+		},
+		{
+			name:     "previous client order id",
+			commands: []cmd.Command{amend(0, 11, 5, 12), amend(0, 12, 5, 11)},
+			code:     resultcode.MatchingDuplicateClientOrderID,
+			replaced: -1,
+		},
+		{
+			name:     "new client order id is recorded",
+			commands: []cmd.Command{amend(0, 11, 5, 12), withClientID(gtc(2, 1, symbolA, order.Bid, 90, 1), 12)},
+			code:     resultcode.MatchingDuplicateClientOrderID,
+			replaced: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRouter(t)
+
+			if res := r.Process(withClientID(gtc(1, 1, symbolA, order.Bid, 100, 10), 11)); res.Code != resultcode.Success {
+				t.Fatalf("place: %v", res.Code)
+			}
+
+			var res *orderbook.MatcherResult
+
+			for _, command := range test.commands {
+				res = r.Process(command)
+			}
+
+			if res.Code != test.code {
+				t.Fatalf("code %v, want %v", res.Code, test.code)
+			}
+
+			replaced := int64(-1)
+
+			for e := res.Head; e != nil; e = e.Next() {
+				if replace, ok := e.(*event.Replace); ok {
+					replaced = replace.ClientOrderID()
+				}
+			}
+
+			if replaced != test.replaced {
+				t.Errorf("replaced with client order id %v, want %v", replaced, test.replaced)
+			}
+		})
+	}
+}
+
+func TestOwnershipOverride(t *testing.T) {
+	override := func(command ownedCommand) cmd.Command {
+		command.SetServiceFlags(order.OwnershipOverride)
+
+		return command.(cmd.Command)
+	}
+
+	tests := []struct {
+		name    string
+		command cmd.Command
+		code    resultcode.ResultCode
+	}{
+		{
+			name:    "flag of a client is ignored",
+			command: override(order.NewAmend(1, 2, symbolA, 0, 5, 0)),
+			code:    resultcode.AuthOrderNotOwned,
+		},
+		{
+			name:    "flag of a client is ignored on move",
+			command: override(move(t, 1, 2, symbolA, 99)),
+			code:    resultcode.AuthOrderNotOwned,
+		},
+		{
+			name:    "admins act on orders of other users",
+			command: order.NewAmend(1, admin, symbolA, 0, 5, 0),
+			code:    resultcode.Success,
+		},
+		{
+			name:    "admins move orders of other users",
+			command: move(t, 1, admin, symbolA, 99),
+			code:    resultcode.Success,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runSteps(t, newTestRouter(t), []step{
+				{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: resultcode.Success},
+				{command: test.command, code: test.code, resting: []int64{1}},
+			})
+		})
+	}
+}
+
+func TestClientOrderIDRecorded(t *testing.T) {
+	ioc := func(orderID int64, groupID int64) *order.Place {
+		place := order.NewPlace(orderID, 1, 100, 10, 100, symbolA, 0, order.Bid, order.IOC)
+
+		if groupID != 0 {
+			place.SetGroup(groupID, order.OCOLeg)
+		}
+
+		return withClientID(place, 12)
+	}
+
+	tests := []struct {
+		name  string
+		first *order.Place
+		code  resultcode.ResultCode // of a GTC order with the same client order id
+	}{
+		{
+			name:  "rejected order",
+			first: ioc(1, 7),
+			code:  resultcode.Success,
+		},
+		{
+			name:  "unfilled IOC order",
+			first: ioc(1, 0),
+			code:  resultcode.MatchingDuplicateClientOrderID,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRouter(t)
+			r.Process(test.first)
+
+			if res := r.Process(withClientID(gtc(2, 1, symbolA, order.Bid, 90, 1), 12)); res.Code != test.code {
+				t.Errorf("code %v, want %v", res.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := newTestRouter(t)
+
+	for _, c := range []cmd.Command{
+		withClientID(gtc(1, 1, symbolA, order.Bid, 100, 10), 5),
+		gtc(2, 2, symbolA, order.Ask, 100, 4),
+	} {
+		if res := r.Process(c); res.Code != resultcode.Success {
+			t.Fatalf("%T: %v", c, res.Code)
+		}
+	}
+
+	if res := r.Process(&cmd.Reset{}); res.Code != resultcode.Success {
+		t.Fatalf("reset: %v", res.Code)
+	}
+
+	if len(r.LastTrades()) != 0 || len(r.LastHolds()) != 0 || len(r.LastReleases()) != 0 {
+		t.Errorf("changes of the last command kept: %v trades, %v holds, %v releases",
+			len(r.LastTrades()), len(r.LastHolds()), len(r.LastReleases()))
+	}
+
+	if _, ok := r.Book(symbolA); ok {
+		t.Error("book kept")
+	}
+
+	if r.HasOrders(1) {
+		t.Error("orders kept")
+	}
+
+	if code := r.AddBook(orderbook.NewNaive(newFuture(t, symbolA))); code != resultcode.Success {
+		t.Fatalf("add book: %v", code)
+	}
+
+	runSteps(t, r, []step{
+		{command: withClientID(gtc(1, 1, symbolA, order.Bid, 100, 10), 5), code: resultcode.Success, resting: []int64{1}},
+	})
+}
+
+// Commands of the user engine are published as accepted by the matching engine.
+func TestUserCommands(t *testing.T) {
+	b, err := bus.New(16)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber, err := b.Subscribe("reports", bus.Block)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRouter(t)
+	r.SetBus(b)
+
+	commands := []cmd.Command{
+		&cmd.AddUser{UserId: 1},
+		&cmd.BalanceAdj{UserId: 1, Currency: 2, Amount: 100, TXID: 1},
+		&cmd.AddUser{UserId: 2},
+		&cmd.AddSubAccount{UserId: 2, ParentId: 1},
+		&cmd.Transfer{TransferID: 1, FromUserId: 1, ToUserId: 2, Currency: 2, Amount: 10},
+		&cmd.SuspendUser{UserId: 2},
+		&cmd.ResumeUser{UserId: 2},
+		&cmd.SetAdmin{UserId: 1, Admin: true},
+		&cmd.Funding{SymbolID: symbolA},
+	}
+
+	for _, c := range commands {
+		if res := r.Process(c); res.Code != resultcode.Success {
+			t.Errorf("%T: %v", c, res.Code)
+		}
+	}
+
+	envelopes, err := subscriber.Poll(len(commands))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(envelopes) != len(commands) {
+		t.Fatalf("%v envelopes, want %v", len(envelopes), len(commands))
+	}
+
+	for i, e := range envelopes {
+		if e.ResultCode() != resultcode.Success {
+			t.Errorf("%T: published %v", commands[i], e.ResultCode())
+		}
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	r := newTestRouter(t)
+	runSteps(t, r, []step{{command: gtc(1, 1, symbolA, order.Bid, 100, 10), code: resultcode.Success, resting: []int64{1}}})
+
+	s, err := r.snapshot([]int32{symbolA})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runSteps(t, r, []step{{command: gtc(2, 1, symbolA, order.Bid, 99, 10), code: resultcode.Success, resting: []int64{1, 2}}})
+	s.mmp = &bytes.Buffer{}
+
+	if err := r.restore(s); err == nil {
+		t.Fatal("restore of a corrupted snapshot succeeded")
+	}
+
+	// not restored partially
+	runSteps(t, r, []step{{command: gtc(3, 1, symbolA, order.Bid, 98, 10), code: resultcode.Success, resting: []int64{1, 2, 3}}})
+}
@@ -0,0 +1,61 @@
+package riskengine
+
+import (
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+)
+
+/*
+ * Risk holds of a user on one symbol required by the orders
+ * resting in the book, e.g. the levels of a mass quote.
+ * Accumulated per side so that a mass quote
+ * is held once instead of once per level.
+ * Holds of the replaced quotes are freed by `NewRelease` of the same change.
+ */
+type Hold struct {
+	userID      int64
+	symbolID    int32
+	askQuantity int64
+	bidQuantity int64
+
+	// sum of price * quantity of bids
+	bidAmount int64
+	_         struct{}
+}
+
+func NewHold(
+	symbolID int32,
+	change *orderbook.PendingChange,
+) *Hold {
+	return &Hold{
+		userID:      change.UserID(),
+		symbolID:    symbolID,
+		askQuantity: change.Added(order.Ask),
+		bidQuantity: change.Added(order.Bid),
+		bidAmount:   change.AddedAmount(order.Bid),
+	}
+}
+
+func (h *Hold) UserID() int64 {
+	return h.userID
+}
+
+func (h *Hold) SymbolID() int32 {
+	return h.symbolID
+}
+
+func (h *Hold) AskQuantity() int64 {
+	return h.askQuantity
+}
+
+func (h *Hold) BidQuantity() int64 {
+	return h.bidQuantity
+}
+
+func (h *Hold) BidAmount() int64 {
+	return h.bidAmount
+}
+
+func (h *Hold) IsEmpty() bool {
+	return h.askQuantity == 0 && h.bidQuantity == 0
+}
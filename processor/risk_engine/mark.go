@@ -0,0 +1,307 @@
+package riskengine
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/xerexchain/matching-engine/math"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+const (
+	// Bound of the premium over the index, in basis points of the index.
+	DefaultMaxPremiumBP int64 = 50
+
+	// Samples of the fair-price EMA.
+	DefaultEMAPeriods int64 = 30
+)
+
+/*
+ * Mark price of a symbol: index price plus the premium of the book mid
+ * over the index, smoothed by an EMA (fair price)
+ * and bounded by `maxPremiumBP` of the index.
+ * The premium is sampled on index updates only,
+ * so moving a thin book between updates does not move the mark price.
+ * Both sides of the record are the mark price.
+ */
+type MarkPrice struct {
+	symbolID    int32
+	index       int64
+	premiumEMA  int64
+	samples     int64
+	price       int64 // 0 if unknown
+	timestampNS int64 // of the last index update
+	_           struct{}
+}
+
+var _ LastPriceCacheRecord = (*MarkPrice)(nil)
+
+func (m *MarkPrice) SymbolID() int32 {
+	return m.symbolID
+}
+
+func (m *MarkPrice) Index() int64 {
+	return m.index
+}
+
+func (m *MarkPrice) PremiumEMA() int64 {
+	return m.premiumEMA
+}
+
+func (m *MarkPrice) Price() int64 {
+	return m.price
+}
+
+func (m *MarkPrice) TimestampNS() int64 {
+	return m.timestampNS
+}
+
+// `math.MaxInt64` if unknown, as an empty ask side.
+func (m *MarkPrice) AskPrice() int64 {
+	if m.price == 0 {
+		return math.MaxInt64
+	}
+
+	return m.price
+}
+
+// 0 if unknown, as an empty bid side.
+func (m *MarkPrice) BidPrice() int64 {
+	return m.price
+}
+
+// TODO unexported fields
+// TODO remove panic?
+func (m *MarkPrice) Hash() uint64 {
+	hash, err := hashstructure.Hash(*m, hashstructure.FormatV2, nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+func (m *MarkPrice) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt32(m.symbolID, out); err != nil {
+		return err
+	}
+
+	fields := []int64{
+		m.index,
+		m.premiumEMA,
+		m.samples,
+		m.price,
+		m.timestampNS,
+	}
+
+	for _, f := range fields {
+		if err := serialization.WriteInt64(f, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MarkPrice) Unmarshal(in *bytes.Buffer) error {
+	symbolID, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	var fields [5]int64
+
+	for i := range fields {
+		f, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		fields[i] = f
+	}
+
+	m.symbolID = symbolID
+	m.index = fields[0]
+	m.premiumEMA = fields[1]
+	m.samples = fields[2]
+	m.price = fields[3]
+	m.timestampNS = fields[4]
+
+	return nil
+}
+
+// Mark prices of symbols.
+type Marks struct {
+	maxPremiumBP int64
+	emaPeriods   int64
+	prices       map[int32]*MarkPrice
+	_            struct{}
+}
+
+func NewMarks(
+	maxPremiumBP int64,
+	emaPeriods int64,
+) *Marks {
+	return &Marks{
+		maxPremiumBP: maxPremiumBP,
+		emaPeriods:   emaPeriods,
+		prices:       make(map[int32]*MarkPrice),
+	}
+}
+
+// Clears mark prices, the configuration is kept.
+func (m *Marks) Reset() {
+	m.prices = make(map[int32]*MarkPrice)
+}
+
+// e.g. on delisting of the symbol
+func (m *Marks) Remove(symbolID int32) {
+	delete(m.prices, symbolID)
+}
+
+func (m *Marks) Get(symbolID int32) (*MarkPrice, bool) {
+	mark, ok := m.prices[symbolID]
+
+	return mark, ok
+}
+
+/*
+ * Applies an index update, `mid` is the mid price of the book,
+ * 0 if a side is empty: the premium is not sampled then.
+ */
+func (m *Marks) Update(
+	symbolID int32,
+	index int64,
+	mid int64,
+	timestampNS int64,
+) *MarkPrice {
+	mark, ok := m.prices[symbolID]
+
+	if !ok {
+		mark = &MarkPrice{
+			symbolID: symbolID,
+		}
+		m.prices[symbolID] = mark
+	}
+
+	bound := index * m.maxPremiumBP / 10000
+
+	if mid != 0 {
+		premium := clamp(mid-index, bound)
+
+		if mark.samples == 0 {
+			mark.premiumEMA = premium
+		} else {
+			mark.premiumEMA += emaStep(premium-mark.premiumEMA, m.emaPeriods)
+		}
+
+		mark.samples++
+	}
+
+	mark.index = index
+	mark.price = index + clamp(mark.premiumEMA, bound)
+	mark.timestampNS = timestampNS
+
+	return mark
+}
+
+// At least one tick towards the sample, truncation would stall the average.
+func emaStep(diff, periods int64) int64 {
+	step := diff * 2 / (periods + 1)
+
+	if step == 0 && diff > 0 {
+		return 1
+	}
+
+	if step == 0 && diff < 0 {
+		return -1
+	}
+
+	return step
+}
+
+func clamp(v, bound int64) int64 {
+	if v > bound {
+		return bound
+	}
+
+	if v < -bound {
+		return -bound
+	}
+
+	return v
+}
+
+func (m *Marks) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(m.maxPremiumBP, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(m.emaPeriods, out); err != nil {
+		return err
+	}
+
+	symbolIDs := make([]int32, 0, len(m.prices))
+
+	for id := range m.prices {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range symbolIDs {
+		if err := m.prices[id].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Marks) Unmarshal(in *bytes.Buffer) error {
+	maxPremiumBP, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	emaPeriods, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	prices := make(map[int32]*MarkPrice, size)
+
+	for ; size > 0; size-- {
+		mark := &MarkPrice{}
+
+		if err := mark.Unmarshal(in); err != nil {
+			return err
+		}
+
+		prices[mark.symbolID] = mark
+	}
+
+	m.maxPremiumBP = maxPremiumBP
+	m.emaPeriods = emaPeriods
+	m.prices = prices
+
+	return nil
+}
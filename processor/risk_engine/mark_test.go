@@ -0,0 +1,40 @@
+package riskengine
+
+import "testing"
+
+func TestMarkPrice(t *testing.T) {
+	steps := []struct {
+		name  string
+		index int64
+		mid   int64
+		ema   int64
+		price int64
+	}{
+		{name: "first sample", index: 10000, mid: 10020, ema: 20, price: 10020},
+		{name: "premium clamped, half a step towards it", index: 10000, mid: 10200, ema: 35, price: 10035},
+		{name: "empty side, not sampled", index: 10000, mid: 0, ema: 35, price: 10035},
+		{name: "average clamped to the new index", index: 5000, mid: 0, ema: 35, price: 5025},
+		{name: "at least one tick", index: 10000, mid: 10036, ema: 36, price: 10036},
+		{name: "discount", index: 10000, mid: 9000, ema: -7, price: 9993},
+	}
+
+	marks := NewMarks(DefaultMaxPremiumBP, 3)
+
+	for i, step := range steps {
+		mark := marks.Update(1, step.index, step.mid, int64(i))
+
+		if mark.PremiumEMA() != step.ema || mark.Price() != step.price {
+			t.Errorf("%v: ema %v, price %v, want %v, %v", step.name, mark.PremiumEMA(), mark.Price(), step.ema, step.price)
+		}
+
+		if got, ok := marks.Get(1); !ok || got.Index() != step.index || got.TimestampNS() != int64(i) {
+			t.Errorf("%v: mark not recorded", step.name)
+		}
+	}
+
+	marks.Remove(1)
+
+	if _, ok := marks.Get(1); ok {
+		t.Error("removed mark kept")
+	}
+}
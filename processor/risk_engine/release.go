@@ -0,0 +1,61 @@
+package riskengine
+
+import (
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+)
+
+/*
+ * Risk holds of a user on one symbol freed by the orders
+ * leaving the book (cancels, reduces, fills of resting orders).
+ * Accumulated per side so that a mass cancel
+ * releases holds in bulk instead of once per order.
+ */
+type Release struct {
+	userID      int64
+	symbolID    int32
+	askQuantity int64
+	bidQuantity int64
+
+	// sum of price * quantity of released bids
+	// TODO reserved bid price for exchange pairs
+	bidAmount int64
+	_         struct{}
+}
+
+func NewRelease(
+	symbolID int32,
+	change *orderbook.PendingChange,
+) *Release {
+	return &Release{
+		userID:      change.UserID(),
+		symbolID:    symbolID,
+		askQuantity: change.Removed(order.Ask),
+		bidQuantity: change.Removed(order.Bid),
+		bidAmount:   change.RemovedAmount(order.Bid),
+	}
+}
+
+func (r *Release) UserID() int64 {
+	return r.userID
+}
+
+func (r *Release) SymbolID() int32 {
+	return r.symbolID
+}
+
+func (r *Release) AskQuantity() int64 {
+	return r.askQuantity
+}
+
+func (r *Release) BidQuantity() int64 {
+	return r.bidQuantity
+}
+
+func (r *Release) BidAmount() int64 {
+	return r.bidAmount
+}
+
+func (r *Release) IsEmpty() bool {
+	return r.askQuantity == 0 && r.bidQuantity == 0
+}
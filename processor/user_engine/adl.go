@@ -0,0 +1,407 @@
+package userengine
+
+import (
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/symbol"
+	"github.com/xerexchain/matching-engine/user"
+)
+
+// Position of a user closed by auto-deleveraging against a liquidation.
+type ADLNotice struct {
+	userID      int64
+	symbolID    int32
+	action      order.Action // closing action
+	quantity    int64
+	price       int64 // bankruptcy price of the liquidated position
+	timestampNS int64
+	_           struct{}
+}
+
+func (n *ADLNotice) UserID() int64 {
+	return n.userID
+}
+
+func (n *ADLNotice) SymbolID() int32 {
+	return n.symbolID
+}
+
+func (n *ADLNotice) Action() order.Action {
+	return n.action
+}
+
+func (n *ADLNotice) Quantity() int64 {
+	return n.quantity
+}
+
+func (n *ADLNotice) Price() int64 {
+	return n.price
+}
+
+func (n *ADLNotice) TimestampNS() int64 {
+	return n.timestampNS
+}
+
+// Contract of margin requirements of the symbol.
+func marginFuture(symbol_ orderbook.Symbol) (*symbol.FutureContract, bool) {
+	switch s := symbol_.(type) {
+	case *symbol.FutureContract:
+		return s, true
+	case *symbol.PerpetualSwap:
+		future := s.Future()
+
+		return &future, true
+	default:
+		return nil, false
+	}
+}
+
+func opposite(action order.Action) order.Action {
+	if action == order.Bid {
+		return order.Ask
+	}
+
+	return order.Bid
+}
+
+// Profile of the insurance fund taking over liquidated positions, 0 if there is none.
+func (e *Engine) SetInsuranceFund(userID int64) {
+	e.insuranceFundID = userID
+}
+
+func (e *Engine) InsuranceFund() int64 {
+	return e.insuranceFundID
+}
+
+// Notices of auto-deleveraged positions since the last call.
+func (e *Engine) ADLNotices() []*ADLNotice {
+	notices := e.notices
+	e.notices = nil
+
+	return notices
+}
+
+/*
+ * Liquidation of the position of the user at its bankruptcy price,
+ * false if the position is not liquidatable at the mark price.
+ */
+func (e *Engine) Liquidation(
+	userID int64,
+	symbolID int32,
+	orderID int64,
+) (*cmd.Liquidate, bool) {
+	profile, ok := e.users.Get(userID)
+
+	if !ok {
+		return nil, false
+	}
+
+	position_, ok := profile.MarginPositionOf(symbolID)
+
+	if !ok {
+		return nil, false
+	}
+
+	book, ok := e.market.Book(symbolID)
+
+	if !ok {
+		return nil, false
+	}
+
+	future, ok := marginFuture(book.Symbol())
+
+	if !ok {
+		return nil, false
+	}
+
+	mark, ok := e.market.MarkPrice(symbolID)
+
+	if !ok || mark.Price() == 0 {
+		return nil, false
+	}
+
+	balance := e.marginBalance(profile, position_.Currency())
+
+	if !position_.IsLiquidatable(*future, mark, balance) {
+		return nil, false
+	}
+
+	quantity := position_.SignedQuantity()
+	action := order.Ask
+
+	if quantity < 0 {
+		quantity = -quantity
+		action = order.Bid
+	}
+
+	return &cmd.Liquidate{
+		OrderID:  orderID,
+		UserId:   userID,
+		SymbolID: symbolID,
+		Action:   action,
+		Quantity: quantity,
+		Price:    position_.BankruptcyPrice(balance),
+	}, true
+}
+
+/*
+ * Quantity of the liquidation not filled in the book is taken over
+ * by the insurance fund at the bankruptcy price, if its balance covers
+ * the loss at the mark price. Otherwise opposite positions are closed
+ * against it at the bankruptcy price, highest ranked first.
+ * `liquidation` is recomputed from the position before the trades of the command,
+ * nil if it was not liquidatable. The close is capped at `command.Quantity`.
+ */
+func (e *Engine) deleverage(
+	command *cmd.Liquidate,
+	liquidation *cmd.Liquidate,
+) resultcode.ResultCode {
+	if liquidation == nil {
+		return resultcode.RiskInvalidLiquidation
+	}
+
+	profile, ok := e.users.Get(command.UserId)
+
+	if !ok {
+		return resultcode.AuthInvalidUser
+	}
+
+	position_, ok := profile.MarginPositionOf(command.SymbolID)
+
+	if !ok {
+		return resultcode.Success
+	}
+
+	liquidation.Metadata = command.Metadata
+
+	if liquidation.Quantity > command.Quantity {
+		liquidation.Quantity = command.Quantity
+	}
+
+	command = liquidation
+	action := command.Action
+	remaining := command.Quantity - e.filled(command)
+	quantity := position_.SignedQuantity()
+
+	if quantity < 0 {
+		quantity = -quantity
+	}
+
+	if remaining > quantity {
+		remaining = quantity
+	}
+
+	if remaining <= 0 {
+		e.removeIfEmpty(profile, command.SymbolID, command.TimestampNS())
+
+		return resultcode.Success
+	}
+
+	book, ok := e.market.Book(command.SymbolID)
+
+	if !ok {
+		return resultcode.MatchingInvalidOrderBookId
+	}
+
+	future, ok := marginFuture(book.Symbol())
+
+	if !ok {
+		return resultcode.UnsupportedSymbolType
+	}
+
+	mark, ok := e.market.MarkPrice(command.SymbolID)
+
+	if !ok || mark.Price() == 0 {
+		return resultcode.RiskInvalidIndexPrice
+	}
+
+	if e.takeOver(command, profile, action, remaining, mark) {
+		return resultcode.Success
+	}
+
+	for _, candidate := range e.rankADL(command, future, action, mark) {
+		if remaining == 0 {
+			break
+		}
+
+		candidatePosition, _ := candidate.MarginPositionOf(command.SymbolID)
+		quantity := candidatePosition.SignedQuantity()
+
+		if quantity < 0 {
+			quantity = -quantity
+		}
+
+		if quantity > remaining {
+			quantity = remaining
+		}
+
+		if err := candidatePosition.ApplyTrade(opposite(action), quantity, command.Price); err != nil {
+			log.Printf("adl %v: user %v: %v", command.SymbolID, candidate.UserID(), err)
+		}
+
+		if err := position_.ApplyTrade(action, quantity, command.Price); err != nil {
+			log.Printf("adl %v: user %v: %v", command.SymbolID, command.UserId, err)
+		}
+
+		e.removeIfEmpty(candidate, command.SymbolID, command.TimestampNS())
+		remaining -= quantity
+
+		e.notices = append(e.notices, &ADLNotice{
+			userID:      candidate.UserID(),
+			symbolID:    command.SymbolID,
+			action:      opposite(action),
+			quantity:    quantity,
+			price:       command.Price,
+			timestampNS: command.TimestampNS(),
+		})
+	}
+
+	e.removeIfEmpty(profile, command.SymbolID, command.TimestampNS())
+
+	if remaining > 0 {
+		log.Printf("adl %v: user %v: %v not covered", command.SymbolID, command.UserId, remaining)
+
+		return resultcode.RiskLiquidationNotCovered
+	}
+
+	return resultcode.Success
+}
+
+// Quantity of the liquidation order filled in the book.
+func (e *Engine) filled(command *cmd.Liquidate) int64 {
+	var quantity int64
+
+	for _, trade := range e.market.LastTrades()[command.SymbolID] {
+		if trade.TakerOrderID() == command.OrderID && trade.TakerUserID() == command.UserId {
+			quantity += trade.Quantity()
+		}
+	}
+
+	return quantity
+}
+
+// false if there is no insurance fund or its balance does not cover the loss
+func (e *Engine) takeOver(
+	command *cmd.Liquidate,
+	profile *user.Profile,
+	action order.Action, // closing action of the liquidated position
+	quantity int64,
+	mark *riskengine.MarkPrice,
+) bool {
+	if e.insuranceFundID == 0 || e.insuranceFundID == command.UserId {
+		return false
+	}
+
+	fund, ok := e.users.Get(e.insuranceFundID)
+
+	if !ok {
+		return false
+	}
+
+	loss := (command.Price - mark.Price()) * quantity
+
+	if action == order.Bid {
+		loss = -loss
+	}
+
+	position_, _ := profile.MarginPositionOf(command.SymbolID)
+
+	if loss > 0 && fund.Balance(position_.Currency()) < loss {
+		return false
+	}
+
+	fundPosition := fund.MarginPositionOrNew(command.SymbolID, position_.Currency())
+
+	if err := fundPosition.ApplyTrade(opposite(action), quantity, command.Price); err != nil {
+		log.Printf("takeover %v: user %v: %v", command.SymbolID, fund.UserID(), err)
+	}
+
+	if err := position_.ApplyTrade(action, quantity, command.Price); err != nil {
+		log.Printf("takeover %v: user %v: %v", command.SymbolID, command.UserId, err)
+	}
+
+	e.removeIfEmpty(fund, command.SymbolID, command.TimestampNS())
+	e.removeIfEmpty(profile, command.SymbolID, command.TimestampNS())
+
+	return true
+}
+
+/*
+ * Profitable positions on the opposite side of the liquidated one,
+ * ranked by profit times leverage (notional at the mark price over equity).
+ * Ties are ranked by user id.
+ */
+func (e *Engine) rankADL(
+	command *cmd.Liquidate,
+	future *symbol.FutureContract,
+	action order.Action, // closing action of the liquidated position
+	mark *riskengine.MarkPrice,
+) []*user.Profile {
+	var (
+		candidates []*user.Profile
+		scores     []*big.Rat
+	)
+
+	for _, userID := range e.users.UserIDs() {
+		if userID == command.UserId {
+			continue
+		}
+
+		profile, _ := e.users.Get(userID)
+		position_, ok := profile.MarginPositionOf(command.SymbolID)
+
+		if !ok {
+			continue
+		}
+
+		quantity := position_.SignedQuantity()
+
+		// opposite side is long if the liquidated position is closed by a bid
+		if quantity == 0 || (quantity > 0) != (action == order.Bid) {
+			continue
+		}
+
+		profit := position_.EstimateProfit(*future, mark)
+		equity := e.marginBalance(profile, position_.Currency()) + profit
+
+		if profit <= 0 || equity <= 0 {
+			continue
+		}
+
+		if quantity < 0 {
+			quantity = -quantity
+		}
+
+		score := new(big.Rat).SetFrac(
+			new(big.Int).Mul(big.NewInt(profit), big.NewInt(quantity*mark.Price())),
+			big.NewInt(equity),
+		)
+		candidates = append(candidates, profile)
+		scores = append(scores, score)
+	}
+
+	ranks := make([]int, len(candidates))
+
+	for i := range ranks {
+		ranks[i] = i
+	}
+
+	sort.SliceStable(ranks, func(i, j int) bool {
+		return scores[ranks[i]].Cmp(scores[ranks[j]]) > 0
+	})
+
+	ranked := make([]*user.Profile, 0, len(ranks))
+
+	for _, i := range ranks {
+		ranked = append(ranked, candidates[i])
+	}
+
+	return ranked
+}
@@ -0,0 +1,321 @@
+package userengine
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/ledger"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const (
+	// Funding rates are in parts per million.
+	FundingRateScale int64 = 1000000
+
+	// Funding rates kept per symbol.
+	FundingHistorySize = 1000
+)
+
+// Funding settled at `timestampNS`.
+type FundingRate struct {
+	symbolID    int32
+	timestampNS int64
+	rate        int64 // parts per million, positive if longs pay shorts
+	markPrice   int64
+	premium     int64 // average of mark minus index over the interval
+	_           struct{}
+}
+
+func (f *FundingRate) SymbolID() int32 {
+	return f.symbolID
+}
+
+func (f *FundingRate) TimestampNS() int64 {
+	return f.timestampNS
+}
+
+func (f *FundingRate) Rate() int64 {
+	return f.rate
+}
+
+func (f *FundingRate) MarkPrice() int64 {
+	return f.markPrice
+}
+
+func (f *FundingRate) Premium() int64 {
+	return f.premium
+}
+
+// Funding of a perpetual swap.
+type funding struct {
+	premiumSum    int64
+	samples       int64
+	lastFundingNS int64
+	history       []*FundingRate // oldest first
+	_             struct{}
+}
+
+func (e *Engine) fundingOf(symbolID int32) *funding {
+	f, ok := e.funding[symbolID]
+
+	if !ok {
+		f = &funding{}
+		e.funding[symbolID] = f
+	}
+
+	return f
+}
+
+// Premium of the mark price over the index, sampled on index updates.
+func (e *Engine) samplePremium(symbolID int32) {
+	book, ok := e.market.Book(symbolID)
+
+	if !ok {
+		return
+	}
+
+	if _, ok := book.Symbol().(*symbol.PerpetualSwap); !ok {
+		return
+	}
+
+	mark, ok := e.market.MarkPrice(symbolID)
+
+	if !ok || mark.Price() == 0 {
+		return
+	}
+
+	f := e.fundingOf(symbolID)
+	f.premiumSum += mark.Price() - mark.Index()
+	f.samples++
+}
+
+/*
+ * Rate is the average premium over the interval relative to the index,
+ * capped by the max funding rate of the swap.
+ * Each position pays `quantity * mark price * rate`,
+ * longs pay shorts if the rate is positive.
+ * Payments are rounded towards zero, the remainder stays with the exchange.
+ */
+func (e *Engine) settleFunding(
+	command *cmd.Funding,
+) resultcode.ResultCode {
+	book, ok := e.market.Book(command.SymbolID)
+
+	if !ok {
+		return resultcode.MatchingInvalidOrderBookId
+	}
+
+	swap, ok := book.Symbol().(*symbol.PerpetualSwap)
+
+	if !ok {
+		return resultcode.UnsupportedSymbolType
+	}
+
+	mark, ok := e.market.MarkPrice(command.SymbolID)
+
+	if !ok || mark.Price() == 0 {
+		return resultcode.RiskInvalidIndexPrice
+	}
+
+	f := e.fundingOf(command.SymbolID)
+
+	if len(f.history) > 0 && command.TimestampNS() < f.lastFundingNS+swap.FundingIntervalNS() {
+		return resultcode.RiskFundingNotDue
+	}
+
+	var premium int64
+
+	if f.samples > 0 {
+		premium = f.premiumSum / f.samples
+	}
+
+	rate := premium * FundingRateScale / mark.Index()
+
+	if rate > swap.MaxFundingRate() {
+		rate = swap.MaxFundingRate()
+	} else if rate < -swap.MaxFundingRate() {
+		rate = -swap.MaxFundingRate()
+	}
+
+	for _, userID := range e.users.UserIDs() {
+		profile, _ := e.users.Get(userID)
+		position_, ok := profile.MarginPositionOf(command.SymbolID)
+
+		if !ok || position_.SignedQuantity() == 0 {
+			continue
+		}
+
+		payment := position_.SignedQuantity() * mark.Price() * rate / FundingRateScale
+
+		if payment != 0 {
+			profile.AddFunding(command.SymbolID, -payment)
+			e.post(
+				ledger.FundingPayment,
+				profile,
+				swap.SettlementCurrency(),
+				ledger.Funding(command.SymbolID),
+				-payment,
+				command.TimestampNS(),
+			)
+		}
+	}
+
+	if len(f.history) >= FundingHistorySize {
+		f.history = f.history[1:]
+	}
+
+	f.history = append(f.history, &FundingRate{
+		symbolID:    command.SymbolID,
+		timestampNS: command.TimestampNS(),
+		rate:        rate,
+		markPrice:   mark.Price(),
+		premium:     premium,
+	})
+	f.premiumSum = 0
+	f.samples = 0
+	f.lastFundingNS = command.TimestampNS()
+
+	return resultcode.Success
+}
+
+// Last `limit` funding rates of the symbol (all kept if 0), oldest first.
+func (e *Engine) FundingHistory(
+	symbolID int32,
+	limit int32,
+) []*FundingRate {
+	f, ok := e.funding[symbolID]
+
+	if !ok {
+		return nil
+	}
+
+	if limit <= 0 || int(limit) >= len(f.history) {
+		return f.history
+	}
+
+	return f.history[len(f.history)-int(limit):]
+}
+
+func marshalFunding(
+	funding map[int32]*funding,
+	out *bytes.Buffer,
+) error {
+	symbolIDs := make([]int32, 0, len(funding))
+
+	for id := range funding {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range symbolIDs {
+		f := funding[id]
+
+		if err := serialization.WriteInt32(id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(f.premiumSum, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(f.samples, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(f.lastFundingNS, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt32(int32(len(f.history)), out); err != nil {
+			return err
+		}
+
+		for _, rate := range f.history {
+			fields := []int64{
+				rate.timestampNS,
+				rate.rate,
+				rate.markPrice,
+				rate.premium,
+			}
+
+			for _, v := range fields {
+				if err := serialization.WriteInt64(v, out); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func unmarshalFunding(in *bytes.Buffer) (map[int32]*funding, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	funding_ := make(map[int32]*funding, size)
+
+	for ; size > 0; size-- {
+		symbolID, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var fields [3]int64
+
+		for i := range fields {
+			if fields[i], err = serialization.ReadInt64(in); err != nil {
+				return nil, err
+			}
+		}
+
+		historySize, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		history := make([]*FundingRate, 0, historySize)
+
+		for ; historySize > 0; historySize-- {
+			var v [4]int64
+
+			for i := range v {
+				if v[i], err = serialization.ReadInt64(in); err != nil {
+					return nil, err
+				}
+			}
+
+			history = append(history, &FundingRate{
+				symbolID:    symbolID,
+				timestampNS: v[0],
+				rate:        v[1],
+				markPrice:   v[2],
+				premium:     v[3],
+			})
+		}
+
+		funding_[symbolID] = &funding{
+			premiumSum:    fields[0],
+			samples:       fields[1],
+			lastFundingNS: fields[2],
+			history:       history,
+		}
+	}
+
+	return funding_, nil
+}
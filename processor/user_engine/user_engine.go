@@ -0,0 +1,465 @@
+package userengine
+
+import (
+	"bytes"
+	"log"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/ledger"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/position"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+	"github.com/xerexchain/matching-engine/user"
+)
+
+// TODO spot balances of exchange pairs, fees
+// TODO pending holds before matching (R1)
+
+// State of the matching engine used by the user engine, see `matchingengine.Router`.
+type Market interface {
+	Book(symbolID int32) (*orderbook.Naive, bool)
+	MarkPrice(symbolID int32) (*riskengine.MarkPrice, bool)
+	LastTrades() map[int32][]*event.Trade
+	LastHolds() []*riskengine.Hold
+	LastReleases() []*riskengine.Release
+	HasOrders(userID int64) bool
+}
+
+/*
+ * Maintains user profiles: margin positions are updated
+ * from trades and resting orders of the matching engine, funding is settled
+ * and liquidations are deleveraged.
+ * Balance changes are journaled in the ledger.
+ * Commands are processed after the matching engine.
+ */
+type Engine struct {
+	market          Market
+	users           *user.Registry
+	funding         map[int32]*funding
+	insuranceFundID int64
+	ledger          *ledger.Ledger
+	transfers       map[int64]int64 // sending user id -> last applied transfer id
+
+	// drained by `ADLNotices`, not serialized
+	notices []*ADLNotice
+	_       struct{}
+}
+
+func NewEngine(market Market) *Engine {
+	return &Engine{
+		market:    market,
+		users:     user.NewRegistry(),
+		funding:   make(map[int32]*funding),
+		ledger:    ledger.New(),
+		transfers: make(map[int64]int64),
+	}
+}
+
+// Journal of balance changes.
+func (e *Engine) Ledger() *ledger.Ledger {
+	return e.ledger
+}
+
+/*
+ * Reconciles the ledger against balances of active profiles
+ * and assets `held` in external custody (currency -> amount).
+ * Balances of suspended profiles are moved out of the user account, see `adjustBalance`.
+ */
+func (e *Engine) Reconcile(held map[int32]int64) []*ledger.Reconciliation {
+	profiles := make(map[int64]map[int32]int64)
+
+	for _, userID := range e.users.UserIDs() {
+		profile, _ := e.users.Get(userID)
+		profiles[userID] = profile.BalanceReport().Balances()
+	}
+
+	return e.ledger.Reconcile(profiles, held)
+}
+
+func (e *Engine) Users() *user.Registry {
+	return e.users
+}
+
+func (e *Engine) IsSuspended(userID int64) bool {
+	return e.users.IsSuspended(userID)
+}
+
+// Unknown and suspended users can't trade, see `matchingengine.Router.SetUsers`.
+func (e *Engine) IsActive(userID int64) bool {
+	_, ok := e.users.Get(userID)
+
+	return ok
+}
+
+func (e *Engine) IsAdmin(userID int64) bool {
+	return e.users.IsAdmin(userID)
+}
+
+func (e *Engine) BalanceReport(userID int64) (*user.BalanceReport, bool) {
+	profile, ok := e.users.Get(userID)
+
+	if !ok {
+		return nil, false
+	}
+
+	return profile.BalanceReport(), true
+}
+
+/*
+ * `res` is the result of the command in the matching engine.
+ * Success if the command is not a user command.
+ */
+func (e *Engine) Process(
+	command cmd.Command,
+	res *orderbook.MatcherResult,
+) resultcode.ResultCode {
+	var liquidation *cmd.Liquidate
+
+	// checked against positions before the trades of the liquidation
+	if c, ok := command.(*cmd.Liquidate); ok && res.Code == resultcode.Success {
+		liquidation, _ = e.Liquidation(c.UserId, c.SymbolID, c.OrderID)
+	}
+
+	e.applyTrades(command.TimestampNS())
+	e.applyPending(command.TimestampNS())
+
+	switch c := command.(type) {
+	case *cmd.SetIndexPrice:
+		if res.Code != resultcode.Success {
+			return res.Code
+		}
+
+		e.samplePremium(c.SymbolID)
+
+		return resultcode.Success
+	case *cmd.Funding:
+		return e.settleFunding(c)
+	case *cmd.Settle:
+		if res.Code != resultcode.Success {
+			return res.Code
+		}
+
+		e.settle(c)
+
+		return resultcode.Success
+	case *cmd.Liquidate:
+		if res.Code != resultcode.Success {
+			return res.Code
+		}
+
+		return e.deleverage(c, liquidation)
+	case *cmd.BalanceAdj:
+		return e.adjustBalance(c)
+	case *cmd.AddUser:
+		return e.users.AddUser(c.UserId)
+	case *cmd.SetAdmin:
+		return e.users.SetAdmin(c.AdminId, c.UserId, c.Admin)
+	case *cmd.SuspendUser:
+		return e.suspendUser(c)
+	case *cmd.ResumeUser:
+		return e.users.ResumeUser(c.UserId)
+	case *cmd.AddSubAccount:
+		return e.users.AddSubAccount(c.UserId, c.ParentId, c.SharedMargin)
+	case *cmd.Transfer:
+		return e.transfer(c)
+	case *cmd.Reset:
+		e.users = user.NewRegistry()
+		e.funding = make(map[int32]*funding)
+		e.ledger.Reset()
+		e.transfers = make(map[int64]int64)
+		e.notices = nil
+
+		return resultcode.Success
+	default:
+		return resultcode.Success
+	}
+}
+
+// Currency of margin and profit, false if the symbol is not traded on margin.
+func marginCurrency(symbol_ orderbook.Symbol) (int32, bool) {
+	switch s := symbol_.(type) {
+	case *symbol.FutureContract:
+		return s.QuoteCurrency(), true
+	case *symbol.PerpetualSwap:
+		return s.SettlementCurrency(), true
+	default:
+		return 0, false
+	}
+}
+
+func (e *Engine) applyTrades(timestampNS int64) {
+	trades := e.market.LastTrades()
+	symbolIDs := make([]int32, 0, len(trades))
+
+	for id := range trades {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	for _, symbolID := range symbolIDs {
+		book, ok := e.market.Book(symbolID)
+
+		if !ok {
+			continue
+		}
+
+		currency, ok := marginCurrency(book.Symbol())
+
+		if !ok {
+			continue
+		}
+
+		for _, trade := range trades[symbolID] {
+			takerAction := order.Bid
+
+			if trade.MakerAction() == order.Bid {
+				takerAction = order.Ask
+			}
+
+			e.applyTrade(symbolID, currency, trade.MakerUserID(), trade.MakerAction(), trade, timestampNS)
+			e.applyTrade(symbolID, currency, trade.TakerUserID(), takerAction, trade, timestampNS)
+		}
+	}
+}
+
+func (e *Engine) applyTrade(
+	symbolID int32,
+	currency int32,
+	userID int64,
+	action order.Action,
+	trade *event.Trade,
+	timestampNS int64,
+) {
+	profile, ok := e.users.Get(userID)
+
+	if !ok {
+		log.Printf("trade %v: unknown user %v", trade.TradeID(), userID)
+
+		return
+	}
+
+	position_ := profile.MarginPositionOrNew(symbolID, currency)
+
+	if err := position_.ApplyTrade(action, trade.Quantity(), trade.Price()); err != nil {
+		log.Printf("trade %v: %v", trade.TradeID(), err)
+	}
+
+	e.removeIfEmpty(profile, symbolID, timestampNS)
+}
+
+/*
+ * Pending quantities of margin positions follow the orders resting in the books,
+ * positions are created by the first resting order.
+ */
+func (e *Engine) applyPending(timestampNS int64) {
+	for _, hold := range e.market.LastHolds() {
+		if position_, profile, ok := e.pendingPosition(hold.UserID(), hold.SymbolID()); ok {
+			position_.PendingHoldAll(hold)
+			e.removeIfEmpty(profile, hold.SymbolID(), timestampNS)
+		}
+	}
+
+	for _, release := range e.market.LastReleases() {
+		if position_, profile, ok := e.pendingPosition(release.UserID(), release.SymbolID()); ok {
+			position_.PendingReleaseAll(release)
+			e.removeIfEmpty(profile, release.SymbolID(), timestampNS)
+		}
+	}
+}
+
+// false if the symbol is not traded on margin or the user is unknown
+func (e *Engine) pendingPosition(
+	userID int64,
+	symbolID int32,
+) (*position.Margin, *user.Profile, bool) {
+	book, ok := e.market.Book(symbolID)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	currency, ok := marginCurrency(book.Symbol())
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	profile, ok := e.users.Get(userID)
+
+	if !ok {
+		log.Printf("pending %v: unknown user %v", symbolID, userID)
+
+		return nil, nil, false
+	}
+
+	return profile.MarginPositionOrNew(symbolID, currency), profile, true
+}
+
+/*
+ * Changes the balance of the user by `amount` (negative if debited),
+ * journaled against the `counterparty` account.
+ */
+func (e *Engine) post(
+	kind ledger.EntryType,
+	profile *user.Profile,
+	currency int32,
+	counterparty ledger.Account,
+	amount int64,
+	timestampNS int64,
+) {
+	if amount == 0 {
+		return
+	}
+
+	from, to := counterparty, ledger.User(profile.UserID())
+
+	if amount < 0 {
+		from, to = to, from
+	}
+
+	if _, err := e.ledger.Post(kind, currency, from, to, abs(amount), timestampNS); err != nil {
+		log.Printf("post %v: user %v: %v", kind, profile.UserID(), err)
+
+		return
+	}
+
+	profile.AddBalance(currency, amount)
+}
+
+/*
+ * Realized profit of a removed position is cleared against the symbol.
+ * A negative balance of a sub-account sharing margin is covered by the parent.
+ */
+func (e *Engine) removeIfEmpty(
+	profile *user.Profile,
+	symbolID int32,
+	timestampNS int64,
+) {
+	currency, profit, ok := profile.RemoveIfEmpty(symbolID)
+
+	if !ok {
+		return
+	}
+
+	e.post(ledger.Trade, profile, currency, ledger.Clearing(symbolID), profit, timestampNS)
+
+	if profile.SharedMargin() && profile.Balance(currency) < 0 {
+		e.coverFromParent(profile, currency, timestampNS)
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+/*
+ * Closes positions of the delisted future at the settlement price,
+ * profit is realized into balances.
+ * Pending holds are dropped, resting orders are cancelled by the matching engine.
+ */
+func (e *Engine) settle(command *cmd.Settle) {
+	for _, userID := range e.users.UserIDs() {
+		profile, _ := e.users.Get(userID)
+		position_, ok := profile.MarginPositionOf(command.SymbolID)
+
+		if !ok {
+			continue
+		}
+
+		quantity := position_.SignedQuantity()
+		action := order.Ask
+
+		if quantity < 0 {
+			quantity = -quantity
+			action = order.Bid
+		}
+
+		if quantity > 0 {
+			if _, err := position_.CloseCurrentPositionFutures(action, quantity, command.Price); err != nil {
+				log.Printf("settle %v: user %v: %v", command.SymbolID, userID, err)
+			}
+		}
+
+		position_.Reset()
+		e.removeIfEmpty(profile, command.SymbolID, command.TimestampNS())
+	}
+}
+
+func (e *Engine) Marshal(out *bytes.Buffer) error {
+	if err := e.users.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := marshalFunding(e.funding, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(e.insuranceFundID, out); err != nil {
+		return err
+	}
+
+	if err := e.ledger.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := marshalTransfers(e.transfers, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The market is not serialized.
+func (e *Engine) Unmarshal(in *bytes.Buffer) error {
+	users := user.NewRegistry()
+
+	if err := users.Unmarshal(in); err != nil {
+		return err
+	}
+
+	funding, err := unmarshalFunding(in)
+
+	if err != nil {
+		return err
+	}
+
+	insuranceFundID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	ledger_ := ledger.New()
+
+	if err := ledger_.Unmarshal(in); err != nil {
+		return err
+	}
+
+	transfers, err := unmarshalTransfers(in)
+
+	if err != nil {
+		return err
+	}
+
+	e.users = users
+	e.funding = funding
+	e.insuranceFundID = insuranceFundID
+	e.ledger = ledger_
+	e.transfers = transfers
+	e.notices = nil
+
+	return nil
+}
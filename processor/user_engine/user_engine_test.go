@@ -0,0 +1,1075 @@
+package userengine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	matchingengine "github.com/xerexchain/matching-engine/processor/matching_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const (
+	future   int32 = 1
+	currency int32 = 840
+)
+
+// Future contract of `currency` with margin 10 per lot on both sides.
+func newFuture(
+	t *testing.T,
+	symbolID int32,
+	expiryNS int64,
+) *symbol.FutureContract {
+	out := &bytes.Buffer{}
+
+	for _, code := range []int8{2, 1} {
+		if err := serialization.WriteInt8(code, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int32{symbolID, 1, currency} {
+		if err := serialization.WriteInt32(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// scales, fees, margins, expiry, last trading
+	for _, v := range []int64{1, 1, 0, 0, 10, 10, expiryNS, expiryNS} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := symbol.Unmarshal(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*symbol.FutureContract)
+}
+
+// Exchange pair of `currency` without fees.
+func newPair(t *testing.T, symbolID int32) *symbol.Symbol {
+	out := &bytes.Buffer{}
+
+	if err := serialization.WriteInt8(1, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []int32{symbolID, 1, currency} {
+		if err := serialization.WriteInt32(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// scales, fees
+	for _, v := range []int64{1, 1, 0, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := symbol.Unmarshal(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*symbol.Symbol)
+}
+
+type harness struct {
+	t      *testing.T
+	router *matchingengine.Router
+	engine *Engine
+}
+
+// Users are created with `balance` in `currency`.
+func newHarness(
+	t *testing.T,
+	balance int64,
+	userIDs ...int64,
+) *harness {
+	h := &harness{
+		t:      t,
+		router: matchingengine.NewRouter(),
+	}
+	h.engine = NewEngine(h.router)
+	h.router.SetUsers(h.engine)
+
+	h.addBook(newFuture(t, future, 0))
+
+	for _, userID := range userIDs {
+		h.mustProcess(&cmd.AddUser{UserId: userID})
+
+		if balance != 0 {
+			h.mustProcess(&cmd.BalanceAdj{UserId: userID, Currency: currency, Amount: balance, TXID: 1})
+		}
+	}
+
+	return h
+}
+
+func (h *harness) addBook(symbol_ orderbook.Symbol) {
+	h.t.Helper()
+
+	if code := h.router.AddBook(orderbook.NewNaive(symbol_)); code != resultcode.Success {
+		h.t.Fatalf("add book %v: %v", symbol_.ID(), code)
+	}
+}
+
+// Codes of the matching engine and of the user engine.
+func (h *harness) process(
+	command cmd.Command,
+) (resultcode.ResultCode, resultcode.ResultCode) {
+	res := h.router.Process(command)
+
+	return res.Code, h.engine.Process(command, res)
+}
+
+func (h *harness) mustProcess(command cmd.Command) {
+	h.t.Helper()
+	matchingCode, userCode := h.process(command)
+
+	if matchingCode != resultcode.Success {
+		h.t.Fatalf("%T: matching engine: %v", command, matchingCode)
+	}
+
+	if userCode != resultcode.Success {
+		h.t.Fatalf("%T: user engine: %v", command, userCode)
+	}
+}
+
+func gtc(
+	orderID int64,
+	userID int64,
+	action order.Action,
+	price int64,
+	quantity int64,
+) *order.Place {
+	return order.NewPlace(orderID, userID, price, quantity, price, future, 0, action, order.GTC)
+}
+
+func gtcIn(
+	symbolID int32,
+	orderID int64,
+	userID int64,
+	action order.Action,
+	price int64,
+	quantity int64,
+) *order.Place {
+	return order.NewPlace(orderID, userID, price, quantity, price, symbolID, 0, action, order.GTC)
+}
+
+// Signed position quantity of the user in the symbol.
+func (h *harness) quantity(
+	userID int64,
+	symbolID int32,
+) int64 {
+	profile, ok := h.engine.Users().Get(userID)
+
+	if !ok {
+		h.t.Fatalf("unknown user %v", userID)
+	}
+
+	position_, ok := profile.MarginPositionOf(symbolID)
+
+	if !ok {
+		return 0
+	}
+
+	return position_.SignedQuantity()
+}
+
+// Signed position quantity, pending asks and pending bids of the user.
+func (h *harness) position(userID int64) (int64, int64, int64) {
+	profile, ok := h.engine.Users().Get(userID)
+
+	if !ok {
+		h.t.Fatalf("unknown user %v", userID)
+	}
+
+	position_, ok := profile.MarginPositionOf(future)
+
+	if !ok {
+		return 0, 0, 0
+	}
+
+	return position_.SignedQuantity(), position_.Pending(order.Ask), position_.Pending(order.Bid)
+}
+
+func (h *harness) balance(userID int64) int64 {
+	profile, ok := h.engine.Users().Get(userID)
+
+	if !ok {
+		h.t.Fatalf("unknown user %v", userID)
+	}
+
+	return profile.Balance(currency)
+}
+
+func TestPendingHolds(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []cmd.Command
+		want     map[int64][3]int64 // userID -> position, pending asks, pending bids
+	}{
+		{
+			name: "resting orders are held",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 10),
+				gtc(2, 1, order.Ask, 110, 3),
+			},
+			want: map[int64][3]int64{1: {0, 3, 10}},
+		},
+		{
+			name: "fills release the maker and are not held for the taker",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 10),
+				gtc(2, 2, order.Ask, 100, 4),
+			},
+			want: map[int64][3]int64{1: {4, 0, 6}, 2: {-4, 0, 0}},
+		},
+		{
+			name: "rest of a partially filled taker is held",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 4),
+				gtc(2, 2, order.Ask, 100, 10),
+			},
+			want: map[int64][3]int64{1: {4, 0, 0}, 2: {-4, 6, 0}},
+		},
+		{
+			name: "mass cancel releases all orders",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 10),
+				gtc(2, 1, order.Bid, 99, 5),
+				gtc(3, 1, order.Ask, 110, 3),
+				order.NewMassCancel(1, 0, 0),
+			},
+			want: map[int64][3]int64{1: {0, 0, 0}},
+		},
+		{
+			name: "mass cancel of one side",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 10),
+				gtc(2, 1, order.Ask, 110, 3),
+				order.NewMassCancel(1, future, order.Bid),
+			},
+			want: map[int64][3]int64{1: {0, 3, 0}},
+		},
+		{
+			name: "mass quote replaces held levels",
+			commands: []cmd.Command{
+				order.NewMassQuote(1, 0, 1, order.NewLadder(
+					future,
+					order.NewQuote(order.Bid, 100, 10),
+					order.NewQuote(order.Ask, 110, 10),
+				)),
+				order.NewMassQuote(1, 0, 3, order.NewLadder(
+					future,
+					order.NewQuote(order.Bid, 99, 4),
+					order.NewQuote(order.Ask, 111, 5),
+				)),
+			},
+			want: map[int64][3]int64{1: {0, 5, 4}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 10000, 1, 2)
+
+			for _, command := range test.commands {
+				h.mustProcess(command)
+			}
+
+			for userID, want := range test.want {
+				quantity, asks, bids := h.position(userID)
+
+				if got := [3]int64{quantity, asks, bids}; got != want {
+					t.Errorf("user %v: position, pending asks, pending bids %v, want %v", userID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFunding(t *testing.T) {
+	const swap int32 = 2
+
+	tests := []struct {
+		name     string
+		maxRate  int64
+		bid, ask int64 // book of user 3 at the index update
+		balances map[int64]int64
+	}{
+		{
+			name:     "longs pay shorts, rounded towards zero",
+			maxRate:  FundingRateScale,
+			bid:      10010,
+			ask:      10030,
+			balances: map[int64]int64{1: 99900, 2: 100100, 3: 100000},
+		},
+		{
+			name:     "shorts pay longs at the lower mark price",
+			maxRate:  FundingRateScale,
+			bid:      9970,
+			ask:      9990,
+			balances: map[int64]int64{1: 100099, 2: 99901, 3: 100000},
+		},
+		{
+			name:     "rate is capped",
+			maxRate:  1000,
+			bid:      10010,
+			ask:      10030,
+			balances: map[int64]int64{1: 99950, 2: 100050, 3: 100000},
+		},
+		{
+			name:     "no premium without a two-sided book",
+			maxRate:  FundingRateScale,
+			bid:      10010,
+			balances: map[int64]int64{1: 100000, 2: 100000, 3: 100000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 100000, 1, 2, 3)
+			h.addBook(symbol.NewPerpetualSwap(newFuture(t, swap, 0), 1000, test.maxRate))
+
+			h.mustProcess(gtcIn(swap, 1, 1, order.Bid, 10000, 5))
+			h.mustProcess(gtcIn(swap, 2, 2, order.Ask, 10000, 5))
+			h.mustProcess(gtcIn(swap, 3, 3, order.Bid, test.bid, 1))
+
+			if test.ask != 0 {
+				h.mustProcess(gtcIn(swap, 4, 3, order.Ask, test.ask, 1))
+			}
+
+			h.mustProcess(&cmd.SetIndexPrice{SymbolID: swap, Price: 10000})
+			h.mustProcess(&cmd.Funding{SymbolID: swap})
+
+			for userID, want := range test.balances {
+				if got := h.balance(userID); got != want {
+					t.Errorf("user %v: balance %v, want %v", userID, got, want)
+				}
+			}
+
+			if got := h.quantity(1, swap); got != 5 {
+				t.Errorf("user 1: position %v, want 5", got)
+			}
+		})
+	}
+}
+
+func TestPremiumSamples(t *testing.T) {
+	const swap int32 = 2
+
+	h := newHarness(t, 100000, 1)
+	h.addBook(symbol.NewPerpetualSwap(newFuture(t, swap, 0), 1000, FundingRateScale))
+	h.mustProcess(gtcIn(swap, 1, 1, order.Bid, 10010, 1))
+	h.mustProcess(gtcIn(swap, 2, 1, order.Ask, 10030, 1))
+	h.mustProcess(&cmd.SetIndexPrice{SymbolID: swap, Price: 10000})
+
+	if _, code := h.process(&cmd.SetIndexPrice{SymbolID: swap, Price: 0}); code != resultcode.RiskInvalidIndexPrice {
+		t.Fatalf("code %v, want %v", code, resultcode.RiskInvalidIndexPrice)
+	}
+
+	if samples := h.engine.fundingOf(swap).samples; samples != 1 {
+		t.Errorf("%v premium samples, want 1", samples)
+	}
+}
+
+func TestSettle(t *testing.T) {
+	const expiring int32 = 3
+
+	tests := []struct {
+		name     string
+		price    int64
+		balances map[int64]int64
+	}{
+		{
+			name:     "long gains",
+			price:    1100,
+			balances: map[int64]int64{1: 10500, 2: 9500},
+		},
+		{
+			name:     "short gains",
+			price:    950,
+			balances: map[int64]int64{1: 9750, 2: 10250},
+		},
+		{
+			name:     "at the open price",
+			price:    1000,
+			balances: map[int64]int64{1: 10000, 2: 10000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 10000, 1, 2)
+			h.addBook(newFuture(t, expiring, 100))
+
+			h.mustProcess(gtcIn(expiring, 1, 1, order.Bid, 1000, 5))
+			h.mustProcess(gtcIn(expiring, 2, 2, order.Ask, 1000, 5))
+			// rests until the settlement
+			h.mustProcess(gtcIn(expiring, 3, 1, order.Bid, 900, 2))
+			h.mustProcess(&cmd.Settle{
+				SymbolID: expiring,
+				Price:    test.price,
+				Metadata: cmd.Metadata{TimestampNs: 100},
+			})
+
+			for userID, want := range test.balances {
+				if got := h.balance(userID); got != want {
+					t.Errorf("user %v: balance %v, want %v", userID, got, want)
+				}
+
+				if profile, _ := h.engine.Users().Get(userID); profile.HasPositions() {
+					t.Errorf("user %v: position not removed", userID)
+				}
+			}
+
+			for _, r := range h.engine.Reconcile(map[int32]int64{currency: 20000}) {
+				if !r.IsBalanced() {
+					t.Errorf("currency %v: discrepancy %v, %v mismatches", r.Currency(), r.Discrepancy(), len(r.Mismatches()))
+				}
+			}
+		})
+	}
+}
+
+/*
+ * User 1 is long 10 at 1000 with a balance of 200 and margin 100,
+ * liquidatable below 990 with the bankruptcy price 980.
+ */
+func TestLiquidation(t *testing.T) {
+	type notice struct {
+		userID   int64
+		quantity int64
+		price    int64
+	}
+
+	tests := []struct {
+		name          string
+		index         int64
+		commands      []cmd.Command // before the liquidation
+		liquidate     cmd.Liquidate
+		insuranceFund int64
+		matchingCode  resultcode.ResultCode
+		userCode      resultcode.ResultCode
+		balances      map[int64]int64
+		positions     map[int64]int64
+		notices       []notice
+	}{
+		{
+			name:         "deleveraged at the recomputed bankruptcy price",
+			index:        985,
+			liquidate:    cmd.Liquidate{Action: order.Bid, Quantity: 10, Price: 1},
+			matchingCode: resultcode.Success,
+			userCode:     resultcode.Success,
+			balances:     map[int64]int64{1: 0, 2: 400},
+			positions:    map[int64]int64{1: 0, 2: 0},
+			notices:      []notice{{2, 10, 980}},
+		},
+		{
+			name:         "close is capped at the command",
+			index:        985,
+			liquidate:    cmd.Liquidate{Action: order.Ask, Quantity: 4, Price: 980},
+			matchingCode: resultcode.Success,
+			userCode:     resultcode.Success,
+			balances:     map[int64]int64{1: 200, 2: 200},
+			positions:    map[int64]int64{1: 6, 2: -6},
+			notices:      []notice{{2, 4, 980}},
+		},
+		{
+			name:  "filled in the book first",
+			index: 985,
+			commands: []cmd.Command{
+				gtc(3, 3, order.Bid, 982, 4),
+			},
+			liquidate:    cmd.Liquidate{Action: order.Ask, Quantity: 10, Price: 980},
+			matchingCode: resultcode.Success,
+			userCode:     resultcode.Success,
+			balances:     map[int64]int64{1: 8, 2: 200, 3: 200},
+			positions:    map[int64]int64{1: 0, 2: -4, 3: 4},
+			notices:      []notice{{2, 6, 980}},
+		},
+		{
+			name:          "taken over by the insurance fund",
+			index:         985,
+			liquidate:     cmd.Liquidate{Action: order.Ask, Quantity: 10, Price: 980},
+			insuranceFund: 3,
+			matchingCode:  resultcode.Success,
+			userCode:      resultcode.Success,
+			balances:      map[int64]int64{1: 0, 2: 200, 3: 200},
+			positions:     map[int64]int64{1: 0, 2: -10, 3: 10},
+		},
+		{
+			name:         "not liquidatable",
+			index:        995,
+			liquidate:    cmd.Liquidate{Action: order.Ask, Quantity: 10, Price: 980},
+			matchingCode: resultcode.RiskInvalidLiquidation,
+			userCode:     resultcode.RiskInvalidLiquidation,
+			balances:     map[int64]int64{1: 200, 2: 200},
+			positions:    map[int64]int64{1: 10, 2: -10},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 200, 1, 2, 3)
+			h.engine.SetInsuranceFund(test.insuranceFund)
+
+			h.mustProcess(gtc(1, 1, order.Bid, 1000, 10))
+			h.mustProcess(gtc(2, 2, order.Ask, 1000, 10))
+
+			for _, command := range test.commands {
+				h.mustProcess(command)
+			}
+
+			h.mustProcess(&cmd.SetIndexPrice{SymbolID: future, Price: test.index})
+
+			liquidate := test.liquidate
+			liquidate.OrderID = 100
+			liquidate.UserId = 1
+			liquidate.SymbolID = future
+			matchingCode, userCode := h.process(&liquidate)
+
+			if matchingCode != test.matchingCode || userCode != test.userCode {
+				t.Fatalf("codes %v %v, want %v %v", matchingCode, userCode, test.matchingCode, test.userCode)
+			}
+
+			for userID, want := range test.balances {
+				if got := h.balance(userID); got != want {
+					t.Errorf("user %v: balance %v, want %v", userID, got, want)
+				}
+			}
+
+			for userID, want := range test.positions {
+				if got := h.quantity(userID, future); got != want {
+					t.Errorf("user %v: position %v, want %v", userID, got, want)
+				}
+			}
+
+			notices := h.engine.ADLNotices()
+
+			if len(notices) != len(test.notices) {
+				t.Fatalf("%v notices, want %v", len(notices), len(test.notices))
+			}
+
+			for i, n := range notices {
+				if got := (notice{n.UserID(), n.Quantity(), n.Price()}); got != test.notices[i] {
+					t.Errorf("notice %v: %v, want %v", i, got, test.notices[i])
+				}
+			}
+		})
+	}
+}
+
+/*
+ * Users have a balance of 1000, user 1 is long 10 at 1000 with margin 100.
+ * Unrealized losses at the mark price reduce the free balance, profits don't.
+ */
+func TestAdjustment(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []cmd.Command // after the trade
+		adjust   cmd.BalanceAdj
+		code     resultcode.ResultCode
+		balance  int64
+	}{
+		{
+			name:    "deposit",
+			adjust:  cmd.BalanceAdj{UserId: 1, Amount: 50, TXID: 2},
+			code:    resultcode.Success,
+			balance: 1050,
+		},
+		{
+			name:    "withdrawal of the free balance",
+			adjust:  cmd.BalanceAdj{UserId: 1, Amount: -900, TXID: 2},
+			code:    resultcode.Success,
+			balance: 100,
+		},
+		{
+			name:    "withdrawal of margin",
+			adjust:  cmd.BalanceAdj{UserId: 1, Amount: -901, TXID: 2},
+			code:    resultcode.UserMGMTAccountBalanceAdjustmentNSF,
+			balance: 1000,
+		},
+		{
+			name:     "unrealized loss is not free",
+			commands: []cmd.Command{&cmd.SetIndexPrice{SymbolID: future, Price: 990}},
+			adjust:   cmd.BalanceAdj{UserId: 1, Amount: -801, TXID: 2},
+			code:     resultcode.UserMGMTAccountBalanceAdjustmentNSF,
+			balance:  1000,
+		},
+		{
+			name:     "unrealized profit is not free",
+			commands: []cmd.Command{&cmd.SetIndexPrice{SymbolID: future, Price: 1010}},
+			adjust:   cmd.BalanceAdj{UserId: 1, Amount: -901, TXID: 2},
+			code:     resultcode.UserMGMTAccountBalanceAdjustmentNSF,
+			balance:  1000,
+		},
+		{
+			name: "shortfall of a sub-account sharing margin",
+			commands: []cmd.Command{
+				&cmd.AddSubAccount{UserId: 3, ParentId: 2, SharedMargin: true},
+				gtc(3, 3, order.Bid, 1000, 10),
+				gtc(4, 1, order.Ask, 1000, 10),
+				&cmd.SetIndexPrice{SymbolID: future, Price: 990},
+			},
+			adjust:  cmd.BalanceAdj{UserId: 2, Amount: -801, TXID: 2},
+			code:    resultcode.UserMGMTAccountBalanceAdjustmentNSF,
+			balance: 1000,
+		},
+		{
+			name:    "same transaction id",
+			adjust:  cmd.BalanceAdj{UserId: 1, Amount: 50, TXID: 1},
+			code:    resultcode.UserMGMTAccountBalanceAdjustmentAlreadyAppliedSame,
+			balance: 1000,
+		},
+		{
+			name: "lower transaction id",
+			commands: []cmd.Command{
+				&cmd.BalanceAdj{UserId: 1, Currency: currency, Amount: -100, TXID: 3},
+			},
+			adjust:  cmd.BalanceAdj{UserId: 1, Amount: 50, TXID: 2},
+			code:    resultcode.UserMGMTAccountBalanceAdjustmentAlreadyAppliedMany,
+			balance: 900,
+		},
+		{
+			name:    "zero amount",
+			adjust:  cmd.BalanceAdj{UserId: 1, TXID: 2},
+			code:    resultcode.UserMGMTAccountBalanceAdjustmentZero,
+			balance: 1000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 1000, 1, 2)
+
+			h.mustProcess(gtc(1, 1, order.Bid, 1000, 10))
+			h.mustProcess(gtc(2, 2, order.Ask, 1000, 10))
+
+			for _, command := range test.commands {
+				h.mustProcess(command)
+			}
+
+			adjust := test.adjust
+			adjust.Currency = currency
+
+			if _, code := h.process(&adjust); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+
+			if got := h.balance(adjust.UserId); got != test.balance {
+				t.Errorf("balance %v, want %v", got, test.balance)
+			}
+		})
+	}
+}
+
+func TestSuspend(t *testing.T) {
+	const pair int32 = 4
+
+	tests := []struct {
+		name     string
+		commands []cmd.Command
+		code     resultcode.ResultCode
+	}{
+		{
+			name: "empty account",
+			code: resultcode.Success,
+		},
+		{
+			name:     "resting order of an exchange pair",
+			commands: []cmd.Command{gtcIn(pair, 1, 1, order.Bid, 100, 1)},
+			code:     resultcode.UserMGMTUserNotSuspendableHasOrders,
+		},
+		{
+			name:     "resting order of a future",
+			commands: []cmd.Command{gtc(1, 1, order.Bid, 100, 1)},
+			code:     resultcode.UserMGMTUserNotSuspendableHasOrders,
+		},
+		{
+			name: "orders cancelled",
+			commands: []cmd.Command{
+				gtcIn(pair, 1, 1, order.Bid, 100, 1),
+				gtc(2, 1, order.Bid, 100, 1),
+				order.NewMassCancel(1, 0, 0),
+			},
+			code: resultcode.Success,
+		},
+		{
+			name: "open position",
+			commands: []cmd.Command{
+				gtc(1, 1, order.Bid, 100, 1),
+				gtc(2, 2, order.Ask, 100, 1),
+			},
+			code: resultcode.UserMGMTUserNotSuspendableHasPositions,
+		},
+		{
+			name:     "balance",
+			commands: []cmd.Command{&cmd.BalanceAdj{UserId: 1, Currency: currency, Amount: 1, TXID: 1}},
+			code:     resultcode.UserMGMTUserNotSuspendableNonEmptyAccounts,
+		},
+		{
+			name:     "already suspended",
+			commands: []cmd.Command{&cmd.SuspendUser{UserId: 1}},
+			code:     resultcode.UserMGMTUserAlreadySuspended,
+		},
+		{
+			name:     "master account with an active sub-account",
+			commands: []cmd.Command{&cmd.AddSubAccount{UserId: 3, ParentId: 1}},
+			code:     resultcode.UserMGMTUserNotSuspendableHasSubAccounts,
+		},
+		{
+			name: "master account with a suspended sub-account",
+			commands: []cmd.Command{
+				&cmd.AddSubAccount{UserId: 3, ParentId: 1},
+				&cmd.SuspendUser{UserId: 3},
+			},
+			code: resultcode.Success,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 0, 1, 2)
+			h.addBook(newPair(t, pair))
+
+			for _, command := range test.commands {
+				h.mustProcess(command)
+			}
+
+			if _, code := h.process(&cmd.SuspendUser{UserId: 1}); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+
+			if suspended := test.code == resultcode.Success || test.code == resultcode.UserMGMTUserAlreadySuspended; h.engine.IsSuspended(1) != suspended {
+				t.Errorf("suspended %v, want %v", h.engine.IsSuspended(1), suspended)
+			}
+		})
+	}
+}
+
+func TestSetAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []cmd.Command
+		command  *cmd.SetAdmin
+		code     resultcode.ResultCode
+	}{
+		{
+			name:    "first admin",
+			command: &cmd.SetAdmin{UserId: 1, AdminId: 2, Admin: true},
+			code:    resultcode.Success,
+		},
+		{
+			name:     "granted by an admin",
+			commands: []cmd.Command{&cmd.SetAdmin{UserId: 2, Admin: true}},
+			command:  &cmd.SetAdmin{UserId: 1, AdminId: 2, Admin: true},
+			code:     resultcode.Success,
+		},
+		{
+			name:     "granted by a user",
+			commands: []cmd.Command{&cmd.SetAdmin{UserId: 2, Admin: true}},
+			command:  &cmd.SetAdmin{UserId: 1, AdminId: 1, Admin: true},
+			code:     resultcode.AuthNotAdmin,
+		},
+		{
+			name: "granted by a suspended admin",
+			commands: []cmd.Command{
+				&cmd.SetAdmin{UserId: 2, Admin: true},
+				&cmd.SuspendUser{UserId: 2},
+			},
+			command: &cmd.SetAdmin{UserId: 1, AdminId: 2, Admin: true},
+			code:    resultcode.AuthNotAdmin,
+		},
+		{
+			name:     "revoked by a user",
+			commands: []cmd.Command{&cmd.SetAdmin{UserId: 2, Admin: true}},
+			command:  &cmd.SetAdmin{UserId: 2, AdminId: 1},
+			code:     resultcode.AuthNotAdmin,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 0, 1, 2)
+
+			for _, command := range test.commands {
+				h.mustProcess(command)
+			}
+
+			if _, code := h.process(test.command); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+
+			// rejected commands leave the role as it was
+			if want := test.command.Admin == (test.code == resultcode.Success); h.engine.IsAdmin(test.command.UserId) != want {
+				t.Errorf("admin %v, want %v", h.engine.IsAdmin(test.command.UserId), want)
+			}
+		})
+	}
+}
+
+func TestOrdersOfInactiveUsers(t *testing.T) {
+	h := newHarness(t, 0, 1, 2)
+	h.mustProcess(&cmd.SuspendUser{UserId: 2})
+
+	tests := []struct {
+		name   string
+		userID int64
+		code   resultcode.ResultCode
+	}{
+		{name: "active", userID: 1, code: resultcode.Success},
+		{name: "suspended", userID: 2, code: resultcode.AuthInvalidUser},
+		{name: "unregistered", userID: 3, code: resultcode.AuthInvalidUser},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code, _ := h.process(gtc(int64(i+1), test.userID, order.Bid, 100, 1)); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+		})
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	type step struct {
+		command  cmd.Command
+		code     resultcode.ResultCode
+		snapshot bool // engine is restored from a snapshot before the command
+	}
+
+	transfer := func(transferID int64, fromUserID int64, toUserID int64, amount int64) *cmd.Transfer {
+		return &cmd.Transfer{
+			TransferID: transferID,
+			FromUserId: fromUserID,
+			ToUserId:   toUserID,
+			Currency:   currency,
+			Amount:     amount,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		steps    []step
+		balances [2]int64 // users 1 and 2
+	}{
+		{
+			name:     "transfer",
+			steps:    []step{{command: transfer(1, 1, 2, 300), code: resultcode.Success}},
+			balances: [2]int64{700, 1300},
+		},
+		{
+			name: "same transfer id",
+			steps: []step{
+				{command: transfer(1, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(1, 1, 2, 100), code: resultcode.UserMGMTTransferAlreadyApplied},
+			},
+			balances: [2]int64{900, 1100},
+		},
+		{
+			name: "lower transfer id",
+			steps: []step{
+				{command: transfer(1, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(2, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(1, 1, 2, 100), code: resultcode.UserMGMTTransferAlreadyApplied},
+			},
+			balances: [2]int64{800, 1200},
+		},
+		{
+			name: "skipped transfer id",
+			steps: []step{
+				{command: transfer(2, 1, 2, 100), code: resultcode.UserMGMTTransferOutOfSequence},
+				{command: transfer(1, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(3, 1, 2, 100), code: resultcode.UserMGMTTransferOutOfSequence, snapshot: true},
+				{command: transfer(2, 1, 2, 100), code: resultcode.Success},
+			},
+			balances: [2]int64{800, 1200},
+		},
+		{
+			name: "transfer ids are kept per sending user",
+			steps: []step{
+				{command: transfer(1, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(1, 2, 1, 50), code: resultcode.Success},
+			},
+			balances: [2]int64{950, 1050},
+		},
+		{
+			name: "transfer ids are kept in snapshots",
+			steps: []step{
+				{command: transfer(1, 1, 2, 100), code: resultcode.Success},
+				{command: transfer(1, 1, 2, 100), code: resultcode.UserMGMTTransferAlreadyApplied, snapshot: true},
+			},
+			balances: [2]int64{900, 1100},
+		},
+		{
+			name: "id of a rejected transfer is not applied",
+			steps: []step{
+				{command: transfer(1, 1, 2, 1001), code: resultcode.UserMGMTTransferNSF},
+				{command: transfer(1, 1, 2, 1000), code: resultcode.Success},
+			},
+			balances: [2]int64{0, 2000},
+		},
+		{
+			name: "margin is not free",
+			steps: []step{
+				{command: gtc(1, 1, order.Bid, 1000, 10), code: resultcode.Success},
+				{command: gtc(2, 2, order.Ask, 1000, 10), code: resultcode.Success},
+				{command: transfer(1, 1, 2, 901), code: resultcode.UserMGMTTransferNSF},
+				{command: transfer(1, 1, 2, 900), code: resultcode.Success},
+			},
+			balances: [2]int64{100, 1900},
+		},
+		{
+			name: "suspended receiver",
+			steps: []step{
+				{command: &cmd.AddUser{UserId: 3}, code: resultcode.Success},
+				{command: &cmd.SuspendUser{UserId: 3}, code: resultcode.Success},
+				{command: transfer(1, 1, 3, 100), code: resultcode.UserMGMTUserSuspended},
+			},
+			balances: [2]int64{1000, 1000},
+		},
+		{
+			name:     "same user",
+			steps:    []step{{command: transfer(1, 1, 1, 100), code: resultcode.UserMGMTTransferSameUser}},
+			balances: [2]int64{1000, 1000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 1000, 1, 2)
+
+			for i, step := range test.steps {
+				if step.snapshot {
+					out := &bytes.Buffer{}
+
+					if err := h.engine.Marshal(out); err != nil {
+						t.Fatal(err)
+					}
+
+					h.engine = NewEngine(h.router)
+					h.router.SetUsers(h.engine)
+
+					if err := h.engine.Unmarshal(out); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if _, code := h.process(step.command); code != step.code {
+					t.Errorf("step %v: code %v, want %v", i, code, step.code)
+				}
+			}
+
+			if got := [2]int64{h.balance(1), h.balance(2)}; got != test.balances {
+				t.Errorf("balances %v, want %v", got, test.balances)
+			}
+
+			for _, r := range h.engine.Reconcile(map[int32]int64{currency: 2000}) {
+				if !r.IsBalanced() {
+					t.Errorf("currency %v: discrepancy %v, %v mismatches", r.Currency(), r.Discrepancy(), len(r.Mismatches()))
+				}
+			}
+		})
+	}
+}
+
+/*
+ * Users 3 and 4 share the margin of user 1, user 4 is short of 1200
+ * for the margin of its position, 200 more than the balance of user 1.
+ */
+func TestSharedMarginTransfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   int64
+		amount int64
+		code   resultcode.ResultCode
+	}{
+		{name: "master covering a shortfall", from: 1, amount: 1, code: resultcode.UserMGMTTransferNSF},
+		{name: "sub-account", from: 3, amount: 300, code: resultcode.Success},
+		{name: "sub-account over the shortfall of a sibling", from: 3, amount: 301, code: resultcode.UserMGMTTransferNSF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t, 1000, 1, 2)
+
+			for _, userID := range []int64{3, 4} {
+				h.mustProcess(&cmd.AddSubAccount{UserId: userID, ParentId: 1, SharedMargin: true})
+			}
+
+			h.mustProcess(&cmd.BalanceAdj{UserId: 3, Currency: currency, Amount: 500, TXID: 1})
+			h.mustProcess(gtc(1, 4, order.Bid, 1000, 120))
+			h.mustProcess(gtc(2, 2, order.Ask, 1000, 120))
+
+			transfer := &cmd.Transfer{
+				TransferID: 1,
+				FromUserId: test.from,
+				ToUserId:   2,
+				Currency:   currency,
+				Amount:     test.amount,
+			}
+
+			if _, code := h.process(transfer); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+		})
+	}
+}
+
+func TestSpreadTrade(t *testing.T) {
+	const (
+		back     int32 = 4
+		calendar int32 = 5
+	)
+
+	h := newHarness(t, 10000, 1, 2, 3)
+	h.addBook(newFuture(t, back, 0))
+	h.addBook(symbol.NewSpread(calendar, symbol.NewLeg(future, 1), symbol.NewLeg(back, -1)))
+
+	// the reference price of the back leg is the mid 105, the front leg trades at 110
+	h.mustProcess(gtcIn(back, 1, 3, order.Bid, 100, 10))
+	h.mustProcess(gtcIn(back, 2, 3, order.Ask, 110, 10))
+	h.mustProcess(gtcIn(calendar, 3, 1, order.Bid, 5, 2))
+	h.mustProcess(gtcIn(calendar, 4, 2, order.Ask, 5, 3))
+
+	positions := map[int64]map[int32]int64{
+		1: {future: 2, back: -2},
+		2: {future: -2, back: 2},
+		3: {future: 0, back: 0},
+	}
+
+	for userID, want := range positions {
+		for symbolID, quantity := range want {
+			if got := h.quantity(userID, symbolID); got != quantity {
+				t.Errorf("user %v: position %v in %v, want %v", userID, got, symbolID, quantity)
+			}
+		}
+	}
+
+	prices := map[int32]int64{future: 110, back: 105}
+
+	for symbolID, want := range prices {
+		trades := h.router.LastTrades()[symbolID]
+
+		if len(trades) != 1 {
+			t.Fatalf("leg %v: %v trades, want 1", symbolID, len(trades))
+		}
+
+		if trades[0].Price() != want || trades[0].Quantity() != 2 {
+			t.Errorf("leg %v traded %v at %v, want 2 at %v", symbolID, trades[0].Quantity(), trades[0].Price(), want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package userengine
+
+import (
+	"bytes"
+	"log"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/cmd"
+	"github.com/xerexchain/matching-engine/ledger"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/user"
+)
+
+/*
+ * Deposits and withdrawals are journaled against custody,
+ * suspend adjustments against the suspended account of the user.
+ * Adjustments are applied once per transaction id.
+ */
+func (e *Engine) adjustBalance(
+	command *cmd.BalanceAdj,
+) resultcode.ResultCode {
+	profile, ok := e.users.Get(command.UserId)
+
+	if !ok {
+		return resultcode.UserMGMTUserNotFound
+	}
+
+	free := e.freeBalance(profile, command.Currency)
+	code := profile.ValidateAdjustment(command.Amount, command.TXID, free)
+
+	if code != resultcode.Success {
+		return code
+	}
+
+	kind, counterparty := ledger.Deposit, ledger.Custody()
+
+	if command.BalanceAdjCategory.IsSuspend() {
+		kind, counterparty = ledger.Adjustment, ledger.Suspended(command.UserId)
+	} else if command.Amount < 0 {
+		kind = ledger.Withdrawal
+	}
+
+	profile.SetAdjustmentsCounter(command.TXID)
+	e.post(kind, profile, command.Currency, counterparty, command.Amount, command.TimestampNS())
+
+	return resultcode.Success
+}
+
+/*
+ * Moves funds between two active users atomically,
+ * journaled as a transfer between their accounts.
+ * The sender must have the amount free of margin, see `freeBalance`.
+ * Transfer ids are a sequence per sending user starting at 1:
+ * ids up to the last applied one are resubmissions, higher ids than the next one
+ * are out of sequence. Rejected transfers do not take their id.
+ * The last applied id is kept also while the user is suspended.
+ */
+func (e *Engine) transfer(
+	command *cmd.Transfer,
+) resultcode.ResultCode {
+	if command.Amount <= 0 {
+		return resultcode.UserMGMTTransferZero
+	}
+
+	if command.FromUserId == command.ToUserId {
+		return resultcode.UserMGMTTransferSameUser
+	}
+
+	from, code := e.activeProfile(command.FromUserId)
+
+	if code != resultcode.Success {
+		return code
+	}
+
+	to, code := e.activeProfile(command.ToUserId)
+
+	if code != resultcode.Success {
+		return code
+	}
+
+	last := e.transfers[command.FromUserId]
+
+	if command.TransferID <= last {
+		return resultcode.UserMGMTTransferAlreadyApplied
+	}
+
+	if command.TransferID != last+1 {
+		return resultcode.UserMGMTTransferOutOfSequence
+	}
+
+	if e.freeBalance(from, command.Currency) < command.Amount {
+		return resultcode.UserMGMTTransferNSF
+	}
+
+	if err := e.move(from, to, command.Currency, command.Amount, command.TimestampNS()); err != nil {
+		return resultcode.UserMGMTTransferNotPosted
+	}
+
+	e.transfers[command.FromUserId] = command.TransferID
+
+	return resultcode.Success
+}
+
+/*
+ * Orders left resting would fill without a profile to apply the trade to,
+ * they must be cancelled before suspension.
+ */
+func (e *Engine) suspendUser(
+	command *cmd.SuspendUser,
+) resultcode.ResultCode {
+	if _, ok := e.users.Get(command.UserId); ok && e.market.HasOrders(command.UserId) {
+		return resultcode.UserMGMTUserNotSuspendableHasOrders
+	}
+
+	return e.users.SuspendUser(command.UserId)
+}
+
+func (e *Engine) activeProfile(userID int64) (*user.Profile, resultcode.ResultCode) {
+	profile, ok := e.users.Get(userID)
+
+	if ok {
+		return profile, resultcode.Success
+	}
+
+	if e.users.IsSuspended(userID) {
+		return nil, resultcode.UserMGMTUserSuspended
+	}
+
+	return nil, resultcode.UserMGMTUserNotFound
+}
+
+func (e *Engine) move(
+	from *user.Profile,
+	to *user.Profile,
+	currency int32,
+	amount int64,
+	timestampNS int64,
+) error {
+	if _, err := e.ledger.Post(
+		ledger.Transfer,
+		currency,
+		ledger.User(from.UserID()),
+		ledger.User(to.UserID()),
+		amount,
+		timestampNS,
+	); err != nil {
+		log.Printf("transfer: user %v to %v: %v", from.UserID(), to.UserID(), err)
+
+		return err
+	}
+
+	from.AddBalance(currency, -amount)
+	to.AddBalance(currency, amount)
+
+	return nil
+}
+
+/*
+ * Balance not required as margin: the balance less the required margin
+ * and unrealized losses at the mark price of positions in the currency.
+ * Shortfalls of sub-accounts sharing margin are covered by the parent,
+ * a sub-account sharing margin counts the free balance of the parent
+ * as its margin balance does (see `marginBalance`), net of the shortfalls
+ * of its siblings. Balances of the parent are not moved out of a sub-account.
+ */
+func (e *Engine) freeBalance(
+	profile *user.Profile,
+	currency int32,
+) int64 {
+	own := profile.Balance(currency) - e.marginUsed(profile, currency)
+
+	if profile.IsSubAccount() {
+		if !profile.SharedMargin() {
+			return own
+		}
+
+		parent, ok := e.users.Get(profile.ParentID())
+
+		if !ok {
+			return own
+		}
+
+		// the shortfall of the sub-account is counted by the parent already
+		free := e.freeBalance(parent, currency)
+
+		if own > 0 {
+			free += own
+		}
+
+		if balance := profile.Balance(currency); free > balance {
+			free = balance
+		}
+
+		return free
+	}
+
+	for _, userID := range e.users.SubAccounts(profile.UserID()) {
+		sub, _ := e.users.Get(userID)
+
+		if !sub.SharedMargin() {
+			continue
+		}
+
+		if subFree := sub.Balance(currency) - e.marginUsed(sub, currency); subFree < 0 {
+			own += subFree
+		}
+	}
+
+	return own
+}
+
+// Required margin and unrealized losses of positions in the currency.
+func (e *Engine) marginUsed(
+	profile *user.Profile,
+	currency int32,
+) int64 {
+	var used int64
+
+	for _, symbolID := range profile.MarginSymbolIDs() {
+		position_, _ := profile.MarginPositionOf(symbolID)
+
+		if position_.Currency() != currency {
+			continue
+		}
+
+		book, ok := e.market.Book(symbolID)
+
+		if !ok {
+			continue
+		}
+
+		future, ok := marginFuture(book.Symbol())
+
+		if !ok {
+			continue
+		}
+
+		var rec riskengine.LastPriceCacheRecord
+
+		if mark, ok := e.market.MarkPrice(symbolID); ok {
+			rec = mark
+		}
+
+		used += position_.CalculateRequiredMarginForFutures(*future)
+
+		// realized profit is moved to the balance when the position is removed
+		if profit := position_.EstimateProfit(*future, rec); profit < 0 {
+			used -= profit
+		}
+	}
+
+	return used
+}
+
+// Balance for margin, including the balance of the parent if margin is shared.
+func (e *Engine) marginBalance(
+	profile *user.Profile,
+	currency int32,
+) int64 {
+	balance := profile.Balance(currency)
+
+	if !profile.SharedMargin() {
+		return balance
+	}
+
+	if parent, ok := e.users.Get(profile.ParentID()); ok {
+		balance += parent.Balance(currency)
+	}
+
+	return balance
+}
+
+// The rest stays negative if the balance of the parent does not cover it.
+func (e *Engine) coverFromParent(
+	profile *user.Profile,
+	currency int32,
+	timestampNS int64,
+) {
+	parent, ok := e.users.Get(profile.ParentID())
+
+	if !ok {
+		return
+	}
+
+	amount := -profile.Balance(currency)
+
+	if available := parent.Balance(currency); available < amount {
+		amount = available
+	}
+
+	// the error is logged by `move`, the rest stays negative
+	if amount > 0 {
+		e.move(parent, profile, currency, amount, timestampNS)
+	}
+}
+
+func marshalTransfers(
+	transfers map[int64]int64,
+	out *bytes.Buffer,
+) error {
+	userIDs := make([]int64, 0, len(transfers))
+
+	for id := range transfers {
+		userIDs = append(userIDs, id)
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(userIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(transfers[id], out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalTransfers(in *bytes.Buffer) (map[int64]int64, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make(map[int64]int64, size)
+
+	for ; size > 0; size-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transferID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transfers[userID] = transferID
+	}
+
+	return transfers, nil
+}
@@ -11,8 +11,10 @@ const (
 	Success  ResultCode = 100
 	Accepted ResultCode = 110
 
-	AuthInvalidUser  ResultCode = -1001
-	AuthTokenExpired ResultCode = -1002
+	AuthInvalidUser   ResultCode = -1001
+	AuthTokenExpired  ResultCode = -1002
+	AuthOrderNotOwned ResultCode = -1003
+	AuthNotAdmin      ResultCode = -1004
 
 	InvalidSymbol         ResultCode = -1201
 	InvalidPriceStep      ResultCode = -1202
@@ -22,6 +24,11 @@ const (
 	RiskInvalidReservedBidPrice ResultCode = -2002
 	RiskAskPriceLowerThanFee    ResultCode = -2003
 	RiskMarginTradingDisabled   ResultCode = -2004
+	RiskInvalidIndexPrice       ResultCode = -2005
+	RiskFundingNotDue           ResultCode = -2006
+	RiskInvalidSettlementPrice  ResultCode = -2007
+	RiskInvalidLiquidation      ResultCode = -2008
+	RiskLiquidationNotCovered   ResultCode = -2009
 
 	MatchingUnknownOrderID         ResultCode = -3002
 	MatchingDuplicateOrderId       ResultCode = -3003
@@ -29,6 +36,10 @@ const (
 	MatchingInvalidOrderBookId     ResultCode = -3005
 	MatchingOrderBookAlreadyExists ResultCode = -3006
 	MatchingUnsupportedOrderType   ResultCode = -3007
+	MatchingInvalidOrderGroup      ResultCode = -3008
+	MatchingDuplicateClientOrderID ResultCode = -3009
+	MatchingTradingClosed          ResultCode = -3010
+	MatchingSymbolNotExpired       ResultCode = -3011
 
 	MatchingMoveRejectedDifferentPrice   ResultCode = -3040
 	MatchingMoveFailedPriceOverRiskLimit ResultCode = -3041
@@ -36,6 +47,15 @@ const (
 
 	MatchingReduceFailedWrongQuantity ResultCode = -3051
 
+	MatchingKillSwitchActive ResultCode = -3060
+	MatchingInvalidSession   ResultCode = -3061
+	MatchingMMPFrozen        ResultCode = -3062
+	MatchingInvalidMMPGroup  ResultCode = -3063
+
+	MatchingInvalidQuote ResultCode = -3070
+	MatchingBatchAborted ResultCode = -3071 // valid item, another item of the command failed
+	MatchingCrossedQuote ResultCode = -3072 // best bid of a ladder is not below its best ask
+
 	UserMGMTUserAlreadyExists ResultCode = -4001
 
 	UserMGMTAccountBalanceAdjustmentZero               ResultCode = -4100
@@ -48,6 +68,17 @@ const (
 	UserMGMTUserNotSuspendableNonEmptyAccounts ResultCode = -4131
 	UserMGMTUserNotSuspended                   ResultCode = -4132
 	UserMGMTUserAlreadySuspended               ResultCode = -4133
+	UserMGMTUserNotSuspendableHasOrders        ResultCode = -4134
+	UserMGMTUserNotSuspendableHasSubAccounts   ResultCode = -4135
+
+	UserMGMTTransferZero           ResultCode = -4150
+	UserMGMTTransferAlreadyApplied ResultCode = -4151
+	UserMGMTTransferNSF            ResultCode = -4152
+	UserMGMTTransferSameUser       ResultCode = -4153
+	UserMGMTUserSuspended          ResultCode = -4154
+	UserMGMTTransferNotPosted      ResultCode = -4155 // rejected by the ledger
+	UserMGMTTransferOutOfSequence  ResultCode = -4156 // a transfer id is skipped
+	UserMGMTInvalidParent          ResultCode = -4160
 
 	UserMGMTUserNotFound ResultCode = -4201
 
@@ -55,6 +86,7 @@ const (
 
 	BinaryCommandFailed              ResultCode = -8001
 	ReportQueryUnknownType           ResultCode = -8003
+	ReportQueryInvalidParameters     ResultCode = -8004
 	StatePersistRiskEngineFailed     ResultCode = -8010
 	StatePersistMatchingEngineFailed ResultCode = -8020
 
@@ -47,6 +47,22 @@ func WriteInt8(d int8, out *bytes.Buffer) error {
 	return binary.Write(out, binary.LittleEndian, d)
 }
 
+func ReadBool(in *bytes.Buffer) (bool, error) {
+	res, err := ReadInt8(in)
+
+	return res != 0, err
+}
+
+func WriteBool(d bool, out *bytes.Buffer) error {
+	var res int8
+
+	if d {
+		res = 1
+	}
+
+	return WriteInt8(res, out)
+}
+
 func UnmarshalUInt32(b *bytes.Buffer) (interface{}, error) {
 	var res uint32
 	err := binary.Read(b, binary.LittleEndian, &res)
@@ -0,0 +1,280 @@
+package session
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+/*
+ * API client session.
+ * Time is measured in command timestamps (not wall clock),
+ * so that replaying the journal expires the same sessions.
+ */
+type Session struct {
+	id              int64
+	userID          int64
+	timeoutNS       int64
+	lastHeartbeatNS int64
+	_               struct{}
+}
+
+func (s *Session) ID() int64 {
+	return s.id
+}
+
+func (s *Session) UserID() int64 {
+	return s.userID
+}
+
+func (s *Session) isExpired(timestampNS int64) bool {
+	return timestampNS-s.lastHeartbeatNS > s.timeoutNS
+}
+
+func (s *Session) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(s.id, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.userID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.timeoutNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(s.lastHeartbeatNS, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Session) Unmarshal(in *bytes.Buffer) error {
+	id, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	userID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	timeoutNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	lastHeartbeatNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	s.id = id
+	s.userID = userID
+	s.timeoutNS = timeoutNS
+	s.lastHeartbeatNS = lastHeartbeatNS
+
+	return nil
+}
+
+/*
+ * Open sessions and users blocked by kill switch.
+ * Orders of a session are cancelled when the session times out
+ * or the client sends kill switch.
+ * Kill switch also blocks further orders of the user until reset.
+ */
+type Registry struct {
+	sessions map[int64]*Session
+	killed   map[int64]struct{} // userIDs
+	_        struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		sessions: make(map[int64]*Session),
+		killed:   make(map[int64]struct{}),
+	}
+}
+
+// Opens the session if not open yet.
+func (r *Registry) Heartbeat(
+	userID int64,
+	sessionID int64,
+	timeoutNS int64,
+	timestampNS int64,
+) resultcode.ResultCode {
+	if sessionID == 0 || timeoutNS <= 0 {
+		return resultcode.MatchingInvalidSession
+	}
+
+	s, ok := r.sessions[sessionID]
+
+	if !ok {
+		s = &Session{
+			id:     sessionID,
+			userID: userID,
+		}
+		r.sessions[sessionID] = s
+	} else if s.userID != userID {
+		return resultcode.AuthInvalidUser
+	}
+
+	s.timeoutNS = timeoutNS
+	s.lastHeartbeatNS = timestampNS
+
+	return resultcode.Success
+}
+
+// Orders can be tagged only with open sessions of the same user.
+func (r *Registry) IsOpen(
+	userID int64,
+	sessionID int64,
+) bool {
+	s, ok := r.sessions[sessionID]
+
+	return ok && s.userID == userID
+}
+
+// Removes and returns timed out sessions, sorted by id.
+func (r *Registry) Expire(timestampNS int64) []*Session {
+	var expired []*Session
+
+	for _, s := range r.sessions {
+		if s.isExpired(timestampNS) {
+			expired = append(expired, s)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].id < expired[j].id
+	})
+
+	for _, s := range expired {
+		delete(r.sessions, s.id)
+	}
+
+	return expired
+}
+
+// Closes the session and blocks the user.
+func (r *Registry) Kill(
+	userID int64,
+	sessionID int64,
+) (*Session, resultcode.ResultCode) {
+	s, ok := r.sessions[sessionID]
+
+	if !ok || s.userID != userID {
+		return nil, resultcode.MatchingInvalidSession
+	}
+
+	delete(r.sessions, sessionID)
+	r.killed[userID] = struct{}{}
+
+	return s, resultcode.Success
+}
+
+func (r *Registry) IsKilled(userID int64) bool {
+	_, ok := r.killed[userID]
+
+	return ok
+}
+
+func (r *Registry) ResetKill(userID int64) {
+	delete(r.killed, userID)
+}
+
+func (r *Registry) Marshal(out *bytes.Buffer) error {
+	sessionIDs := make([]int64, 0, len(r.sessions))
+
+	for id := range r.sessions {
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	sort.Slice(sessionIDs, func(i, j int) bool {
+		return sessionIDs[i] < sessionIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(sessionIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range sessionIDs {
+		if err := r.sessions[id].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	userIDs := make([]int64, 0, len(r.killed))
+
+	for id := range r.killed {
+		userIDs = append(userIDs, id)
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		return userIDs[i] < userIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(userIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	sessions := make(map[int64]*Session, size)
+
+	for ; size > 0; size-- {
+		s := &Session{}
+
+		if err := s.Unmarshal(in); err != nil {
+			return err
+		}
+
+		sessions[s.id] = s
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	killed := make(map[int64]struct{}, size)
+
+	for ; size > 0; size-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		killed[userID] = struct{}{}
+	}
+
+	r.sessions = sessions
+	r.killed = killed
+
+	return nil
+}
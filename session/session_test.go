@@ -0,0 +1,94 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/resultcode"
+)
+
+func TestExpire(t *testing.T) {
+	r := NewRegistry()
+
+	heartbeats := []struct {
+		userID      int64
+		sessionID   int64
+		timeoutNS   int64
+		timestampNS int64
+		code        resultcode.ResultCode
+	}{
+		{userID: 1, sessionID: 2, timeoutNS: 10, timestampNS: 0, code: resultcode.Success},
+		{userID: 1, sessionID: 1, timeoutNS: 10, timestampNS: 0, code: resultcode.Success},
+		{userID: 2, sessionID: 3, timeoutNS: 100, timestampNS: 0, code: resultcode.Success},
+		// keeps session 2 alive
+		{userID: 1, sessionID: 2, timeoutNS: 10, timestampNS: 5, code: resultcode.Success},
+		{userID: 2, sessionID: 1, timeoutNS: 10, timestampNS: 5, code: resultcode.AuthInvalidUser},
+		{userID: 2, sessionID: 0, timeoutNS: 10, timestampNS: 5, code: resultcode.MatchingInvalidSession},
+		{userID: 2, sessionID: 4, timeoutNS: 0, timestampNS: 5, code: resultcode.MatchingInvalidSession},
+	}
+
+	for i, h := range heartbeats {
+		if code := r.Heartbeat(h.userID, h.sessionID, h.timeoutNS, h.timestampNS); code != h.code {
+			t.Errorf("heartbeat %v: %v, want %v", i, code, h.code)
+		}
+	}
+
+	if !r.IsOpen(1, 1) || r.IsOpen(2, 1) || r.IsOpen(2, 4) {
+		t.Error("open sessions")
+	}
+
+	// the timeout is inclusive
+	if expired := r.Expire(10); len(expired) != 0 {
+		t.Fatalf("%v sessions expired at the timeout", len(expired))
+	}
+
+	expired := r.Expire(16)
+
+	if len(expired) != 2 || expired[0].ID() != 1 || expired[1].ID() != 2 || expired[0].UserID() != 1 {
+		t.Fatalf("expired %v sessions", len(expired))
+	}
+
+	if r.IsOpen(1, 1) || r.IsOpen(1, 2) || !r.IsOpen(2, 3) {
+		t.Error("expired sessions kept open")
+	}
+
+	// replaying from a snapshot expires the same sessions
+	out := &bytes.Buffer{}
+
+	if err := r.Marshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewRegistry()
+
+	if err := restored.Unmarshal(out); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired := restored.Expire(101); len(expired) != 1 || expired[0].ID() != 3 {
+		t.Errorf("restored: expired %v sessions", len(expired))
+	}
+}
+
+func TestKill(t *testing.T) {
+	r := NewRegistry()
+	r.Heartbeat(1, 1, 10, 0)
+
+	if _, code := r.Kill(2, 1); code != resultcode.MatchingInvalidSession {
+		t.Errorf("kill of another user: %v", code)
+	}
+
+	if s, code := r.Kill(1, 1); code != resultcode.Success || s.ID() != 1 {
+		t.Fatalf("kill: %v", code)
+	}
+
+	if r.IsOpen(1, 1) || !r.IsKilled(1) || r.IsKilled(2) {
+		t.Error("killed session")
+	}
+
+	r.ResetKill(1)
+
+	if r.IsKilled(1) {
+		t.Error("kill not reset")
+	}
+}
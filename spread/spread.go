@@ -0,0 +1,791 @@
+package spread
+
+import (
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+// TODO leg price limits (bands)
+
+type Books interface {
+	Book(symbolID int32) (*orderbook.Naive, bool)
+	MarkPrice(symbolID int32) (*riskengine.MarkPrice, bool)
+}
+
+// Called after the books of the symbol changed, e.g. for market maker protection.
+type SettleFunc func(
+	symbolID int32,
+	timestampNS int64,
+	res *orderbook.MatcherResult,
+) *orderbook.MatcherResult
+
+/*
+ * Coordinates spread books (books of `symbol.Spread`) and their leg books.
+ * A spread order matches resting spread orders and implied-out liquidity
+ * of the leg books, whichever price is better (spread book first on ties).
+ * An outright order of a leg matches resting leg orders and implied-in liquidity
+ * of the spreads of the leg, whichever price is better (leg book first on ties).
+ * Each implied execution fills all legs or none.
+ * Fills of spread orders are recorded as leg trades for positions,
+ * trades of spread books are informational (executions and statistics).
+ */
+type Engine struct {
+	books  Books
+	settle SettleFunc
+	_      struct{}
+}
+
+func NewEngine(
+	books Books,
+	settle SettleFunc,
+) *Engine {
+	return &Engine{
+		books:  books,
+		settle: settle,
+	}
+}
+
+/*
+ * Legs must be listed futures and different from each other.
+ * A leg of ratio 1 or -1 is required, leg prices of spread trades are solved for it.
+ */
+func (e *Engine) Validate(
+	spread *symbol.Spread,
+) resultcode.ResultCode {
+	legs := spread.Legs()
+
+	if len(legs) < 2 {
+		return resultcode.InvalidSymbol
+	}
+
+	seen := make(map[int32]struct{}, len(legs))
+	unit := false
+
+	for _, leg := range legs {
+		if leg.Ratio() == 0 {
+			return resultcode.InvalidSymbol
+		}
+
+		unit = unit || abs(leg.Ratio()) == 1
+
+		if _, ok := seen[leg.SymbolID()]; ok {
+			return resultcode.InvalidSymbol
+		}
+
+		seen[leg.SymbolID()] = struct{}{}
+		book, ok := e.books.Book(leg.SymbolID())
+
+		if !ok {
+			return resultcode.InvalidSymbol
+		}
+
+		if _, ok := book.Symbol().(*symbol.FutureContract); !ok {
+			return resultcode.UnsupportedSymbolType
+		}
+	}
+
+	if !unit {
+		return resultcode.InvalidSymbol
+	}
+
+	return resultcode.Success
+}
+
+func (e *Engine) legBooks(
+	spread *symbol.Spread,
+) ([]*orderbook.Naive, bool) {
+	books := make([]*orderbook.Naive, 0, len(spread.Legs()))
+
+	for _, leg := range spread.Legs() {
+		book, ok := e.books.Book(leg.SymbolID())
+
+		if !ok {
+			return nil, false
+		}
+
+		books = append(books, book)
+	}
+
+	return books, true
+}
+
+/*
+ * Implied-out price of the side `action` of the spread,
+ * computed from the best prices of the leg books.
+ * Implied bid sells legs of positive ratio and buys the others.
+ */
+func (e *Engine) ImpliedOut(
+	spreadID int32,
+	action order.Action,
+) (price int64, quantity int64, ok bool) {
+	spread, legBooks, ok := e.spread(spreadID)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	return impliedOut(spread, legBooks, action)
+}
+
+func impliedOut(
+	spread *symbol.Spread,
+	legBooks []*orderbook.Naive,
+	action order.Action,
+) (price int64, quantity int64, ok bool) {
+	for i, leg := range spread.Legs() {
+		ratio := leg.Ratio()
+		legPrice, legQuantity, ok := legBooks[i].Best(legSide(action, ratio))
+
+		if !ok || legQuantity/abs(ratio) == 0 {
+			return 0, 0, false
+		}
+
+		price += ratio * legPrice
+
+		if i == 0 || legQuantity/abs(ratio) < quantity {
+			quantity = legQuantity / abs(ratio)
+		}
+	}
+
+	return price, quantity, true
+}
+
+/*
+ * Implied-in price of the side `action` of a leg, computed from
+ * the best price of the spread book and the best prices of the other legs.
+ * Bids are rounded down, asks up.
+ */
+func (e *Engine) ImpliedIn(
+	spreadID int32,
+	legSymbolID int32,
+	action order.Action,
+) (price int64, quantity int64, ok bool) {
+	spread, legBooks, ok := e.spread(spreadID)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	k := -1
+
+	for i, leg := range spread.Legs() {
+		if leg.SymbolID() == legSymbolID {
+			k = i
+		}
+	}
+
+	if k == -1 {
+		return 0, 0, false
+	}
+
+	ratioK := spread.Legs()[k].Ratio()
+	spreadAction := action
+
+	if ratioK < 0 {
+		spreadAction = opposite(action)
+	}
+
+	book, _ := e.books.Book(spreadID)
+	rest, quantity, ok := book.Best(spreadAction)
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	for i, leg := range spread.Legs() {
+		if i == k {
+			continue
+		}
+
+		ratio := leg.Ratio()
+
+		// the other legs trade against the books on the opposite side of the spread order
+		legPrice, legQuantity, ok := legBooks[i].Best(legSide(opposite(spreadAction), ratio))
+
+		if !ok || legQuantity/abs(ratio) == 0 {
+			return 0, 0, false
+		}
+
+		rest -= ratio * legPrice
+
+		if legQuantity/abs(ratio) < quantity {
+			quantity = legQuantity / abs(ratio)
+		}
+	}
+
+	if action == order.Bid {
+		price = floorDiv(rest, ratioK)
+	} else {
+		price = ceilDiv(rest, ratioK)
+	}
+
+	return price, quantity * abs(ratioK), true
+}
+
+func (e *Engine) spread(
+	spreadID int32,
+) (*symbol.Spread, []*orderbook.Naive, bool) {
+	book, ok := e.books.Book(spreadID)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	spread, ok := book.Symbol().(*symbol.Spread)
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	legBooks, ok := e.legBooks(spread)
+
+	return spread, legBooks, ok
+}
+
+/*
+ * Places a spread order (GTC or IOC) into the spread `book`.
+ * Spread book and implied-out liquidity are taken level by level,
+ * the remainder rests in the spread book (GTC) or is rejected (IOC).
+ */
+func (e *Engine) Place(
+	book *orderbook.Naive,
+	command *order.Place,
+) *orderbook.MatcherResult {
+	spread, ok := book.Symbol().(*symbol.Spread)
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.UnsupportedSymbolType,
+		}
+	}
+
+	legBooks, ok := e.legBooks(spread)
+
+	if !ok {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderBookId,
+		}
+	}
+
+	if command.GroupID() != 0 {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingInvalidOrderGroup,
+		}
+	}
+
+	if command.Category() != order.GTC && command.Category() != order.IOC {
+		return &orderbook.MatcherResult{
+			Code: resultcode.MatchingUnsupportedOrderType,
+		}
+	}
+
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+	action := command.Action()
+	unpriced := false
+
+	for command.Quantity() > 0 {
+		bookPrice, _, bookOK := book.Best(opposite(action))
+		impliedPrice, impliedQuantity, impliedOK := impliedOut(spread, legBooks, opposite(action))
+
+		bookOK = bookOK && isAcceptable(action, bookPrice, command.Price())
+		impliedOK = impliedOK && isAcceptable(action, impliedPrice, command.Price())
+
+		var legPrices []int64
+
+		if bookOK {
+			legPrices, bookOK = e.legPrices(spread, legBooks, bookPrice)
+			unpriced = !bookOK
+		}
+
+		if bookOK && (!impliedOK || isAcceptable(action, bookPrice, impliedPrice)) {
+			chunk := order.NewPlace(
+				command.OrderID(),
+				command.UserID(),
+				bookPrice,
+				command.Quantity(),
+				command.ReservedPrice(),
+				command.SymbolID(),
+				command.Timestamp(),
+				action,
+				order.IOC,
+			)
+			chunkResult := book.Match(chunk)
+			command.Reduce(command.Quantity() - chunk.Quantity())
+			legResults := e.splitTrades(spread, legBooks, command, legPrices, chunkResult)
+			res.Append(e.settle(book.Symbol().ID(), command.TimestampNS(), chunkResult).Head)
+
+			for _, legResult := range legResults {
+				if legResult != nil {
+					res.Append(legResult.Head)
+				}
+			}
+		} else if impliedOK {
+			quantity := impliedQuantity
+
+			if command.Quantity() < quantity {
+				quantity = command.Quantity()
+			}
+
+			e.executeLegs(spread, legBooks, command, quantity, res)
+			command.Reduce(quantity)
+		} else {
+			break
+		}
+	}
+
+	if command.Quantity() == 0 {
+		return res
+	}
+
+	// the remainder would match the spread book at unknown leg prices
+	if command.Category() == order.GTC && !unpriced {
+		res.Append(book.PlaceGTC(command).Head)
+	} else {
+		res.Append(event.NewReject(
+			book.Symbol().ID(),
+			command.OrderID(),
+			command.Price(),
+			command.Quantity(),
+			action,
+		))
+	}
+
+	return res
+}
+
+/*
+ * Leg prices of a spread trade at `price`. Legs take their reference prices
+ * (mark price, else mid or best price of the leg book), but the first leg
+ * of ratio 1 or -1 which takes the rest of the spread price.
+ * Not ok if a reference price is unknown or the rest is not positive.
+ */
+func (e *Engine) legPrices(
+	spread *symbol.Spread,
+	legBooks []*orderbook.Naive,
+	price int64,
+) ([]int64, bool) {
+	legs := spread.Legs()
+	prices := make([]int64, len(legs))
+	k := -1
+	rest := price
+
+	for i, leg := range legs {
+		if k == -1 && abs(leg.Ratio()) == 1 {
+			k = i
+
+			continue
+		}
+
+		reference, ok := e.referencePrice(leg.SymbolID(), legBooks[i])
+
+		if !ok {
+			return nil, false
+		}
+
+		prices[i] = reference
+		rest -= leg.Ratio() * reference
+	}
+
+	if k == -1 {
+		return nil, false
+	}
+
+	prices[k] = rest * legs[k].Ratio()
+
+	if prices[k] <= 0 {
+		return nil, false
+	}
+
+	return prices, true
+}
+
+func (e *Engine) referencePrice(
+	symbolID int32,
+	book *orderbook.Naive,
+) (int64, bool) {
+	if mark, ok := e.books.MarkPrice(symbolID); ok && mark.Price() > 0 {
+		return mark.Price(), true
+	}
+
+	bid, _, bidOK := book.Best(order.Bid)
+	ask, _, askOK := book.Best(order.Ask)
+
+	switch {
+	case bidOK && askOK:
+		return (bid + ask) / 2, true
+	case bidOK:
+		return bid, true
+	case askOK:
+		return ask, true
+	}
+
+	return 0, false
+}
+
+/*
+ * Records the trades of the spread book in `spreadResult` as leg trades
+ * at `legPrices`, the taker is the spread order `command`.
+ * Leg books are not changed, the makers rest in the spread book.
+ */
+func (e *Engine) splitTrades(
+	spread *symbol.Spread,
+	legBooks []*orderbook.Naive,
+	command *order.Place,
+	legPrices []int64,
+	spreadResult *orderbook.MatcherResult,
+) []*orderbook.MatcherResult {
+	legResults := make([]*orderbook.MatcherResult, len(legBooks))
+
+	for item := spreadResult.Head; item != nil; item = item.Next() {
+		trade, ok := item.(*event.Trade)
+
+		if !ok {
+			continue
+		}
+
+		for i, leg := range spread.Legs() {
+			ratio := abs(leg.Ratio())
+
+			// remained of the leg order is the one of the spread order
+			legOrder := order.NewPlace(
+				command.OrderID(),
+				command.UserID(),
+				legPrices[i],
+				(trade.Quantity()+trade.TakerRemained())*ratio,
+				legPrices[i],
+				leg.SymbolID(),
+				command.Timestamp(),
+				legSide(command.Action(), leg.Ratio()),
+				order.IOC,
+			)
+			legResult := legBooks[i].MatchImplied(
+				legOrder,
+				trade.MakerOrderID(),
+				trade.MakerUserID(),
+				trade.MakerOrderCompleted(),
+				trade.MakerRemained()*ratio,
+				legPrices[i],
+				trade.Quantity()*ratio,
+			)
+
+			if legResults[i] == nil {
+				legResults[i] = legResult
+			} else {
+				legResults[i].Append(legResult.Head)
+			}
+		}
+	}
+
+	return legResults
+}
+
+// Takes the best level of each leg book, `quantity` is in spread units.
+func (e *Engine) executeLegs(
+	spread *symbol.Spread,
+	legBooks []*orderbook.Naive,
+	command *order.Place,
+	quantity int64,
+	res *orderbook.MatcherResult,
+) {
+	legResults := make([]*orderbook.MatcherResult, 0, len(legBooks))
+
+	for i, leg := range spread.Legs() {
+		resting := legSide(opposite(command.Action()), leg.Ratio())
+		price, _, _ := legBooks[i].Best(resting)
+
+		legOrder := order.NewPlace(
+			command.OrderID(),
+			command.UserID(),
+			price,
+			quantity*abs(leg.Ratio()),
+			price,
+			leg.SymbolID(),
+			command.Timestamp(),
+			opposite(resting),
+			order.IOC,
+		)
+		legResults = append(legResults, legBooks[i].Match(legOrder))
+	}
+
+	// settled after all legs are filled, settlement may change the leg books
+	for i, leg := range spread.Legs() {
+		res.Append(e.settle(leg.SymbolID(), command.TimestampNS(), legResults[i]).Head)
+	}
+}
+
+/*
+ * Matches an outright order (GTC or IOC) of the leg `book` against resting leg orders
+ * and implied-in liquidity of the spreads `spreadIDs`, level by level.
+ * The quantity of the command is reduced by the filled quantity,
+ * the remainder is placed by the caller.
+ */
+func (e *Engine) MatchImpliedIn(
+	book *orderbook.Naive,
+	command *order.Place,
+	spreadIDs []int32,
+) *orderbook.MatcherResult {
+	res := &orderbook.MatcherResult{
+		Code: resultcode.Success,
+	}
+	action := command.Action()
+	legSymbolID := book.Symbol().ID()
+
+	for command.Quantity() > 0 {
+		bookPrice, _, bookOK := book.Best(opposite(action))
+		bookOK = bookOK && isAcceptable(action, bookPrice, command.Price())
+
+		var (
+			spreadID     int32
+			impliedPrice int64
+			impliedUnits int64
+			impliedOK    bool
+		)
+
+		for _, id := range spreadIDs {
+			price, quantity, ok := e.ImpliedIn(id, legSymbolID, opposite(action))
+
+			if !ok || !isAcceptable(action, price, command.Price()) {
+				continue
+			}
+
+			if impliedOK && !isBetter(action, price, impliedPrice) {
+				continue
+			}
+
+			ratio := abs(e.legRatio(id, legSymbolID))
+			units := quantity / ratio
+
+			if command.Quantity()/ratio < units {
+				units = command.Quantity() / ratio
+			}
+
+			if units == 0 {
+				continue
+			}
+
+			spreadID = id
+			impliedPrice = price
+			impliedUnits = units
+			impliedOK = true
+		}
+
+		if bookOK && (!impliedOK || isAcceptable(action, bookPrice, impliedPrice)) {
+			chunk := order.NewPlace(
+				command.OrderID(),
+				command.UserID(),
+				bookPrice,
+				command.Quantity(),
+				command.ReservedPrice(),
+				command.SymbolID(),
+				command.Timestamp(),
+				action,
+				order.IOC,
+			)
+			chunkResult := book.Match(chunk)
+			command.Reduce(command.Quantity() - chunk.Quantity())
+			res.Append(e.settle(legSymbolID, command.TimestampNS(), chunkResult).Head)
+		} else if impliedOK {
+			e.executeImpliedIn(spreadID, book, command, impliedPrice, impliedUnits, res)
+		} else {
+			break
+		}
+	}
+
+	return res
+}
+
+/*
+ * Fills `units` of the best resting spread orders: the outright order trades
+ * with each spread maker in the leg `book` at `price`, and the spread makers
+ * take the best levels of the other legs.
+ * Trades of the spread book have no taker (order and user 0).
+ */
+func (e *Engine) executeImpliedIn(
+	spreadID int32,
+	book *orderbook.Naive,
+	command *order.Place,
+	price int64,
+	units int64,
+	res *orderbook.MatcherResult,
+) {
+	spread, legBooks, _ := e.spread(spreadID)
+	spreadBook, _ := e.books.Book(spreadID)
+	ratioK := e.legRatio(spreadID, book.Symbol().ID())
+	spreadAction := opposite(command.Action())
+
+	if ratioK < 0 {
+		spreadAction = command.Action()
+	}
+
+	spreadPrice, _, _ := spreadBook.Best(spreadAction)
+
+	// the spread makers are filled without a taker of the spread book, the outright order trades legs only
+	chunk := order.NewPlace(
+		0,
+		0,
+		spreadPrice,
+		units,
+		spreadPrice,
+		spreadID,
+		command.Timestamp(),
+		opposite(spreadAction),
+		order.IOC,
+	)
+	spreadResult := spreadBook.Match(chunk)
+	legResults := make([]*orderbook.MatcherResult, len(legBooks))
+
+	for item := spreadResult.Head; item != nil; item = item.Next() {
+		trade, ok := item.(*event.Trade)
+
+		if !ok {
+			continue
+		}
+
+		for i, leg := range spread.Legs() {
+			if legBooks[i] == book {
+				continue
+			}
+
+			resting := legSide(opposite(spreadAction), leg.Ratio())
+			legPrice, _, _ := legBooks[i].Best(resting)
+
+			legOrder := order.NewPlace(
+				trade.MakerOrderID(),
+				trade.MakerUserID(),
+				legPrice,
+				trade.Quantity()*abs(leg.Ratio()),
+				legPrice,
+				leg.SymbolID(),
+				command.Timestamp(),
+				opposite(resting),
+				order.IOC,
+			)
+			legResult := legBooks[i].Match(legOrder)
+
+			if legResults[i] == nil {
+				legResults[i] = legResult
+			} else {
+				legResults[i].Append(legResult.Head)
+			}
+		}
+
+		// the maker is the spread order, its fill is settled with the spread book
+		res.Append(book.MatchImplied(
+			command,
+			trade.MakerOrderID(),
+			trade.MakerUserID(),
+			trade.MakerOrderCompleted(),
+			trade.MakerRemained()*abs(ratioK),
+			price,
+			trade.Quantity()*abs(ratioK),
+		).Head)
+	}
+
+	// settled after all legs are filled, settlement may change the leg books
+	res.Append(e.settle(spreadID, command.TimestampNS(), spreadResult).Head)
+
+	for i, leg := range spread.Legs() {
+		if legResults[i] != nil {
+			res.Append(e.settle(leg.SymbolID(), command.TimestampNS(), legResults[i]).Head)
+		}
+	}
+}
+
+func (e *Engine) legRatio(
+	spreadID int32,
+	legSymbolID int32,
+) int64 {
+	spread, _, ok := e.spread(spreadID)
+
+	if !ok {
+		return 0
+	}
+
+	for _, leg := range spread.Legs() {
+		if leg.SymbolID() == legSymbolID {
+			return leg.Ratio()
+		}
+	}
+
+	return 0
+}
+
+// Side of the leg book providing the side `action` of the spread.
+func legSide(
+	action order.Action,
+	ratio int64,
+) order.Action {
+	if ratio > 0 {
+		return action
+	}
+
+	return opposite(action)
+}
+
+func opposite(action order.Action) order.Action {
+	if action == order.Ask {
+		return order.Bid
+	}
+
+	return order.Ask
+}
+
+// whether a taker of side `action` with limit `limit` accepts `price`
+func isAcceptable(
+	action order.Action,
+	price int64,
+	limit int64,
+) bool {
+	if action == order.Bid {
+		return price <= limit
+	}
+
+	return price >= limit
+}
+
+// whether `price` is strictly better than `other` for a taker of side `action`
+func isBetter(
+	action order.Action,
+	price int64,
+	other int64,
+) bool {
+	if action == order.Bid {
+		return price < other
+	}
+
+	return price > other
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+
+	return q
+}
+
+func ceilDiv(a, b int64) int64 {
+	q := a / b
+
+	if (a%b != 0) && ((a < 0) == (b < 0)) {
+		q++
+	}
+
+	return q
+}
@@ -0,0 +1,300 @@
+package spread
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	riskengine "github.com/xerexchain/matching-engine/processor/risk_engine"
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+	"github.com/xerexchain/matching-engine/symbol"
+)
+
+const (
+	front    int32 = 1
+	back     int32 = 2
+	calendar int32 = 3 // front - back
+)
+
+type books struct {
+	books map[int32]*orderbook.Naive
+	marks *riskengine.Marks
+}
+
+func (b *books) Book(symbolID int32) (*orderbook.Naive, bool) {
+	book, ok := b.books[symbolID]
+
+	return book, ok
+}
+
+func (b *books) MarkPrice(symbolID int32) (*riskengine.MarkPrice, bool) {
+	return b.marks.Get(symbolID)
+}
+
+func newFuture(t *testing.T, symbolID int32) *symbol.FutureContract {
+	out := &bytes.Buffer{}
+
+	for _, code := range []int8{2, 1} {
+		if err := serialization.WriteInt8(code, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int32{symbolID, 1, 2} {
+		if err := serialization.WriteInt32(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []int64{1, 1, 0, 0, 10, 10, 0, 0} {
+		if err := serialization.WriteInt64(v, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := symbol.Unmarshal(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*symbol.FutureContract)
+}
+
+func gtc(
+	orderID int64,
+	userID int64,
+	symbolID int32,
+	action order.Action,
+	price int64,
+	quantity int64,
+) *order.Place {
+	return order.NewPlace(orderID, userID, price, quantity, price, symbolID, 0, action, order.GTC)
+}
+
+// Engine of the calendar spread, leg orders rest in the books.
+func newEngine(
+	t *testing.T,
+	resting ...*order.Place,
+) (*Engine, *books) {
+	b := &books{
+		books: map[int32]*orderbook.Naive{
+			front:    orderbook.NewNaive(newFuture(t, front)),
+			back:     orderbook.NewNaive(newFuture(t, back)),
+			calendar: orderbook.NewNaive(symbol.NewSpread(calendar, symbol.NewLeg(front, 1), symbol.NewLeg(back, -1))),
+		},
+		marks: riskengine.NewMarks(riskengine.DefaultMaxPremiumBP, riskengine.DefaultEMAPeriods),
+	}
+	settle := func(
+		symbolID int32,
+		timestampNS int64,
+		res *orderbook.MatcherResult,
+	) *orderbook.MatcherResult {
+		return res
+	}
+
+	for _, place := range resting {
+		if res := b.books[place.SymbolID()].PlaceGTC(place); res.Code != resultcode.Success {
+			t.Fatalf("resting order %v: %v", place.OrderID(), res.Code)
+		}
+	}
+
+	return NewEngine(b, settle), b
+}
+
+func TestValidate(t *testing.T) {
+	e, b := newEngine(t)
+	b.books[4] = orderbook.NewNaive(symbol.NewSpread(4, symbol.NewLeg(front, 1), symbol.NewLeg(back, -1)))
+
+	tests := []struct {
+		name string
+		legs []symbol.Leg
+		code resultcode.ResultCode
+	}{
+		{
+			name: "calendar",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1), symbol.NewLeg(back, -1)},
+			code: resultcode.Success,
+		},
+		{
+			name: "ratio spread",
+			legs: []symbol.Leg{symbol.NewLeg(front, 2), symbol.NewLeg(back, -1)},
+			code: resultcode.Success,
+		},
+		{
+			name: "one leg",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1)},
+			code: resultcode.InvalidSymbol,
+		},
+		{
+			name: "same leg twice",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1), symbol.NewLeg(front, -1)},
+			code: resultcode.InvalidSymbol,
+		},
+		{
+			name: "zero ratio",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1), symbol.NewLeg(back, 0)},
+			code: resultcode.InvalidSymbol,
+		},
+		{
+			name: "unknown leg",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1), symbol.NewLeg(9, -1)},
+			code: resultcode.InvalidSymbol,
+		},
+		{
+			name: "no leg of unit ratio",
+			legs: []symbol.Leg{symbol.NewLeg(front, 2), symbol.NewLeg(back, -2)},
+			code: resultcode.InvalidSymbol,
+		},
+		{
+			name: "spread leg",
+			legs: []symbol.Leg{symbol.NewLeg(front, 1), symbol.NewLeg(4, -1)},
+			code: resultcode.UnsupportedSymbolType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := e.Validate(symbol.NewSpread(5, test.legs...)); code != test.code {
+				t.Errorf("code %v, want %v", code, test.code)
+			}
+		})
+	}
+}
+
+func TestImplied(t *testing.T) {
+	e, _ := newEngine(
+		t,
+		gtc(1, 1, front, order.Bid, 100, 10),
+		gtc(2, 1, front, order.Ask, 102, 6),
+		gtc(3, 1, back, order.Bid, 95, 4),
+		gtc(4, 1, back, order.Ask, 97, 8),
+		gtc(5, 2, calendar, order.Bid, 5, 3),
+	)
+
+	// price, quantity
+	type quote [2]int64
+
+	tests := []struct {
+		name string
+		f    func() (int64, int64, bool)
+		want quote
+	}{
+		{
+			name: "out bid sells the front and buys the back",
+			f:    func() (int64, int64, bool) { return e.ImpliedOut(calendar, order.Bid) },
+			want: quote{3, 8},
+		},
+		{
+			name: "out ask",
+			f:    func() (int64, int64, bool) { return e.ImpliedOut(calendar, order.Ask) },
+			want: quote{7, 4},
+		},
+		{
+			name: "in bid of the front",
+			f:    func() (int64, int64, bool) { return e.ImpliedIn(calendar, front, order.Bid) },
+			want: quote{100, 3},
+		},
+		{
+			name: "in ask of the back",
+			f:    func() (int64, int64, bool) { return e.ImpliedIn(calendar, back, order.Ask) },
+			want: quote{97, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, quantity, ok := test.f()
+
+			if got := (quote{price, quantity}); !ok || got != test.want {
+				t.Errorf("%v (%v), want %v", got, ok, test.want)
+			}
+		})
+	}
+
+	if _, _, ok := e.ImpliedIn(calendar, front, order.Ask); ok {
+		t.Error("implied-in ask without a spread ask")
+	}
+}
+
+/*
+ * A spread ask matches the resting spread bid at 5:
+ * the back leg takes its reference price and the front leg the rest.
+ */
+func TestLegPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		resting []*order.Place
+		mark    int64 // index of the back leg, 0 if none
+		prices  map[int32]int64
+		reject  bool
+	}{
+		{
+			name:    "mid of the back",
+			resting: []*order.Place{gtc(1, 1, back, order.Bid, 80, 4), gtc(2, 1, back, order.Ask, 120, 8)},
+			prices:  map[int32]int64{front: 105, back: 100},
+		},
+		{
+			name:    "best price of the back",
+			resting: []*order.Place{gtc(1, 1, back, order.Ask, 120, 8)},
+			prices:  map[int32]int64{front: 125, back: 120},
+		},
+		{
+			name:    "mark of the back",
+			resting: []*order.Place{gtc(1, 1, back, order.Ask, 120, 8)},
+			mark:    90,
+			prices:  map[int32]int64{front: 95, back: 90},
+		},
+		{
+			name:   "back not priced",
+			reject: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, b := newEngine(t, append(test.resting, gtc(3, 2, calendar, order.Bid, 5, 3))...)
+
+			if test.mark != 0 {
+				b.marks.Update(back, test.mark, 0, 0)
+			}
+
+			book := b.books[calendar]
+			res := e.Place(book, gtc(4, 3, calendar, order.Ask, 5, 2))
+
+			if res.Code != resultcode.Success {
+				t.Fatalf("code %v", res.Code)
+			}
+
+			if test.reject {
+				if _, ok := res.Head.(*event.Reject); !ok || res.Head.Next() != nil {
+					t.Errorf("remainder crossing the spread book not rejected")
+				}
+
+				return
+			}
+
+			for symbolID, want := range test.prices {
+				trades := b.books[symbolID].Trades()
+
+				if len(trades) != 1 {
+					t.Fatalf("leg %v: %v trades, want 1", symbolID, len(trades))
+				}
+
+				trade := trades[0]
+
+				if trade.Price() != want || trade.Quantity() != 2 || trade.MakerUserID() != 2 || trade.TakerUserID() != 3 {
+					t.Errorf("leg %v: %v of %v at %v, want 2 of 2 to 3 at %v",
+						symbolID, trade.Quantity(), trade.MakerUserID(), trade.Price(), want)
+				}
+			}
+
+			if trades := book.Trades(); len(trades) != 1 || trades[0].TakerOrderID() != 4 {
+				t.Errorf("%v spread trades", len(trades))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"github.com/xerexchain/matching-engine/resultcode"
+)
+
+type QueryType int8
+
+const (
+	TapeQuery QueryType = iota + 1
+	CandlesQuery
+	TickerQuery
+)
+
+/*
+ * Report query of market statistics of a symbol.
+ * `interval` is used by candle queries only,
+ * `limit` by tape and candle queries (0 for all kept).
+ */
+type Query struct {
+	kind     QueryType
+	symbolID int32
+	interval int64
+	limit    int32
+	_        struct{}
+}
+
+func NewQuery(
+	kind QueryType,
+	symbolID int32,
+	interval int64,
+	limit int32,
+) *Query {
+	return &Query{
+		kind:     kind,
+		symbolID: symbolID,
+		interval: interval,
+		limit:    limit,
+	}
+}
+
+func (q *Query) Type() QueryType {
+	return q.kind
+}
+
+func (q *Query) SymbolID() int32 {
+	return q.symbolID
+}
+
+func (q *Query) Interval() int64 {
+	return q.interval
+}
+
+func (q *Query) Limit() int32 {
+	return q.limit
+}
+
+// Only the field of the query type is set.
+type Report struct {
+	code    resultcode.ResultCode
+	trades  []*Trade
+	candles []*Candle
+	ticker  *Ticker
+	_       struct{}
+}
+
+// Report of a failed query.
+func NewReport(code resultcode.ResultCode) *Report {
+	return &Report{
+		code: code,
+	}
+}
+
+func (r *Report) ResultCode() resultcode.ResultCode {
+	return r.code
+}
+
+func (r *Report) Trades() []*Trade {
+	return r.trades
+}
+
+func (r *Report) Candles() []*Candle {
+	return r.candles
+}
+
+func (r *Report) Ticker() *Ticker {
+	return r.ticker
+}
+
+func (s *Stats) Query(q *Query) *Report {
+	switch q.kind {
+	case TapeQuery:
+		return &Report{
+			code:   resultcode.Success,
+			trades: s.Tape(q.symbolID, q.limit),
+		}
+	case CandlesQuery:
+		candles, err := s.Candles(q.symbolID, q.interval, q.limit)
+
+		if err != nil {
+			return NewReport(resultcode.ReportQueryInvalidParameters)
+		}
+
+		return &Report{
+			code:    resultcode.Success,
+			candles: candles,
+		}
+	case TickerQuery:
+		return &Report{
+			code:   resultcode.Success,
+			ticker: s.Ticker(q.symbolID),
+		}
+	default:
+		return NewReport(resultcode.ReportQueryUnknownType)
+	}
+}
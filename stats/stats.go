@@ -0,0 +1,820 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+const (
+	Second int64 = 1000 * 1000 * 1000
+	Minute       = 60 * Second
+	Hour         = 60 * Minute
+	Day          = 24 * Hour
+)
+
+const (
+	// Trades kept per symbol.
+	DefaultTapeSize int32 = 1000
+
+	// Candles kept per symbol and interval.
+	DefaultCandlesSize int32 = 1000
+)
+
+var DefaultIntervals = []int64{
+	Second,
+	Minute,
+	5 * Minute,
+	15 * Minute,
+	Hour,
+	4 * Hour,
+	Day,
+}
+
+var (
+	ErrInterval = errors.New("interval")
+	ErrSize     = errors.New("size")
+)
+
+// Trade of the tape.
+type Trade struct {
+	tradeID     int64
+	timestampNS int64
+	price       int64
+	quantity    int64
+	takerAction order.Action
+	_           struct{}
+}
+
+func (t *Trade) TradeID() int64 {
+	return t.tradeID
+}
+
+func (t *Trade) TimestampNS() int64 {
+	return t.timestampNS
+}
+
+func (t *Trade) Price() int64 {
+	return t.price
+}
+
+func (t *Trade) Quantity() int64 {
+	return t.quantity
+}
+
+func (t *Trade) TakerAction() order.Action {
+	return t.takerAction
+}
+
+// OHLCV candle of the interval starting at `openTimeNS`.
+type Candle struct {
+	openTimeNS int64
+	open       int64
+	high       int64
+	low        int64
+	close      int64
+	volume     int64 // sum of quantities
+	turnover   int64 // sum of price * quantity
+	numTrades  int64
+	_          struct{}
+}
+
+func (c *Candle) OpenTimeNS() int64 {
+	return c.openTimeNS
+}
+
+func (c *Candle) Open() int64 {
+	return c.open
+}
+
+func (c *Candle) High() int64 {
+	return c.high
+}
+
+func (c *Candle) Low() int64 {
+	return c.low
+}
+
+func (c *Candle) Close() int64 {
+	return c.close
+}
+
+func (c *Candle) Volume() int64 {
+	return c.volume
+}
+
+func (c *Candle) Turnover() int64 {
+	return c.turnover
+}
+
+func (c *Candle) NumTrades() int64 {
+	return c.numTrades
+}
+
+// Volume weighted average price, rounded down.
+func (c *Candle) VWAP() int64 {
+	if c.volume == 0 {
+		return 0
+	}
+
+	return c.turnover / c.volume
+}
+
+func (c *Candle) add(t *Trade) {
+	if c.numTrades == 0 {
+		c.open = t.price
+		c.high = t.price
+		c.low = t.price
+	}
+
+	if t.price > c.high {
+		c.high = t.price
+	}
+
+	if t.price < c.low {
+		c.low = t.price
+	}
+
+	c.close = t.price
+	c.volume += t.quantity
+	c.turnover += t.price * t.quantity
+	c.numTrades++
+}
+
+/*
+ * 24h statistics of a symbol at `timestampNS`.
+ * The window is rolled at minute granularity:
+ * trades of the minute 24h ago are included.
+ * Open, high and low are 0 if no trade is within the window,
+ * last is the price of the last trade ever.
+ */
+type Ticker struct {
+	symbolID    int32
+	timestampNS int64
+	open        int64
+	high        int64
+	low         int64
+	last        int64
+	volume      int64
+	turnover    int64
+	numTrades   int64
+	_           struct{}
+}
+
+func (t *Ticker) SymbolID() int32 {
+	return t.symbolID
+}
+
+func (t *Ticker) TimestampNS() int64 {
+	return t.timestampNS
+}
+
+func (t *Ticker) Open() int64 {
+	return t.open
+}
+
+func (t *Ticker) High() int64 {
+	return t.high
+}
+
+func (t *Ticker) Low() int64 {
+	return t.low
+}
+
+func (t *Ticker) Last() int64 {
+	return t.last
+}
+
+func (t *Ticker) Volume() int64 {
+	return t.volume
+}
+
+func (t *Ticker) Turnover() int64 {
+	return t.turnover
+}
+
+func (t *Ticker) NumTrades() int64 {
+	return t.numTrades
+}
+
+// Volume weighted average price, rounded down.
+func (t *Ticker) VWAP() int64 {
+	if t.volume == 0 {
+		return 0
+	}
+
+	return t.turnover / t.volume
+}
+
+// Last price minus open price, 0 if no trade is within the window.
+func (t *Ticker) Change() int64 {
+	if t.numTrades == 0 {
+		return 0
+	}
+
+	return t.last - t.open
+}
+
+type symbolStats struct {
+	lastPrice int64
+	tape      []*Trade            // oldest first
+	candles   map[int64][]*Candle // interval -> candles, oldest first
+	minutes   []*Candle           // last 24h, for the ticker
+	_         struct{}
+}
+
+func newSymbolStats() *symbolStats {
+	return &symbolStats{
+		candles: make(map[int64][]*Candle),
+	}
+}
+
+/*
+ * Market statistics of symbols fed by trades of the books.
+ * Time is measured in command timestamps,
+ * so replaying the journal produces identical results.
+ * A timestamp older than the last one is accounted to the last candle.
+ */
+type Stats struct {
+	tapeSize    int32
+	candlesSize int32
+	intervals   []int64
+	timestampNS int64 // of the last command
+	symbols     map[int32]*symbolStats
+	_           struct{}
+}
+
+// Intervals are between a second and a day, sizes are positive.
+func New(
+	tapeSize int32,
+	candlesSize int32,
+	intervals []int64,
+) (*Stats, error) {
+	if tapeSize <= 0 || candlesSize <= 0 {
+		return nil, ErrSize
+	}
+
+	for _, interval := range intervals {
+		if interval < Second || interval > Day {
+			return nil, ErrInterval
+		}
+	}
+
+	return &Stats{
+		tapeSize:    tapeSize,
+		candlesSize: candlesSize,
+		intervals:   append([]int64(nil), intervals...),
+		symbols:     make(map[int32]*symbolStats),
+	}, nil
+}
+
+// Clears statistics, the configuration is kept.
+func (s *Stats) Reset() {
+	s.timestampNS = 0
+	s.symbols = make(map[int32]*symbolStats)
+}
+
+func (s *Stats) TimestampNS() int64 {
+	return s.timestampNS
+}
+
+// Called for every command, with trades of the symbol if any.
+func (s *Stats) Record(
+	symbolID int32,
+	timestampNS int64,
+	trades []*event.Trade,
+) {
+	if timestampNS > s.timestampNS {
+		s.timestampNS = timestampNS
+	}
+
+	if len(trades) == 0 {
+		return
+	}
+
+	ss, ok := s.symbols[symbolID]
+
+	if !ok {
+		ss = newSymbolStats()
+		s.symbols[symbolID] = ss
+	}
+
+	for _, trade := range trades {
+		takerAction := order.Bid
+
+		if trade.MakerAction() == order.Bid {
+			takerAction = order.Ask
+		}
+
+		t := &Trade{
+			tradeID:     trade.TradeID(),
+			timestampNS: timestampNS,
+			price:       trade.Price(),
+			quantity:    trade.Quantity(),
+			takerAction: takerAction,
+		}
+
+		ss.lastPrice = t.price
+		ss.tape = appendBounded(ss.tape, t, s.tapeSize)
+
+		for _, interval := range s.intervals {
+			ss.candles[interval] = addToCandles(ss.candles[interval], t, interval, s.candlesSize)
+		}
+
+		ss.minutes = addToCandles(ss.minutes, t, Minute, int32(Day/Minute)+1)
+	}
+}
+
+func appendBounded(
+	tape []*Trade,
+	t *Trade,
+	size int32,
+) []*Trade {
+	if int32(len(tape)) >= size {
+		tape = tape[1:]
+	}
+
+	return append(tape, t)
+}
+
+func addToCandles(
+	candles []*Candle,
+	t *Trade,
+	interval int64,
+	size int32,
+) []*Candle {
+	openTimeNS := t.timestampNS - t.timestampNS%interval
+
+	if len(candles) > 0 && candles[len(candles)-1].openTimeNS >= openTimeNS {
+		candles[len(candles)-1].add(t)
+
+		return candles
+	}
+
+	if int32(len(candles)) >= size {
+		candles = candles[1:]
+	}
+
+	c := &Candle{
+		openTimeNS: openTimeNS,
+	}
+	c.add(t)
+
+	return append(candles, c)
+}
+
+// Last `limit` trades (all kept if 0), oldest first.
+func (s *Stats) Tape(
+	symbolID int32,
+	limit int32,
+) []*Trade {
+	ss, ok := s.symbols[symbolID]
+
+	if !ok {
+		return nil
+	}
+
+	return ss.tape[from(len(ss.tape), limit):]
+}
+
+// Last `limit` candles (all kept if 0), oldest first.
+func (s *Stats) Candles(
+	symbolID int32,
+	interval int64,
+	limit int32,
+) ([]*Candle, error) {
+	if !s.hasInterval(interval) {
+		return nil, ErrInterval
+	}
+
+	ss, ok := s.symbols[symbolID]
+
+	if !ok {
+		return nil, nil
+	}
+
+	candles := ss.candles[interval]
+
+	return candles[from(len(candles), limit):], nil
+}
+
+func (s *Stats) hasInterval(interval int64) bool {
+	for _, v := range s.intervals {
+		if v == interval {
+			return true
+		}
+	}
+
+	return false
+}
+
+func from(size int, limit int32) int {
+	if limit <= 0 || int(limit) >= size {
+		return 0
+	}
+
+	return size - int(limit)
+}
+
+func (s *Stats) Ticker(symbolID int32) *Ticker {
+	ticker := &Ticker{
+		symbolID:    symbolID,
+		timestampNS: s.timestampNS,
+	}
+
+	ss, ok := s.symbols[symbolID]
+
+	if !ok {
+		return ticker
+	}
+
+	ticker.last = ss.lastPrice
+
+	for _, c := range ss.minutes {
+		if c.openTimeNS+Minute <= s.timestampNS-Day {
+			continue
+		}
+
+		if ticker.numTrades == 0 {
+			ticker.open = c.open
+			ticker.high = c.high
+			ticker.low = c.low
+		}
+
+		if c.high > ticker.high {
+			ticker.high = c.high
+		}
+
+		if c.low < ticker.low {
+			ticker.low = c.low
+		}
+
+		ticker.volume += c.volume
+		ticker.turnover += c.turnover
+		ticker.numTrades += c.numTrades
+	}
+
+	return ticker
+}
+
+func (s *Stats) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt32(s.tapeSize, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(s.candlesSize, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(s.intervals)), out); err != nil {
+		return err
+	}
+
+	for _, interval := range s.intervals {
+		if err := serialization.WriteInt64(interval, out); err != nil {
+			return err
+		}
+	}
+
+	if err := serialization.WriteInt64(s.timestampNS, out); err != nil {
+		return err
+	}
+
+	symbolIDs := make([]int32, 0, len(s.symbols))
+
+	for id := range s.symbols {
+		symbolIDs = append(symbolIDs, id)
+	}
+
+	sort.Slice(symbolIDs, func(i, j int) bool {
+		return symbolIDs[i] < symbolIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(symbolIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range symbolIDs {
+		if err := serialization.WriteInt32(id, out); err != nil {
+			return err
+		}
+
+		if err := s.symbols[id].marshal(s.intervals, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Stats) Unmarshal(in *bytes.Buffer) error {
+	tapeSize, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	candlesSize, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	intervals := make([]int64, 0, size)
+
+	for ; size > 0; size-- {
+		interval, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		intervals = append(intervals, interval)
+	}
+
+	timestampNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	symbols := make(map[int32]*symbolStats, size)
+
+	for ; size > 0; size-- {
+		id, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		ss, err := unmarshalSymbolStats(intervals, in)
+
+		if err != nil {
+			return err
+		}
+
+		symbols[id] = ss
+	}
+
+	s.tapeSize = tapeSize
+	s.candlesSize = candlesSize
+	s.intervals = intervals
+	s.timestampNS = timestampNS
+	s.symbols = symbols
+
+	return nil
+}
+
+func (ss *symbolStats) marshal(
+	intervals []int64,
+	out *bytes.Buffer,
+) error {
+	if err := serialization.WriteInt64(ss.lastPrice, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(ss.tape)), out); err != nil {
+		return err
+	}
+
+	for _, t := range ss.tape {
+		if err := t.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	for _, interval := range intervals {
+		if err := marshalCandles(ss.candles[interval], out); err != nil {
+			return err
+		}
+	}
+
+	return marshalCandles(ss.minutes, out)
+}
+
+func unmarshalSymbolStats(
+	intervals []int64,
+	in *bytes.Buffer,
+) (*symbolStats, error) {
+	lastPrice, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tape := make([]*Trade, 0, size)
+
+	for ; size > 0; size-- {
+		t := &Trade{}
+
+		if err := t.Unmarshal(in); err != nil {
+			return nil, err
+		}
+
+		tape = append(tape, t)
+	}
+
+	ss := newSymbolStats()
+
+	for _, interval := range intervals {
+		candles, err := unmarshalCandles(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(candles) > 0 {
+			ss.candles[interval] = candles
+		}
+	}
+
+	minutes, err := unmarshalCandles(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ss.lastPrice = lastPrice
+	ss.tape = tape
+	ss.minutes = minutes
+
+	return ss, nil
+}
+
+func marshalCandles(
+	candles []*Candle,
+	out *bytes.Buffer,
+) error {
+	if err := serialization.WriteInt32(int32(len(candles)), out); err != nil {
+		return err
+	}
+
+	for _, c := range candles {
+		if err := c.Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalCandles(in *bytes.Buffer) ([]*Candle, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	candles := make([]*Candle, 0, size)
+
+	for ; size > 0; size-- {
+		c := &Candle{}
+
+		if err := c.Unmarshal(in); err != nil {
+			return nil, err
+		}
+
+		candles = append(candles, c)
+	}
+
+	return candles, nil
+}
+
+func (t *Trade) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt64(t.tradeID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(t.timestampNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(t.price, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(t.quantity, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt8(int8(t.takerAction), out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *Trade) Unmarshal(in *bytes.Buffer) error {
+	tradeID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	timestampNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	price, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	quantity, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	code, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	takerAction, ok := order.ActionFrom(code)
+
+	if !ok {
+		return fmt.Errorf("Trade.Unmarshal: action: %v", code)
+	}
+
+	t.tradeID = tradeID
+	t.timestampNS = timestampNS
+	t.price = price
+	t.quantity = quantity
+	t.takerAction = takerAction
+
+	return nil
+}
+
+func (c *Candle) Marshal(out *bytes.Buffer) error {
+	fields := []int64{
+		c.openTimeNS,
+		c.open,
+		c.high,
+		c.low,
+		c.close,
+		c.volume,
+		c.turnover,
+		c.numTrades,
+	}
+
+	for _, f := range fields {
+		if err := serialization.WriteInt64(f, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Candle) Unmarshal(in *bytes.Buffer) error {
+	var fields [8]int64
+
+	for i := range fields {
+		f, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		fields[i] = f
+	}
+
+	c.openTimeNS = fields[0]
+	c.open = fields[1]
+	c.high = fields[2]
+	c.low = fields[3]
+	c.close = fields[4]
+	c.volume = fields[5]
+	c.turnover = fields[6]
+	c.numTrades = fields[7]
+
+	return nil
+}
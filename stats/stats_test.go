@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/xerexchain/matching-engine/order"
+	"github.com/xerexchain/matching-engine/orderbook/event"
+)
+
+const symbolID int32 = 1
+
+func trade(
+	tradeID int64,
+	price int64,
+	quantity int64,
+) *event.Trade {
+	return event.NewTrade(symbolID, tradeID, 1, 1, 2, 2, false, false, order.Ask, 10, 10, price, quantity, price)
+}
+
+func TestCandles(t *testing.T) {
+	// open time, open, high, low, close, volume, number of trades
+	type candle [7]int64
+
+	s, err := New(DefaultTapeSize, 2, []int64{Minute})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Record(symbolID, 10*Second, []*event.Trade{trade(1, 100, 1), trade(2, 105, 2)})
+	s.Record(symbolID, 50*Second, []*event.Trade{trade(3, 95, 1)})
+	// rolls over to the next minute
+	s.Record(symbolID, Minute, []*event.Trade{trade(4, 98, 3)})
+	// older than the last command, accounted to the last candle
+	s.Record(symbolID, 30*Second, []*event.Trade{trade(5, 99, 1)})
+
+	want := []candle{
+		{0, 100, 105, 95, 95, 4, 3},
+		{Minute, 98, 99, 98, 99, 4, 2},
+	}
+
+	candles, err := s.Candles(symbolID, Minute, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(candles) != len(want) {
+		t.Fatalf("%v candles, want %v", len(candles), len(want))
+	}
+
+	for i, c := range candles {
+		got := candle{c.OpenTimeNS(), c.Open(), c.High(), c.Low(), c.Close(), c.Volume(), c.NumTrades()}
+
+		if got != want[i] {
+			t.Errorf("candle %v: %v, want %v", i, got, want[i])
+		}
+	}
+
+	// a gap rolls the oldest candle out
+	s.Record(symbolID, 5*Minute+Second, []*event.Trade{trade(6, 90, 1)})
+	candles, _ = s.Candles(symbolID, Minute, 0)
+
+	if len(candles) != 2 || candles[0].OpenTimeNS() != Minute || candles[1].OpenTimeNS() != 5*Minute {
+		t.Errorf("candles after a gap: %v", len(candles))
+	}
+
+	if _, err := s.Candles(symbolID, Hour, 0); err != ErrInterval {
+		t.Errorf("unknown interval: %v, want %v", err, ErrInterval)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	s, err := New(DefaultTapeSize, DefaultCandlesSize, DefaultIntervals)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Record(symbolID, Hour, []*event.Trade{trade(1, 100, 2)})
+	s.Record(symbolID, 2*Hour, []*event.Trade{trade(2, 120, 1), trade(3, 110, 1)})
+
+	ticker := s.Ticker(symbolID)
+
+	if ticker.Open() != 100 || ticker.High() != 120 || ticker.Low() != 100 || ticker.Last() != 110 {
+		t.Errorf("ticker %v/%v/%v/%v, want 100/120/100/110", ticker.Open(), ticker.High(), ticker.Low(), ticker.Last())
+	}
+
+	if ticker.Volume() != 4 || ticker.NumTrades() != 3 {
+		t.Errorf("volume %v of %v trades, want 4 of 3", ticker.Volume(), ticker.NumTrades())
+	}
+
+	// the trade of the first hour leaves the window
+	s.Record(symbolID, Day+Hour+Minute, nil)
+	ticker = s.Ticker(symbolID)
+
+	if ticker.Open() != 120 || ticker.Low() != 110 || ticker.Volume() != 2 || ticker.Last() != 110 {
+		t.Errorf("rolled ticker open %v, low %v, volume %v, last %v", ticker.Open(), ticker.Low(), ticker.Volume(), ticker.Last())
+	}
+
+	s.Record(symbolID, 3*Day, nil)
+
+	if ticker := s.Ticker(symbolID); ticker.NumTrades() != 0 || ticker.Open() != 0 || ticker.Last() != 110 {
+		t.Errorf("ticker without trades: %v trades, open %v, last %v", ticker.NumTrades(), ticker.Open(), ticker.Last())
+	}
+}
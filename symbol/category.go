@@ -6,6 +6,8 @@ const (
 	_currencyExchangePair Category = iota + 1
 	_futureContract
 	_option
+	_spread
+	_perpetualSwap
 )
 
 var (
@@ -13,6 +15,8 @@ var (
 		int8(_currencyExchangePair): _currencyExchangePair,
 		int8(_futureContract):       _futureContract,
 		int8(_option):               _option,
+		int8(_spread):               _spread,
+		int8(_perpetualSwap):        _perpetualSwap,
 	}
 
 	_factory = map[int8]func() _Symbol{
@@ -26,6 +30,12 @@ var (
 			// TODO panic?
 			panic("not implemented")
 		},
+		int8(_spread): func() _Symbol {
+			return &Spread{}
+		},
+		int8(_perpetualSwap): func() _Symbol {
+			return &PerpetualSwap{}
+		},
 	}
 )
 
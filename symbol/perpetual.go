@@ -0,0 +1,124 @@
+package symbol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+/*
+ * Future contract without expiry.
+ * Longs and shorts exchange funding payments every `fundingIntervalNS`
+ * in the quote currency, at a rate capped by `maxFundingRate`
+ * (parts per million of the position value per interval).
+ */
+type PerpetualSwap struct {
+	future            FutureContract
+	fundingIntervalNS int64
+	maxFundingRate    int64
+	_                 struct{}
+}
+
+func NewPerpetualSwap(
+	future *FutureContract,
+	fundingIntervalNS int64,
+	maxFundingRate int64,
+) *PerpetualSwap {
+	return &PerpetualSwap{
+		future:            *future,
+		fundingIntervalNS: fundingIntervalNS,
+		maxFundingRate:    maxFundingRate,
+	}
+}
+
+func (p *PerpetualSwap) ID() int32 {
+	return p.future.ID()
+}
+
+// Margin parameters of the swap.
+func (p *PerpetualSwap) Future() FutureContract {
+	return p.future
+}
+
+// Currency of funding payments.
+func (p *PerpetualSwap) SettlementCurrency() int32 {
+	return p.future.QuoteCurrency()
+}
+
+func (p *PerpetualSwap) FundingIntervalNS() int64 {
+	return p.fundingIntervalNS
+}
+
+func (p *PerpetualSwap) MaxFundingRate() int64 {
+	return p.maxFundingRate
+}
+
+// TODO unexported fields
+// TODO remove panic?
+func (p *PerpetualSwap) Hash() uint64 {
+	hash, err := hashstructure.Hash(*p, hashstructure.FormatV2, nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+func (p *PerpetualSwap) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt8(int8(_perpetualSwap), out); err != nil {
+		return err
+	}
+
+	if err := p.future.Marshal(out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.fundingIntervalNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.maxFundingRate, out); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *PerpetualSwap) Unmarshal(in *bytes.Buffer) error {
+	code, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	if Category(code) != _perpetualSwap {
+		return fmt.Errorf("PerpetualSwap.Unmarshal: category: %v", code)
+	}
+
+	future := &FutureContract{}
+
+	if err := future.Unmarshal(in); err != nil {
+		return err
+	}
+
+	fundingIntervalNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	maxFundingRate, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	p.future = *future
+	p.fundingIntervalNS = fundingIntervalNS
+	p.maxFundingRate = maxFundingRate
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package symbol
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// A leg of a spread.
+// Positive `ratio` buys the leg when the spread is bought,
+// negative `ratio` sells it.
+type Leg struct {
+	symbolID int32
+	ratio    int64
+	_        struct{}
+}
+
+func NewLeg(
+	symbolID int32,
+	ratio int64,
+) Leg {
+	return Leg{
+		symbolID: symbolID,
+		ratio:    ratio,
+	}
+}
+
+func (l Leg) SymbolID() int32 {
+	return l.symbolID
+}
+
+func (l Leg) Ratio() int64 {
+	return l.ratio
+}
+
+/*
+ * Spread instrument defined as legs over existing symbols,
+ * e.g. a futures calendar spread: buy the near contract, sell the far one.
+ * Price of one spread unit is the sum of `ratio * leg price`.
+ */
+type Spread struct {
+	id   int32
+	legs []Leg
+	_    struct{}
+}
+
+func NewSpread(
+	id int32,
+	legs ...Leg,
+) *Spread {
+	return &Spread{
+		id:   id,
+		legs: legs,
+	}
+}
+
+func (s *Spread) ID() int32 {
+	return s.id
+}
+
+func (s *Spread) Legs() []Leg {
+	return s.legs
+}
+
+// TODO remove panic?
+func (s *Spread) Hash() uint64 {
+	hash, err := hashstructure.Hash(*s, hashstructure.FormatV2, nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+func (s *Spread) Marshal(out *bytes.Buffer) error {
+	if err := serialization.WriteInt8(int8(_spread), out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(s.id, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt32(int32(len(s.legs)), out); err != nil {
+		return err
+	}
+
+	for _, leg := range s.legs {
+		if err := serialization.WriteInt32(leg.symbolID, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(leg.ratio, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Spread) Unmarshal(in *bytes.Buffer) error {
+	code, err := serialization.ReadInt8(in)
+
+	if err != nil {
+		return err
+	}
+
+	if Category(code) != _spread {
+		return fmt.Errorf("Spread.Unmarshal: category: %v", code)
+	}
+
+	id, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	legs := make([]Leg, 0, size)
+
+	for ; size > 0; size-- {
+		symbolID, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return err
+		}
+
+		ratio, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		legs = append(legs, NewLeg(symbolID, ratio))
+	}
+
+	s.id = id
+	s.legs = legs
+
+	return nil
+}
@@ -35,6 +35,14 @@ func (s *Symbol) ID() int32 {
 	return s.id
 }
 
+func (s *Symbol) BaseCurrency() int32 {
+	return s.baseCurrency
+}
+
+func (s *Symbol) QuoteCurrency() int32 {
+	return s.quoteCurrency
+}
+
 // TODO unexported fields
 // TODO remove panic?
 func (s *Symbol) Hash() uint64 {
@@ -154,13 +162,22 @@ type FutureContract struct {
 	symbol     Symbol
 	marginBuy  int64 // quote currency
 	marginSell int64 // quote currency
-	_          struct{}
+
+	// 0 if the contract does not expire
+	expiryNS      int64
+	lastTradingNS int64 // new orders are rejected from this time
+	_             struct{}
 }
 
 func (s *FutureContract) ID() int32 {
 	return s.symbol.ID()
 }
 
+// Currency of margin and profit.
+func (f *FutureContract) QuoteCurrency() int32 {
+	return f.symbol.QuoteCurrency()
+}
+
 func (f *FutureContract) MarginBuy() int64 {
 	return f.marginBuy
 }
@@ -169,6 +186,22 @@ func (f *FutureContract) MarginSell() int64 {
 	return f.marginSell
 }
 
+func (f *FutureContract) ExpiryNS() int64 {
+	return f.expiryNS
+}
+
+func (f *FutureContract) LastTradingNS() int64 {
+	return f.lastTradingNS
+}
+
+func (f *FutureContract) IsTradingClosed(timestampNS int64) bool {
+	return f.lastTradingNS != 0 && timestampNS >= f.lastTradingNS
+}
+
+func (f *FutureContract) IsExpired(timestampNS int64) bool {
+	return f.expiryNS != 0 && timestampNS >= f.expiryNS
+}
+
 // TODO unexported fields
 // TODO remove panic?
 func (f *FutureContract) Hash() uint64 {
@@ -198,6 +231,14 @@ func (f *FutureContract) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := serialization.WriteInt64(f.expiryNS, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(f.lastTradingNS, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -230,9 +271,23 @@ func (f *FutureContract) Unmarshal(in *bytes.Buffer) error {
 		return err
 	}
 
+	expiryNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	lastTradingNS, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
 	f.symbol = *symbol_
 	f.marginBuy = marginBuy
 	f.marginSell = marginSell
+	f.expiryNS = expiryNS
+	f.lastTradingNS = lastTradingNS
 
 	return nil
 }
@@ -257,6 +312,11 @@ func Unmarshal(in *bytes.Buffer) (_Symbol, error) {
 		return nil, fmt.Errorf("Unmarshal: category: %v", code)
 	}
 
+	// category is read again by the concrete symbol
+	if err := in.UnreadByte(); err != nil {
+		return nil, err
+	}
+
 	f := _factory[code]
 	symbol_ := f()
 
@@ -3,9 +3,11 @@ package user
 import (
 	"bytes"
 	"fmt"
+	"sort"
 
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/xerexchain/matching-engine/position"
+	"github.com/xerexchain/matching-engine/resultcode"
 	"github.com/xerexchain/matching-engine/serialization"
 )
 
@@ -21,7 +23,16 @@ type Profile struct {
 
 	// currency -> balance
 	balances map[int32]int64
-	_        struct{}
+
+	// symbolID -> funding received, negative if paid
+	funding map[int32]int64
+
+	// master account of a sub-account, 0 if there is none
+	parentID int64
+
+	// balances of the parent count for margin of the sub-account
+	sharedMargin bool
+	_            struct{}
 }
 
 func NewProfile(userID int64, status Status) *Profile {
@@ -30,7 +41,100 @@ func NewProfile(userID int64, status Status) *Profile {
 		status:          status,
 		balances:        make(map[int32]int64),
 		marginPositions: make(map[int32]*position.Margin),
+		funding:         make(map[int32]int64),
+	}
+}
+
+func (p *Profile) UserID() int64 {
+	return p.userID
+}
+
+func (p *Profile) ParentID() int64 {
+	return p.parentID
+}
+
+func (p *Profile) IsSubAccount() bool {
+	return p.parentID != 0
+}
+
+func (p *Profile) SharedMargin() bool {
+	return p.sharedMargin
+}
+
+func (p *Profile) AdjustmentsCounter() int64 {
+	return p.adjustmentsCounter
+}
+
+// Transaction ids of adjustments must increase.
+func (p *Profile) SetAdjustmentsCounter(txid int64) {
+	p.adjustmentsCounter = txid
+}
+
+/*
+ * Checks an adjustment of the balance by `amount` (negative for withdrawals),
+ * `txid` of an applied adjustment is rejected.
+ * Withdrawals are limited by `free`, the balance not required as margin.
+ */
+func (p *Profile) ValidateAdjustment(
+	amount int64,
+	txid int64,
+	free int64,
+) resultcode.ResultCode {
+	if amount == 0 {
+		return resultcode.UserMGMTAccountBalanceAdjustmentZero
+	}
+
+	// double adjustment protection
+	if p.adjustmentsCounter == txid {
+		return resultcode.UserMGMTAccountBalanceAdjustmentAlreadyAppliedSame
+	}
+
+	if p.adjustmentsCounter > txid {
+		return resultcode.UserMGMTAccountBalanceAdjustmentAlreadyAppliedMany
 	}
+
+	if amount < 0 && free+amount < 0 {
+		return resultcode.UserMGMTAccountBalanceAdjustmentNSF
+	}
+
+	return resultcode.Success
+}
+
+func (p *Profile) Balance(currency int32) int64 {
+	return p.balances[currency]
+}
+
+// Balance changes are journaled by the user engine, see `ledger.Ledger`.
+// TODO NSF check
+func (p *Profile) AddBalance(
+	currency int32,
+	amount int64,
+) {
+	p.balances[currency] += amount
+}
+
+// Records a funding payment of a perpetual swap, negative if paid.
+// The balance is changed separately, see `AddBalance`.
+func (p *Profile) AddFunding(
+	symbolID int32,
+	amount int64,
+) {
+	p.funding[symbolID] += amount
+}
+
+func (p *Profile) HasPositions() bool {
+	return len(p.marginPositions) > 0
+}
+
+// false if all balances are zero
+func (p *Profile) HasBalances() bool {
+	for _, balance := range p.balances {
+		if balance != 0 {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (p *Profile) MarginPositionOf(
@@ -41,6 +145,51 @@ func (p *Profile) MarginPositionOf(
 	return position_, ok
 }
 
+// Symbols of margin positions, sorted.
+func (p *Profile) MarginSymbolIDs() []int32 {
+	ids := make([]int32, 0, len(p.marginPositions))
+
+	for id := range p.marginPositions {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+func (p *Profile) MarginPositionOrNew(
+	symbolID int32,
+	currency int32,
+) *position.Margin {
+	position_, ok := p.marginPositions[symbolID]
+
+	if !ok {
+		position_ = position.NewMargin(p.userID, symbolID, currency)
+		p.marginPositions[symbolID] = position_
+	}
+
+	return position_
+}
+
+/*
+ * Removes the position if empty, returns its currency and realized profit.
+ * The profit is to be moved to the balance, see `AddBalance`.
+ */
+func (p *Profile) RemoveIfEmpty(symbolID int32) (int32, int64, bool) {
+	position_, ok := p.marginPositions[symbolID]
+
+	if !ok || !position_.IsEmpty() {
+		return 0, 0, false
+	}
+
+	delete(p.marginPositions, symbolID)
+
+	return position_.Currency(), position_.Profit(), true
+}
+
 // TODO This is not equal to java stateHash.
 // TODO unexported fields
 // TODO panic?
@@ -99,6 +248,18 @@ func (p *Profile) Marshal(out *bytes.Buffer) error {
 		return err
 	}
 
+	if err := marshalSorted(p.funding, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteInt64(p.parentID, out); err != nil {
+		return err
+	}
+
+	if err := serialization.WriteBool(p.sharedMargin, out); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -177,11 +338,91 @@ func (p *Profile) Unmarshal(in *bytes.Buffer) error {
 		return fmt.Errorf("Profile.Unmarshal: status: %v", code)
 	}
 
+	funding, err := unmarshalInt64s(in)
+
+	if err != nil {
+		return err
+	}
+
+	parentID, err := serialization.ReadInt64(in)
+
+	if err != nil {
+		return err
+	}
+
+	sharedMargin, err := serialization.ReadBool(in)
+
+	if err != nil {
+		return err
+	}
+
 	p.userID = userID
 	p.marginPositions = marginPositions
 	p.adjustmentsCounter = adjustmentsCounter
 	p.balances = balances
 	p.status = status
+	p.funding = funding
+	p.parentID = parentID
+	p.sharedMargin = sharedMargin
 
 	return nil
 }
+
+func marshalSorted(
+	m map[int32]int64,
+	out *bytes.Buffer,
+) error {
+	keys := make([]int32, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(keys)), out); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := serialization.WriteInt32(k, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(m[k], out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshalInt64s(in *bytes.Buffer) (map[int32]int64, error) {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int32]int64, size)
+
+	for ; size > 0; size-- {
+		k, err := serialization.ReadInt32(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m[k] = v
+	}
+
+	return m, nil
+}
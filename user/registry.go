@@ -0,0 +1,401 @@
+package user
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/xerexchain/matching-engine/resultcode"
+	"github.com/xerexchain/matching-engine/serialization"
+)
+
+// Balances of a user, including funding received per symbol.
+type BalanceReport struct {
+	userID   int64
+	balances map[int32]int64 // currency -> balance
+	funding  map[int32]int64 // symbolID -> funding received
+	_        struct{}
+}
+
+func (r *BalanceReport) UserID() int64 {
+	return r.userID
+}
+
+func (r *BalanceReport) Balances() map[int32]int64 {
+	return r.balances
+}
+
+func (r *BalanceReport) Funding() map[int32]int64 {
+	return r.funding
+}
+
+func (p *Profile) BalanceReport() *BalanceReport {
+	balances := make(map[int32]int64, len(p.balances))
+	funding := make(map[int32]int64, len(p.funding))
+
+	for currency, balance := range p.balances {
+		balances[currency] = balance
+	}
+
+	for symbolID, amount := range p.funding {
+		funding[symbolID] = amount
+	}
+
+	return &BalanceReport{
+		userID:   p.userID,
+		balances: balances,
+		funding:  funding,
+	}
+}
+
+/*
+ * Profiles of active users. Suspended users have neither balances
+ * nor positions, their profiles are evicted and only
+ * the adjustments counter and the parent are kept until resumption.
+ */
+type Registry struct {
+	profiles  map[int64]*Profile
+	suspended map[int64]*suspendedUser
+
+	// users with the admin role (operators), granted by the admin API only
+	admins map[int64]struct{}
+	_      struct{}
+}
+
+// Compact state of a suspended profile.
+type suspendedUser struct {
+	adjustmentsCounter int64
+	parentID           int64
+	sharedMargin       bool
+	_                  struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		profiles:  make(map[int64]*Profile),
+		suspended: make(map[int64]*suspendedUser),
+		admins:    make(map[int64]struct{}),
+	}
+}
+
+func (r *Registry) AddUser(userID int64) resultcode.ResultCode {
+	if r.exists(userID) {
+		return resultcode.UserMGMTUserAlreadyExists
+	}
+
+	r.profiles[userID] = NewProfile(userID, _active)
+
+	return resultcode.Success
+}
+
+// Sub-accounts have one level, the parent must be an active master account.
+func (r *Registry) AddSubAccount(
+	userID int64,
+	parentID int64,
+	sharedMargin bool,
+) resultcode.ResultCode {
+	if r.exists(userID) {
+		return resultcode.UserMGMTUserAlreadyExists
+	}
+
+	parent, ok := r.profiles[parentID]
+
+	if !ok || parent.IsSubAccount() {
+		return resultcode.UserMGMTInvalidParent
+	}
+
+	profile := NewProfile(userID, _active)
+	profile.parentID = parentID
+	profile.sharedMargin = sharedMargin
+	r.profiles[userID] = profile
+
+	return resultcode.Success
+}
+
+// Active sub-accounts of the master account, sorted.
+func (r *Registry) SubAccounts(parentID int64) []int64 {
+	var ids []int64
+
+	for _, id := range r.UserIDs() {
+		if r.profiles[id].parentID == parentID {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (r *Registry) SuspendUser(userID int64) resultcode.ResultCode {
+	if _, ok := r.suspended[userID]; ok {
+		return resultcode.UserMGMTUserAlreadySuspended
+	}
+
+	profile, ok := r.profiles[userID]
+
+	if !ok {
+		return resultcode.UserMGMTUserNotFound
+	}
+
+	if profile.HasPositions() {
+		return resultcode.UserMGMTUserNotSuspendableHasPositions
+	}
+
+	if len(r.SubAccounts(userID)) > 0 {
+		return resultcode.UserMGMTUserNotSuspendableHasSubAccounts
+	}
+
+	if profile.HasBalances() {
+		return resultcode.UserMGMTUserNotSuspendableNonEmptyAccounts
+	}
+
+	delete(r.profiles, userID)
+	r.suspended[userID] = &suspendedUser{
+		adjustmentsCounter: profile.adjustmentsCounter,
+		parentID:           profile.parentID,
+		sharedMargin:       profile.sharedMargin,
+	}
+
+	return resultcode.Success
+}
+
+func (r *Registry) ResumeUser(userID int64) resultcode.ResultCode {
+	suspended, ok := r.suspended[userID]
+
+	if !ok {
+		if _, ok := r.profiles[userID]; ok {
+			return resultcode.UserMGMTUserNotSuspended
+		}
+
+		return resultcode.UserMGMTUserNotFound
+	}
+
+	profile := NewProfile(userID, _active)
+	profile.adjustmentsCounter = suspended.adjustmentsCounter
+	profile.parentID = suspended.parentID
+	profile.sharedMargin = suspended.sharedMargin
+	r.profiles[userID] = profile
+	delete(r.suspended, userID)
+
+	return resultcode.Success
+}
+
+func (r *Registry) exists(userID int64) bool {
+	_, active := r.profiles[userID]
+	_, suspended := r.suspended[userID]
+
+	return active || suspended
+}
+
+func (r *Registry) IsSuspended(userID int64) bool {
+	_, ok := r.suspended[userID]
+
+	return ok
+}
+
+/*
+ * Grants or revokes the admin role, `issuerID` must be an admin.
+ * The first admin is granted by any issuer.
+ */
+func (r *Registry) SetAdmin(
+	issuerID int64,
+	userID int64,
+	admin bool,
+) resultcode.ResultCode {
+	if len(r.admins) > 0 && !r.IsAdmin(issuerID) {
+		return resultcode.AuthNotAdmin
+	}
+
+	if !r.exists(userID) {
+		return resultcode.UserMGMTUserNotFound
+	}
+
+	if admin {
+		r.admins[userID] = struct{}{}
+	} else {
+		delete(r.admins, userID)
+	}
+
+	return resultcode.Success
+}
+
+// Suspended admins lose the role until resumption.
+func (r *Registry) IsAdmin(userID int64) bool {
+	_, ok := r.admins[userID]
+
+	return ok && !r.IsSuspended(userID)
+}
+
+// Profile of an active user.
+func (r *Registry) Get(userID int64) (*Profile, bool) {
+	profile, ok := r.profiles[userID]
+
+	return profile, ok
+}
+
+// Active users, sorted for deterministic processing.
+func (r *Registry) UserIDs() []int64 {
+	ids := make([]int64, 0, len(r.profiles))
+
+	for id := range r.profiles {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+func (r *Registry) Marshal(out *bytes.Buffer) error {
+	userIDs := r.UserIDs()
+
+	if err := serialization.WriteInt32(int32(len(userIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if err := r.profiles[id].Marshal(out); err != nil {
+			return err
+		}
+	}
+
+	suspendedIDs := make([]int64, 0, len(r.suspended))
+
+	for id := range r.suspended {
+		suspendedIDs = append(suspendedIDs, id)
+	}
+
+	sort.Slice(suspendedIDs, func(i, j int) bool {
+		return suspendedIDs[i] < suspendedIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(suspendedIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range suspendedIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+
+		suspended := r.suspended[id]
+
+		if err := serialization.WriteInt64(suspended.adjustmentsCounter, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteInt64(suspended.parentID, out); err != nil {
+			return err
+		}
+
+		if err := serialization.WriteBool(suspended.sharedMargin, out); err != nil {
+			return err
+		}
+	}
+
+	adminIDs := make([]int64, 0, len(r.admins))
+
+	for id := range r.admins {
+		adminIDs = append(adminIDs, id)
+	}
+
+	sort.Slice(adminIDs, func(i, j int) bool {
+		return adminIDs[i] < adminIDs[j]
+	})
+
+	if err := serialization.WriteInt32(int32(len(adminIDs)), out); err != nil {
+		return err
+	}
+
+	for _, id := range adminIDs {
+		if err := serialization.WriteInt64(id, out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) Unmarshal(in *bytes.Buffer) error {
+	size, err := serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	profiles := make(map[int64]*Profile, size)
+
+	for ; size > 0; size-- {
+		profile := &Profile{}
+
+		if err := profile.Unmarshal(in); err != nil {
+			return err
+		}
+
+		profiles[profile.userID] = profile
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	suspended := make(map[int64]*suspendedUser, size)
+
+	for ; size > 0; size-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		adjustmentsCounter, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		parentID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		sharedMargin, err := serialization.ReadBool(in)
+
+		if err != nil {
+			return err
+		}
+
+		suspended[userID] = &suspendedUser{
+			adjustmentsCounter: adjustmentsCounter,
+			parentID:           parentID,
+			sharedMargin:       sharedMargin,
+		}
+	}
+
+	size, err = serialization.ReadInt32(in)
+
+	if err != nil {
+		return err
+	}
+
+	admins := make(map[int64]struct{}, size)
+
+	for ; size > 0; size-- {
+		userID, err := serialization.ReadInt64(in)
+
+		if err != nil {
+			return err
+		}
+
+		admins[userID] = struct{}{}
+	}
+
+	r.profiles = profiles
+	r.suspended = suspended
+	r.admins = admins
+
+	return nil
+}
@@ -12,6 +12,11 @@ var _int8ToBalanceAdjCategory = map[int8]BalanceAdjCategory{
 	int8(_suspend):    _suspend,
 }
 
+// Balances of profiles are moved out before suspension and back on resumption.
+func (c BalanceAdjCategory) IsSuspend() bool {
+	return c == _suspend
+}
+
 func BalanceAdjCategoryFrom(code int8) (BalanceAdjCategory, bool) {
 	val, ok := _int8ToBalanceAdjCategory[code]
 